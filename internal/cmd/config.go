@@ -0,0 +1,77 @@
+package cmd
+
+import (
+    "errors"
+    "fmt"
+    "os"
+
+    "hermetica/internal/config"
+    "github.com/spf13/cobra"
+    "gopkg.in/yaml.v3"
+)
+
+var showResolved bool
+
+var configCmd = &cobra.Command{
+    Use:   "config",
+    Short: "Inspect and validate configuration",
+}
+
+var configValidateCmd = &cobra.Command{
+    Use:   "validate",
+    Short: "Validate the config file and report every problem",
+    RunE: func(cmd *cobra.Command, args []string) error {
+        cfg, err := config.Load(cfgPath)
+        if err != nil {
+            return err
+        }
+        if err := cfg.Validate(); err != nil {
+            var verr config.ValidationError
+            if !errors.As(err, &verr) {
+                return err
+            }
+            for _, fe := range verr {
+                if fe.Source == "" {
+                    fe.Source = cfgPath
+                }
+                fmt.Println(fe.Error())
+            }
+            return fmt.Errorf("%s: %d problem(s)", cfgPath, len(verr))
+        }
+        fmt.Printf("%s: ok\n", cfgPath)
+        return nil
+    },
+}
+
+var configShowCmd = &cobra.Command{
+    Use:   "show",
+    Short: "Print the config file, or the effective merged config with --resolved",
+    RunE: func(cmd *cobra.Command, args []string) error {
+        if !showResolved {
+            b, err := os.ReadFile(cfgPath)
+            if err != nil {
+                return err
+            }
+            _, err = os.Stdout.Write(b)
+            return err
+        }
+        cfg, err := config.Load(cfgPath)
+        if err != nil {
+            return err
+        }
+        node, err := cfg.Annotated()
+        if err != nil {
+            return err
+        }
+        enc := yaml.NewEncoder(os.Stdout)
+        enc.SetIndent(2)
+        defer enc.Close()
+        return enc.Encode(node)
+    },
+}
+
+func init() {
+    configShowCmd.Flags().BoolVar(&showResolved, "resolved", false, "Print the merged config with the origin of each value")
+    configCmd.AddCommand(configValidateCmd)
+    configCmd.AddCommand(configShowCmd)
+}
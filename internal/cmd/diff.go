@@ -0,0 +1,184 @@
+package cmd
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "slices"
+    "time"
+
+    "hermetica/internal/config"
+    "hermetica/internal/report"
+    "hermetica/internal/store"
+    "github.com/spf13/cobra"
+)
+
+var (
+    diffFormat string
+    diffOut    string
+)
+
+var diffCmd = &cobra.Command{
+    Use:   "diff <from> <to>",
+    Short: "Show what changed between two runs",
+    Long: `Compare two runs: new and removed subdomains, IP changes per FQDN,
+opened and closed ports, and web targets whose status, title, tech, TLS
+issuer or body hash changed.
+
+Each side is a run ID (see "hermetica diff" without arguments or run_id in
+run.meta.json), a target directory such as work/example.com/ (its last run),
+a work directory holding hermetica.sqlite, or a database snapshot file.
+Every run leaves a snapshot in <workdir>/snapshots/<run_id>.sqlite.`,
+    Args: func(cmd *cobra.Command, args []string) error {
+        if len(args) != 0 && len(args) != 2 {
+            return fmt.Errorf("want two runs to compare, or none to list runs")
+        }
+        return nil
+    },
+    RunE: func(cmd *cobra.Command, args []string) error {
+        cfg, err := config.Load(cfgPath)
+        if err != nil {
+            return err
+        }
+        if cmd.Flags().Changed("workdir") {
+            cfg.Workdir = workdir
+        }
+        if !slices.Contains(report.DiffFormats, diffFormat) {
+            return fmt.Errorf("unknown --format %q (want text, json or csv)", diffFormat)
+        }
+        ctx := context.Background()
+        if len(args) == 0 {
+            return listRuns(ctx, cfg, os.Stdout)
+        }
+
+        from, err := openSource(ctx, cfg, args[0])
+        if err != nil {
+            return err
+        }
+        defer from.db.Close()
+        to, err := openSource(ctx, cfg, args[1])
+        if err != nil {
+            return err
+        }
+        defer to.db.Close()
+        domain := domainOverride
+        if domain == "" {
+            domain = from.domain
+        }
+        if domain == "" {
+            domain = to.domain
+        }
+        a, err := report.LoadState(ctx, from.db, domain, args[0])
+        if err != nil {
+            return fmt.Errorf("%s: %w", args[0], err)
+        }
+        b, err := report.LoadState(ctx, to.db, domain, args[1])
+        if err != nil {
+            return fmt.Errorf("%s: %w", args[1], err)
+        }
+        d := report.Compare(a, b, domain)
+
+        if diffOut == "" || diffOut == "-" {
+            return report.WriteDiff(d, diffFormat, os.Stdout)
+        }
+        out, err := os.Create(diffOut + ".tmp")
+        if err != nil {
+            return err
+        }
+        defer out.Close()
+        if err := report.WriteDiff(d, diffFormat, out); err != nil {
+            return err
+        }
+        if err := out.Close(); err != nil {
+            return err
+        }
+        return os.Rename(diffOut+".tmp", diffOut)
+    },
+}
+
+// diffSource is one side of a diff: an opened store and the domain of its
+// run, when known.
+type diffSource struct {
+    db     *store.DB
+    domain string
+}
+
+// openSource resolves arg to a store snapshot. Paths are tried first: a
+// file is a database, a directory with run.meta.json is a target directory
+// whose last run's snapshot is used, and any other directory must hold
+// hermetica.sqlite. Anything else is looked up as a run ID in the
+// configured database.
+func openSource(ctx context.Context, cfg *config.Config, arg string) (*diffSource, error) {
+    path, domain := "", ""
+    fi, err := os.Stat(arg)
+    switch {
+    case err == nil && !fi.IsDir():
+        path = arg
+    case err == nil:
+        var meta struct {
+            RunID    string `json:"run_id"`
+            Domain   string `json:"domain"`
+            Snapshot string `json:"snapshot"`
+        }
+        if b, rerr := os.ReadFile(filepath.Join(arg, "run.meta.json")); rerr == nil && json.Unmarshal(b, &meta) == nil && meta.Snapshot != "" {
+            path, domain = meta.Snapshot, meta.Domain
+        } else {
+            path = filepath.Join(arg, "hermetica.sqlite")
+        }
+    case errors.Is(err, os.ErrNotExist):
+        db, err := openDB(cfg.DatabasePath())
+        if err != nil {
+            return nil, fmt.Errorf("%s is not a file or directory, and %w", arg, err)
+        }
+        run, err := db.GetRun(ctx, arg)
+        db.Close()
+        if errors.Is(err, store.ErrNoRun) {
+            return nil, fmt.Errorf("%s: not a file, directory or run ID in %s", arg, cfg.DatabasePath())
+        }
+        if err != nil {
+            return nil, err
+        }
+        path, domain = run.Snapshot, run.Domain
+    default:
+        return nil, err
+    }
+    db, err := openDB(path)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", arg, err)
+    }
+    return &diffSource{db: db, domain: domain}, nil
+}
+
+// openDB opens an existing database without creating it.
+func openDB(path string) (*store.DB, error) {
+    if _, err := os.Stat(path); err != nil {
+        return nil, fmt.Errorf("database %s: %w", path, err)
+    }
+    return store.Open(path)
+}
+
+// listRuns prints the recorded runs, newest last.
+func listRuns(ctx context.Context, cfg *config.Config, w io.Writer) error {
+    db, err := openDB(cfg.DatabasePath())
+    if err != nil {
+        return err
+    }
+    defer db.Close()
+    runs, err := db.ListRuns(ctx, domainOverride)
+    if err != nil {
+        return err
+    }
+    for _, r := range runs {
+        fmt.Fprintf(w, "%s  %-20s %-7s %-11s %s\n", r.ID, r.Domain, r.Mode, r.Status, r.StartedAt.Local().Format(time.DateTime))
+    }
+    return nil
+}
+
+func init() {
+    diffCmd.Flags().StringVar(&diffFormat, "format", "text", "Output format: text|json|csv")
+    diffCmd.Flags().StringVar(&diffOut, "out", "-", "Output file, or - for stdout")
+}
@@ -3,9 +3,9 @@ package cmd
 import (
     "fmt"
     "os/exec"
-    "strings"
 
     "hermetica/internal/config"
+    "hermetica/internal/executil"
     "github.com/Masterminds/semver/v3"
     "github.com/rs/zerolog/log"
     "github.com/spf13/cobra"
@@ -25,6 +25,9 @@ var doctorCmd = &cobra.Command{
         if err != nil {
             return err
         }
+        if err := cfg.Validate(); err != nil {
+            return err
+        }
         // Optionally attempt to auto-detect tool paths on PATH
         if fixPaths {
             for name, p := range cfg.Tools.Paths {
@@ -47,22 +50,18 @@ var doctorCmd = &cobra.Command{
             if !ok {
                 continue
             }
+            if name == "dnsx" && cfg.DNS.Backend == "native" {
+                log.Info().Str("tool", name).Msg("skipped (dns.backend: native)")
+                continue
+            }
             if path == "" {
                 return fmt.Errorf("tool %s path not set", name)
             }
-            // Run version command
-            out, verr := exec.Command(path, "-version").CombinedOutput()
+            verText, parsed, verr := executil.Version(cmd.Context(), path)
             if verr != nil {
-                // Fallbacks
-                out2, verr2 := exec.Command(path, "--version").CombinedOutput()
-                if verr2 != nil {
-                    return fmt.Errorf("%s version check failed: %v", name, verr)
-                }
-                out = out2
+                return fmt.Errorf("%s version check failed: %v", name, verr)
             }
-            verText := strings.TrimSpace(string(out))
-            parsed, perr := parseSemver(verText)
-            if perr != nil {
+            if parsed == nil {
                 log.Warn().Str("tool", name).Str("raw", verText).Msg("could not parse version; skipping strict compare")
             } else {
                 constraint, cErr := semver.NewConstraint(min)
@@ -99,22 +98,6 @@ func tryRun(bin string, args ...string) {
     _ = exec.Command(bin, args...).Run()
 }
 
-func parseSemver(s string) (*semver.Version, error) {
-    // Attempt to extract something that looks like vX.Y.Z
-    fields := strings.Fields(s)
-    for _, f := range fields {
-        f = strings.TrimPrefix(f, "v")
-        if v, err := semver.NewVersion(f); err == nil {
-            return v, nil
-        }
-    }
-    // Try as-is
-    if strings.HasPrefix(s, "v") {
-        s = strings.TrimPrefix(s, "v")
-    }
-    return semver.NewVersion(s)
-}
-
 func fileExists(p string) bool { _, err := os.Stat(p); return err == nil }
 
 func writeConfig(path string, cfg *config.Config) error {
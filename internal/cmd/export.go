@@ -1,16 +1,123 @@
 package cmd
 
 import (
+    "context"
     "fmt"
+    "os"
+    "path/filepath"
+    "slices"
+    "time"
+
+    "hermetica/internal/config"
+    "hermetica/internal/report"
+    "hermetica/internal/store"
     "github.com/spf13/cobra"
 )
 
+var (
+    exportFormat  string
+    exportOut     string
+    exportTable   string
+    exportOnlyWeb bool
+    exportStatus  string
+    exportSince   string
+)
+
 var exportCmd = &cobra.Command{
     Use:   "export",
     Short: "Export data from SQLite to CSV/JSON",
+    Long: `Export assets, services, webtargets and discovery rows from the store.
+
+Each table is written to <out>/<table>.<format>; --out - writes a single
+table to stdout. In CSV, multi-valued fields such as tech are joined with ";".`,
     RunE: func(cmd *cobra.Command, args []string) error {
-        fmt.Println("Export not yet implemented — will query SQLite and write CSV/JSON.")
+        cfg, err := config.Load(cfgPath)
+        if err != nil {
+            return err
+        }
+        if workdir != "" {
+            cfg.Workdir = workdir
+        }
+        if !slices.Contains(report.Formats, exportFormat) {
+            return fmt.Errorf("unknown --format %q (want csv, json or jsonl)", exportFormat)
+        }
+        tables := report.Tables
+        if exportTable != "all" {
+            if !slices.Contains(report.Tables, exportTable) {
+                return fmt.Errorf("unknown --table %q (want assets, services, webtargets, discovery or all)", exportTable)
+            }
+            tables = []string{exportTable}
+        }
+        f := store.Filter{Domain: domainOverride, OnlyWeb: exportOnlyWeb}
+        if exportStatus != "" {
+            if f.Status, err = report.ParseStatus(exportStatus); err != nil {
+                return err
+            }
+            if exportTable == "all" {
+                tables = []string{"webtargets"}
+            }
+        }
+        if exportOut == "-" && len(tables) != 1 {
+            return fmt.Errorf("--out - needs a single --table")
+        }
+        if exportSince != "" {
+            if f.Since, err = report.ParseSince(exportSince, time.Now()); err != nil {
+                return err
+            }
+        }
+
+        dbPath := cfg.DatabasePath()
+        if _, err := os.Stat(dbPath); err != nil {
+            return fmt.Errorf("database %s: %w", dbPath, err)
+        }
+        db, err := store.Open(dbPath)
+        if err != nil {
+            return err
+        }
+        defer db.Close()
+
+        ctx := context.Background()
+        if exportOut == "-" {
+            _, err := report.Export(ctx, db, tables[0], exportFormat, f, os.Stdout)
+            return err
+        }
+        if err := os.MkdirAll(exportOut, 0o755); err != nil {
+            return err
+        }
+        for _, t := range tables {
+            path := filepath.Join(exportOut, t+"."+exportFormat)
+            n, err := exportFile(ctx, db, t, f, path)
+            if err != nil {
+                return fmt.Errorf("export %s: %w", t, err)
+            }
+            fmt.Printf("%s: %d rows\n", path, n)
+        }
         return nil
     },
 }
 
+// exportFile writes one table to path through a temp file.
+func exportFile(ctx context.Context, db *store.DB, table string, f store.Filter, path string) (int, error) {
+    out, err := os.Create(path + ".tmp")
+    if err != nil {
+        return 0, err
+    }
+    defer out.Close()
+    n, err := report.Export(ctx, db, table, exportFormat, f, out)
+    if err != nil {
+        return 0, err
+    }
+    if err := out.Close(); err != nil {
+        return 0, err
+    }
+    return n, os.Rename(path+".tmp", path)
+}
+
+func init() {
+    exportCmd.Flags().StringVar(&exportFormat, "format", "csv", "Output format: csv|json|jsonl")
+    exportCmd.Flags().StringVar(&exportOut, "out", "out", "Output directory, or - for stdout")
+    exportCmd.Flags().StringVar(&exportTable, "table", "all", "Table: assets|services|webtargets|discovery|all")
+    exportCmd.Flags().BoolVar(&exportOnlyWeb, "only-web", false, "Only rows tied to services that serve HTTP")
+    exportCmd.Flags().StringVar(&exportStatus, "status", "", "Web target status codes or classes, e.g. 200,3xx (implies --table webtargets)")
+    exportCmd.Flags().StringVar(&exportSince, "since", "", "Only rows last seen since a time, date or duration (e.g. 2024-05-01, 36h, 7d)")
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "path/filepath"
+
+    "hermetica/internal/config"
+    "hermetica/internal/report"
+    "hermetica/internal/store"
+    "github.com/spf13/cobra"
+)
+
+var (
+    reportHTML bool
+    reportOut  string
+)
+
+var reportCmd = &cobra.Command{
+    Use:   "report",
+    Short: "Render a self-contained HTML report from SQLite",
+    RunE: func(cmd *cobra.Command, args []string) error {
+        cfg, err := config.Load(cfgPath)
+        if err != nil {
+            return err
+        }
+        if workdir != "" {
+            cfg.Workdir = workdir
+        }
+        if !reportHTML && !cfg.Report.HTML {
+            return fmt.Errorf("no report format selected: pass --html or set report.html")
+        }
+        var domains []string
+        if domainOverride != "" {
+            domains = []string{domainOverride}
+        } else {
+            for _, t := range cfg.Targets {
+                domains = append(domains, t.Domain)
+            }
+        }
+        if len(domains) == 0 {
+            return fmt.Errorf("no targets: set targets[] or pass -d")
+        }
+
+        dbPath := cfg.DatabasePath()
+        if _, err := os.Stat(dbPath); err != nil {
+            return fmt.Errorf("database %s: %w", dbPath, err)
+        }
+        db, err := store.Open(dbPath)
+        if err != nil {
+            return err
+        }
+        defer db.Close()
+
+        out := reportOut
+        if out == "" {
+            out = filepath.Join(cfg.Workdir, "report.html")
+        }
+        if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+            return err
+        }
+        f, err := os.Create(out + ".tmp")
+        if err != nil {
+            return err
+        }
+        defer f.Close()
+        title := "Hermetica report"
+        if cfg.Project != "" {
+            title += " — " + cfg.Project
+        }
+        if err := report.HTML(context.Background(), db, title, dbPath, cfg.Workdir, domains, f); err != nil {
+            return err
+        }
+        if err := f.Close(); err != nil {
+            return err
+        }
+        if err := os.Rename(out+".tmp", out); err != nil {
+            return err
+        }
+        fmt.Println(out)
+        return nil
+    },
+}
+
+func init() {
+    reportCmd.Flags().BoolVar(&reportHTML, "html", false, "Render the HTML report (also enabled by report.html)")
+    reportCmd.Flags().StringVar(&reportOut, "out", "", "Output file (default <workdir>/report.html)")
+}
@@ -1,22 +1,95 @@
 package cmd
 
 import (
+    "context"
     "fmt"
+    "os"
+    "path/filepath"
+    "time"
+
     "hermetica/internal/config"
+    "hermetica/internal/pipeline"
+    "github.com/rs/zerolog/log"
     "github.com/spf13/cobra"
 )
 
 var resumeCmd = &cobra.Command{
     Use:   "resume",
     Short: "Resume from existing artifacts",
+    Long: `Resume the last run from its per-stage checkpoint.
+
+Point -w at a target directory (work/<domain>/) to resume that target, or at
+the work root to resume every configured target. Completed stages whose
+artifacts and inputs are unchanged are skipped; the first incomplete or
+invalidated stage and everything after it run again. Resume refuses to
+continue when the config changed for a stage that already completed.`,
     RunE: func(cmd *cobra.Command, args []string) error {
-        _, err := config.Load(cfgPath)
+        cfg, err := config.Load(cfgPath)
         if err != nil {
             return err
         }
-        // Placeholder: resume logic will use artifacts in workdir
-        fmt.Println("Resume not yet implemented — will use artifacts when available.")
+        if profile != "" {
+            cfg.Scan.Profile = profile
+        }
+        if err := cfg.Validate(); err != nil {
+            return err
+        }
+
+        domains := []string{}
+        if _, err := os.Stat(filepath.Join(workdir, pipeline.CheckpointFile)); err == nil {
+            dir := filepath.Clean(workdir)
+            cfg.Workdir = filepath.Dir(dir)
+            domains = append(domains, filepath.Base(dir))
+        } else {
+            cfg.Workdir = workdir
+            if domainOverride != "" {
+                domains = append(domains, domainOverride)
+            } else {
+                for _, t := range cfg.Targets {
+                    domains = append(domains, t.Domain)
+                }
+            }
+        }
+
+        sigCtx, stop := interruptContext()
+        defer stop()
+        for _, d := range domains {
+            i := targetIndex(cfg, d)
+            if i < 0 {
+                return fmt.Errorf("%s is not a target in %s", d, cfgPath)
+            }
+            tcfg, err := cfg.ForTarget(i)
+            if err != nil {
+                return err
+            }
+            if profile != "" {
+                tcfg.Scan.Profile = profile
+            }
+            if _, err := os.Stat(filepath.Join(tcfg.Workdir, d, pipeline.CheckpointFile)); err != nil {
+                return fmt.Errorf("no checkpoint for %s in %s; start it with `hermetica run`", d, filepath.Join(tcfg.Workdir, d))
+            }
+            log.Info().Str("stage", "resume").Str("domain", d).Msg("resuming target")
+            ctx, cancel := context.WithTimeout(sigCtx, 24*time.Hour)
+            err = pipeline.Resume(ctx, tcfg, cfg.Targets[i])
+            cancel()
+            if sigCtx.Err() != nil {
+                return interrupted(filepath.Join(tcfg.Workdir, d))
+            }
+            if err != nil {
+                return fmt.Errorf("resume failed for %s: %w", d, err)
+            }
+            log.Info().Str("domain", d).Msg("target completed")
+        }
         return nil
     },
 }
 
+// targetIndex returns the index of domain in cfg.Targets, or -1.
+func targetIndex(cfg *config.Config, domain string) int {
+    for i, t := range cfg.Targets {
+        if t.Domain == domain {
+            return i
+        }
+    }
+    return -1
+}
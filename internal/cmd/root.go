@@ -43,6 +43,10 @@ func init() {
     rootCmd.AddCommand(runCmd)
     rootCmd.AddCommand(resumeCmd)
     rootCmd.AddCommand(exportCmd)
+    rootCmd.AddCommand(reportCmd)
+    rootCmd.AddCommand(diffCmd)
+    rootCmd.AddCommand(watchCmd)
     rootCmd.AddCommand(doctorCmd)
+    rootCmd.AddCommand(configCmd)
 }
 
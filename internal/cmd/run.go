@@ -3,6 +3,11 @@ package cmd
 import (
     "context"
     "fmt"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "sync"
+    "syscall"
     "time"
 
     "hermetica/internal/config"
@@ -29,14 +34,29 @@ var runCmd = &cobra.Command{
         if profile != "" {
             cfg.Scan.Profile = profile
         }
+        if err := cfg.Validate(); err != nil {
+            return err
+        }
         logging.Init(debug)
 
-        for _, t := range cfg.Targets {
+        sigCtx, stop := interruptContext()
+        defer stop()
+        for i, t := range cfg.Targets {
+            tcfg, err := cfg.ForTarget(i)
+            if err != nil {
+                return err
+            }
+            if profile != "" {
+                tcfg.Scan.Profile = profile
+            }
             log.Info().Str("stage", "run").Str("domain", t.Domain).Msg("starting target")
-            ctx := context.Background()
-            ctx, cancel := context.WithTimeout(ctx, 24*time.Hour)
-            defer cancel()
-            if err := pipeline.Run(ctx, cfg, t, force); err != nil {
+            ctx, cancel := context.WithTimeout(sigCtx, 24*time.Hour)
+            err = pipeline.Run(ctx, tcfg, t, force)
+            cancel()
+            if sigCtx.Err() != nil {
+                return interrupted(filepath.Join(tcfg.Workdir, t.Domain))
+            }
+            if err != nil {
                 return fmt.Errorf("pipeline failed for %s: %w", t.Domain, err)
             }
             log.Info().Str("domain", t.Domain).Msg("target completed")
@@ -45,3 +65,37 @@ var runCmd = &cobra.Command{
     },
 }
 
+
+// interruptContext returns a context cancelled by SIGINT or SIGTERM. Running
+// tools then get SIGTERM (and SIGKILL after a grace period) and stages that
+// can resume save their progress before the checkpoint is written.
+// After the first signal, or once stop is called, a signal gets the
+// default behaviour again.
+func interruptContext() (context.Context, context.CancelFunc) {
+    ctx, cancel := context.WithCancel(context.Background())
+    sig := make(chan os.Signal, 1)
+    signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+    done := make(chan struct{})
+    go func() {
+        select {
+        case <-sig:
+            signal.Stop(sig)
+            log.Warn().Msg("interrupted; stopping tools and saving partial results (signal again to force)")
+            cancel()
+        case <-done:
+        }
+    }()
+    var once sync.Once
+    stop := func() {
+        once.Do(func() {
+            signal.Stop(sig)
+            close(done)
+        })
+        cancel()
+    }
+    return ctx, stop
+}
+
+func interrupted(dir string) error {
+    return fmt.Errorf("interrupted; continue with `hermetica resume -w %s`", dir)
+}
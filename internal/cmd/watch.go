@@ -0,0 +1,321 @@
+package cmd
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "time"
+
+    "hermetica/internal/config"
+    "hermetica/internal/pipeline"
+    "hermetica/internal/report"
+    "hermetica/internal/schedule"
+    "hermetica/internal/store"
+    "github.com/rs/zerolog/log"
+    "github.com/spf13/cobra"
+)
+
+var (
+    watchOnce   bool
+    watchFormat string
+)
+
+var watchCmd = &cobra.Command{
+    Use:   "watch",
+    Short: "Re-run targets on a schedule and report what changed",
+    Long: `Run the configured targets again and again on the cadence set in the
+watch block of the config: an interval such as 6h or 7d, or a cron
+expression such as "0 3 * * 1". Every stage follows watch.schedule unless
+watch.stages gives it its own cadence, e.g. discover_subdomains hourly and
+scan_ports weekly.
+
+A cycle runs the stages that are due and reuses the artifacts of the rest,
+so stages that should see fresh upstream data need the same cadence as the
+stages feeding them. Stages that never ran are due at once. A stage that
+fails, or never starts because an earlier one failed, stays due and is
+retried after 5 minutes, doubling up to 6 hours, or at its next regular
+time if that comes first. When each stage last ran is kept in the store,
+so a restarted watch picks up where it left off.
+
+After every cycle the change set against the previous run is written to
+work/<domain>/changes/<run_id>.json and, when not empty, printed to stdout.`,
+    RunE: func(cmd *cobra.Command, args []string) error {
+        cfg, err := config.Load(cfgPath)
+        if err != nil {
+            return err
+        }
+        if workdir != "" {
+            cfg.Workdir = workdir
+        }
+        if profile != "" {
+            cfg.Scan.Profile = profile
+        }
+        if err := cfg.Validate(); err != nil {
+            return err
+        }
+        if !slices.Contains(report.DiffFormats, watchFormat) {
+            return fmt.Errorf("unknown --format %q (want text, json or csv)", watchFormat)
+        }
+        for stage := range cfg.Watch.Stages {
+            if !slices.Contains(pipeline.Registered(), stage) {
+                return fmt.Errorf("watch.stages: unknown stage %q (want one of %s)", stage, strings.Join(pipeline.Registered(), ", "))
+            }
+        }
+
+        w := &watcher{runStages: pipeline.RunStages, now: time.Now}
+        for i, t := range cfg.Targets {
+            if domainOverride != "" && t.Domain != domainOverride {
+                continue
+            }
+            tcfg, err := cfg.ForTarget(i)
+            if err != nil {
+                return err
+            }
+            if profile != "" {
+                tcfg.Scan.Profile = profile
+            }
+            wt := watchTarget{target: t, cfg: tcfg, stages: pipeline.StageNames(tcfg, t), cadence: map[string]schedule.Schedule{}}
+            for _, stage := range wt.stages {
+                if wt.cadence[stage], err = schedule.Parse(cfg.Watch.StageSchedule(stage)); err != nil {
+                    return fmt.Errorf("watch: %s: %w", stage, err)
+                }
+            }
+            w.targets = append(w.targets, wt)
+        }
+        if len(w.targets) == 0 {
+            return fmt.Errorf("%s is not a target in %s", domainOverride, cfgPath)
+        }
+        w.db, err = openStoreAt(cfg.DatabasePath())
+        if err != nil {
+            return err
+        }
+        defer w.db.Close()
+
+        sigCtx, stop := interruptContext()
+        defer stop()
+        return w.run(sigCtx)
+    },
+}
+
+// watchTarget is a target with the stages enabled for it and the cadence
+// of each.
+type watchTarget struct {
+    target  config.Target
+    cfg     *config.Config
+    stages  []string
+    cadence map[string]schedule.Schedule
+}
+
+// watcher runs cycles until interrupted. db holds the schedule state; the
+// pipeline opens its own handle on the same file. runStages and now are
+// pipeline.RunStages and time.Now outside of tests.
+type watcher struct {
+    db        *store.DB
+    targets   []watchTarget
+    runStages func(context.Context, *config.Config, config.Target, map[string]bool) (string, map[string]bool, error)
+    now       func() time.Time
+}
+
+func (w *watcher) run(ctx context.Context) error {
+    for {
+        next, err := w.cycle(ctx)
+        if ctx.Err() != nil {
+            log.Info().Str("stage", "watch").Msg("watch stopped")
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        if watchOnce {
+            return nil
+        }
+        log.Info().Str("stage", "watch").Time("next", next).Msg("sleeping until the next stage is due")
+        timer := time.NewTimer(time.Until(next))
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            log.Info().Str("stage", "watch").Msg("watch stopped")
+            return nil
+        case <-timer.C:
+        }
+    }
+}
+
+// cycle runs the due stages of every target and returns when the next
+// stage is due. Stages that completed get their next time from their
+// cadence; stages that failed or never started because an earlier one
+// failed stay due and are retried with backoff (schedule.Retry). Only
+// store errors end the watch.
+func (w *watcher) cycle(ctx context.Context) (time.Time, error) {
+    var next time.Time
+    for _, wt := range w.targets {
+        domain := wt.target.Domain
+        state, err := w.db.ListSchedules(ctx, domain)
+        if err != nil {
+            return next, fmt.Errorf("schedule state for %s: %w", domain, err)
+        }
+        now := w.now()
+        due := map[string]bool{}
+        for _, stage := range wt.stages {
+            if !wt.dueAt(stage, state).After(now) {
+                due[stage] = true
+            }
+        }
+        if len(due) == 0 {
+            log.Debug().Str("stage", "watch").Str("domain", domain).Msg("nothing due")
+        } else {
+            runID, completed := w.runTarget(ctx, wt, due)
+            if ctx.Err() != nil {
+                return next, nil
+            }
+            var rows []store.StageSchedule
+            for stage := range due {
+                s := state[stage]
+                s.Domain, s.Stage, s.LastRunID = domain, stage, runID
+                if completed[stage] {
+                    s.LastRun, s.NextRun, s.LastStatus, s.Failures = now, wt.cadence[stage].Next(now), "done", 0
+                } else {
+                    s.Failures++
+                    s.NextRun, s.LastStatus = schedule.Retry(now, s.Failures, wt.cadence[stage]), "failed"
+                }
+                rows = append(rows, s)
+                state[stage] = s
+            }
+            if err := w.db.SaveSchedules(ctx, rows); err != nil {
+                return next, fmt.Errorf("saving schedule state for %s: %w", domain, err)
+            }
+            if runID != "" {
+                if err := w.changes(ctx, wt, runID); err != nil {
+                    log.Warn().Err(err).Str("stage", "watch").Str("domain", domain).Msg("writing change set")
+                }
+            }
+        }
+        for _, stage := range wt.stages {
+            at := wt.dueAt(stage, state)
+            if next.IsZero() || at.Before(next) {
+                next = at
+            }
+        }
+    }
+    return next, nil
+}
+
+// dueAt returns when stage is due; see schedule.Due.
+func (wt watchTarget) dueAt(stage string, state map[string]store.StageSchedule) time.Time {
+    s, ok := state[stage]
+    return schedule.Due(wt.cadence[stage], s, ok)
+}
+
+// runTarget runs the due stages of wt and returns the run ID and the
+// stages that completed.
+func (w *watcher) runTarget(ctx context.Context, wt watchTarget, due map[string]bool) (string, map[string]bool) {
+    var names []string
+    for _, stage := range wt.stages {
+        if due[stage] {
+            names = append(names, stage)
+        }
+    }
+    domain := wt.target.Domain
+    log.Info().Str("stage", "watch").Str("domain", domain).Strs("due", names).Msg("starting cycle")
+    rctx, cancel := context.WithTimeout(ctx, 24*time.Hour)
+    runID, completed, err := w.runStages(rctx, wt.cfg, wt.target, due)
+    cancel()
+    if err != nil {
+        log.Error().Err(err).Str("stage", "watch").Str("domain", domain).Int("completed", len(completed)).Msg("cycle failed")
+        return runID, completed
+    }
+    log.Info().Str("stage", "watch").Str("domain", domain).Str("run_id", runID).Msg("cycle completed")
+    return runID, completed
+}
+
+// changes compares the snapshot of run runID with the one of the run
+// before it, writes the change set to work/<domain>/changes/<run_id>.json
+// and prints it unless nothing changed. The first run is compared against
+// an empty state.
+func (w *watcher) changes(ctx context.Context, wt watchTarget, runID string) error {
+    domain := wt.target.Domain
+    runs, err := w.db.ListRuns(ctx, domain)
+    if err != nil {
+        return err
+    }
+    i := slices.IndexFunc(runs, func(r store.Run) bool { return r.ID == runID })
+    if i < 0 {
+        return store.ErrNoRun
+    }
+    b, err := loadSnapshot(ctx, runs[i].Snapshot, domain, runID)
+    if err != nil {
+        return err
+    }
+    a := &report.State{Label: "empty"}
+    for j := i - 1; j >= 0; j-- {
+        if _, err := os.Stat(runs[j].Snapshot); err != nil {
+            continue
+        }
+        if a, err = loadSnapshot(ctx, runs[j].Snapshot, domain, runs[j].ID); err != nil {
+            return err
+        }
+        break
+    }
+    d := report.Compare(a, b, domain)
+
+    dir := filepath.Join(wt.cfg.Workdir, domain, "changes")
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        return err
+    }
+    path := filepath.Join(dir, runID+".json")
+    out, err := os.Create(path + ".tmp")
+    if err != nil {
+        return err
+    }
+    defer out.Close()
+    if err := report.WriteDiff(d, "json", out); err != nil {
+        return err
+    }
+    if err := out.Close(); err != nil {
+        return err
+    }
+    if err := os.Rename(path+".tmp", path); err != nil {
+        return err
+    }
+
+    ev := log.Info().Str("stage", "watch").Str("domain", domain).Str("changes", path)
+    if d.Empty() {
+        ev.Msg("no changes")
+        return nil
+    }
+    ev.Int("new_subdomains", len(d.NewHosts)).Int("removed_subdomains", len(d.GoneHosts)).
+        Int("ip_changes", len(d.IPChanges)).Int("opened_ports", len(d.OpenPorts)).Int("closed_ports", len(d.ClosedPorts)).
+        Int("new_webtargets", len(d.NewWeb)).Int("removed_webtargets", len(d.GoneWeb)).Int("changed_webtargets", len(d.WebChanges)).
+        Msg("changes found")
+    return report.WriteDiff(d, watchFormat, os.Stdout)
+}
+
+// loadSnapshot reads the state of domain from the snapshot at path.
+func loadSnapshot(ctx context.Context, path, domain, label string) (*report.State, error) {
+    if path == "" {
+        return nil, errors.New("run has no snapshot")
+    }
+    db, err := openDB(path)
+    if err != nil {
+        return nil, err
+    }
+    defer db.Close()
+    return report.LoadState(ctx, db, domain, label)
+}
+
+// openStoreAt opens the database at path, creating it and its directory.
+func openStoreAt(path string) (*store.DB, error) {
+    if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+        return nil, err
+    }
+    return store.Open(path)
+}
+
+func init() {
+    watchCmd.Flags().BoolVar(&watchOnce, "once", false, "Run the due stages once and exit")
+    watchCmd.Flags().StringVar(&watchFormat, "format", "text", "Change set format on stdout: text|json|csv")
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+    "context"
+    "errors"
+    "path/filepath"
+    "reflect"
+    "slices"
+    "testing"
+    "time"
+
+    "hermetica/internal/config"
+    "hermetica/internal/schedule"
+    "hermetica/internal/store"
+)
+
+// fakeWatch is a watcher over one target whose stages run on the given
+// cadences. Stages listed in fail do not complete and stop the stages
+// after them; the due stages of every run are logged to ran.
+type fakeWatch struct {
+    *watcher
+    clock time.Time
+    fail  map[string]bool
+    ran   [][]string
+}
+
+func newFakeWatch(t *testing.T, cadence map[string]schedule.Schedule, stages ...string) *fakeWatch {
+    db, err := store.Open(filepath.Join(t.TempDir(), "hermetica.sqlite"))
+    if err != nil { t.Fatal(err) }
+    t.Cleanup(func() { db.Close() })
+    f := &fakeWatch{clock: time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC), fail: map[string]bool{}}
+    wt := watchTarget{target: config.Target{Domain: "example.com"}, cfg: &config.Config{}, stages: stages, cadence: cadence}
+    f.watcher = &watcher{db: db, targets: []watchTarget{wt}, now: func() time.Time { return f.clock }}
+    f.runStages = func(_ context.Context, _ *config.Config, _ config.Target, due map[string]bool) (string, map[string]bool, error) {
+        var names []string
+        completed := map[string]bool{}
+        var err error
+        for _, s := range stages {
+            if !due[s] { continue }
+            names = append(names, s)
+            if err == nil && f.fail[s] { err = errors.New(s + " failed") }
+            if err == nil { completed[s] = true }
+        }
+        f.ran = append(f.ran, names)
+        return "", completed, err
+    }
+    return f
+}
+
+// step advances the clock by d and runs one cycle, returning the stages it
+// ran and the next due time relative to the new clock.
+func (f *fakeWatch) step(t *testing.T, d time.Duration) ([]string, time.Duration) {
+    t.Helper()
+    f.clock = f.clock.Add(d)
+    n := len(f.ran)
+    next, err := f.cycle(context.Background())
+    if err != nil { t.Fatal(err) }
+    var ran []string
+    if len(f.ran) > n { ran = f.ran[n] }
+    return ran, next.Sub(f.clock)
+}
+
+func TestWatchDueStages(t *testing.T) {
+    hourly, daily := schedule.Interval{D: time.Hour}, schedule.Interval{D: 24 * time.Hour}
+    f := newFakeWatch(t, map[string]schedule.Schedule{"discover": hourly, "resolve": hourly, "scan": daily}, "discover", "resolve", "scan")
+    for _, tc := range []struct {
+        name   string
+        after  time.Duration
+        ran    []string
+        nextIn time.Duration
+    }{
+        {"never ran", 0, []string{"discover", "resolve", "scan"}, time.Hour},
+        {"nothing due yet", 30 * time.Minute, nil, 30 * time.Minute},
+        {"hourly stages due", 30 * time.Minute, []string{"discover", "resolve"}, time.Hour},
+        {"hourly again", time.Hour, []string{"discover", "resolve"}, time.Hour},
+        {"daily stage joins", 22 * time.Hour, []string{"discover", "resolve", "scan"}, time.Hour},
+    } {
+        ran, next := f.step(t, tc.after)
+        if !reflect.DeepEqual(ran, tc.ran) { t.Errorf("%s: ran %v, want %v", tc.name, ran, tc.ran) }
+        if next != tc.nextIn { t.Errorf("%s: next due in %v, want %v", tc.name, next, tc.nextIn) }
+    }
+}
+
+func TestWatchFailureBackoff(t *testing.T) {
+    daily := schedule.Interval{D: 24 * time.Hour}
+    f := newFakeWatch(t, map[string]schedule.Schedule{"discover": daily, "resolve": daily, "scan": daily}, "discover", "resolve", "scan")
+    f.fail["resolve"] = true
+    if ran, next := f.step(t, 0); len(ran) != 3 || next != 5*time.Minute { t.Fatalf("first cycle ran %v, next in %v", ran, next) }
+    state, err := f.db.ListSchedules(context.Background(), "example.com")
+    if err != nil { t.Fatal(err) }
+    // scan never started because resolve failed: both back off.
+    for stage, want := range map[string]struct {
+        status   string
+        failures int
+    }{"discover": {"done", 0}, "resolve": {"failed", 1}, "scan": {"failed", 1}} {
+        if s := state[stage]; s.LastStatus != want.status || s.Failures != want.failures { t.Errorf("%s = %+v, want %s after %d failures", stage, s, want.status, want.failures) }
+    }
+
+    // The retries only run the failed stages, each after twice the wait.
+    for i, wait := range []time.Duration{5 * time.Minute, 10 * time.Minute, 20 * time.Minute} {
+        if ran, _ := f.step(t, wait-time.Second); ran != nil { t.Errorf("retry %d ran %v early", i+1, ran) }
+        ran, next := f.step(t, time.Second)
+        if !reflect.DeepEqual(ran, []string{"resolve", "scan"}) { t.Errorf("retry %d ran %v", i+1, ran) }
+        if next != 2*wait { t.Errorf("retry %d: next due in %v, want %v", i+1, next, 2*wait) }
+    }
+
+    // A success resets the backoff; discover, done 75 minutes ago, is due
+    // next.
+    f.fail["resolve"] = false
+    if ran, next := f.step(t, 40*time.Minute); !reflect.DeepEqual(ran, []string{"resolve", "scan"}) || next != 24*time.Hour-75*time.Minute { t.Errorf("recovery ran %v, next in %v", ran, next) }
+    state, err = f.db.ListSchedules(context.Background(), "example.com")
+    if err != nil { t.Fatal(err) }
+    if s := state["resolve"]; s.LastStatus != "done" || s.Failures != 0 { t.Errorf("resolve after recovery = %+v", s) }
+}
+
+func TestWatchRetryWithinCadence(t *testing.T) {
+    // With a 15 minute cadence the backoff would pass the regular time
+    // from the third failure on; the retry happens on cadence instead.
+    f := newFakeWatch(t, map[string]schedule.Schedule{"discover": schedule.Interval{D: 15 * time.Minute}}, "discover")
+    f.fail["discover"] = true
+    waits := []time.Duration{0}
+    for i := 0; i < 6; i++ {
+        ran, next := f.step(t, waits[len(waits)-1])
+        if !slices.Equal(ran, []string{"discover"}) { t.Fatalf("cycle %d ran %v", i, ran) }
+        if next > 15*time.Minute { t.Errorf("cycle %d: retry in %v, after the 15m cadence", i, next) }
+        waits = append(waits, next)
+    }
+    if want := []time.Duration{0, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 15 * time.Minute, 15 * time.Minute, 15 * time.Minute}; !slices.Equal(waits, want) { t.Errorf("waits = %v, want %v", waits, want) }
+}
@@ -5,30 +5,52 @@ import (
     "os"
     "path/filepath"
     "strings"
+    "time"
 
     "gopkg.in/yaml.v3"
+    "hermetica/internal/executil"
 )
 
 type Config struct {
-    Project  string        `yaml:"project"`
-    Workdir  string        `yaml:"workdir"`
-    Database string        `yaml:"database"`
-    Targets  []Target      `yaml:"targets"`
-    Scope    Scope         `yaml:"scope"`
-    Tools    Tools         `yaml:"tools"`
-    DNS      DNS           `yaml:"dns"`
-    Limits   Limits        `yaml:"limits"`
-    Scan     Scan          `yaml:"scan"`
-    Stages   Stages        `yaml:"stages"`
-    Probe    ProbeMatrix   `yaml:"probe_matrix"`
-    Evidence Evidence      `yaml:"evidence"`
-    Report   Report        `yaml:"report"`
+    Project   string        `yaml:"project"`
+    Workdir   string        `yaml:"workdir"`
+    Database  string        `yaml:"database"`
+    Targets   []Target      `yaml:"targets"`
+    Scope     Scope         `yaml:"scope"`
+    Tools     Tools         `yaml:"tools"`
+    DNS       DNS           `yaml:"dns"`
+    Limits    Limits        `yaml:"limits"`
+    Scan      Scan          `yaml:"scan"`
+    Stages    Stages        `yaml:"stages"`
+    Probe     ProbeMatrix   `yaml:"probe_matrix"`
+    Evidence  Evidence      `yaml:"evidence"`
+    Report    Report        `yaml:"report"`
+    Watch     Watch         `yaml:"watch"`
+    Snapshots Snapshots     `yaml:"snapshots"`
+
+    doc     *yaml.Node // merged document, kept for line numbers in Validate
+    origins origins    // file or env var each node of doc came from
 }
 
+// Target is one entry of targets[]. The optional blocks override the
+// top-level ones field by field; after Load they hold the effective values,
+// with anything left unset inherited (see Config.ForTarget).
 type Target struct {
     Domain            string `yaml:"domain"`
-    IncludeSubdomains bool   `yaml:"include_subdomains"`
-    IPv6Enabled       bool   `yaml:"ipv6_enabled"`
+    IncludeSubdomains *bool  `yaml:"include_subdomains,omitempty"` // nil means true
+    IPv6Enabled       *bool  `yaml:"ipv6_enabled,omitempty"` // nil inherits dns.ipv6_enabled
+
+    Limits *Limits      `yaml:"limits,omitempty"`
+    Scan   *Scan        `yaml:"scan,omitempty"`
+    Stages *Stages      `yaml:"stages,omitempty"`
+    Scope  *Scope       `yaml:"scope,omitempty"`
+    Probe  *ProbeMatrix `yaml:"probe_matrix,omitempty"`
+}
+
+// SubdomainsEnabled reports whether passive subdomain discovery runs for
+// the target. When false the apex domain is the only host.
+func (t Target) SubdomainsEnabled() bool {
+    return t.IncludeSubdomains == nil || *t.IncludeSubdomains
 }
 
 type Scope struct {
@@ -44,12 +66,28 @@ type Tools struct {
     Versions       map[string]string `yaml:"versions"`
     ProviderConfig string            `yaml:"provider_config"`
     ResolversFile  string            `yaml:"resolvers_file"`
+    Retry          ToolRetry         `yaml:"retry"`
+}
+
+// ToolRetry is the retry policy for failed tool runs. Only timeouts and
+// non-zero exits that produced no output are retried.
+type ToolRetry struct {
+    Attempts          int `yaml:"attempts"`            // total attempts; 0 or 1 disables retries
+    BackoffSeconds    int `yaml:"backoff_seconds"`     // wait before the first retry, doubled after each
+    MaxBackoffSeconds int `yaml:"max_backoff_seconds"` // cap for the wait; 0 means no cap
+}
+
+// Policy converts the block for executil.CmdSpec.
+func (r ToolRetry) Policy() executil.RetryPolicy {
+    return executil.RetryPolicy{Attempts: r.Attempts, Backoff: time.Duration(r.BackoffSeconds) * time.Second, MaxBackoff: time.Duration(r.MaxBackoffSeconds) * time.Second}
 }
 
 type DNS struct {
-    WildcardFilter bool `yaml:"wildcard_filter"`
-    VerifyCount    int  `yaml:"verify_count"`
-    IPv6Enabled    bool `yaml:"ipv6_enabled"`
+    Backend        string `yaml:"backend"` // dnsx (default) | native
+    ResolverQPS    int    `yaml:"resolver_qps"` // native backend: per-resolver query rate, 0 = unlimited
+    WildcardFilter bool   `yaml:"wildcard_filter"`
+    VerifyCount    int    `yaml:"verify_count"`
+    IPv6Enabled    bool   `yaml:"ipv6_enabled"` // per target: the effective value after Config.ForTarget
 }
 
 type Limits struct {
@@ -63,6 +101,7 @@ type Limits struct {
 type Scan struct {
     Profile string `yaml:"profile"`
     NaabuRate int  `yaml:"naabu_rate"`
+    BatchSize int  `yaml:"batch_size"` // IPs per naabu run; default 256
     AdaptiveBackoff AdaptiveBackoff `yaml:"adaptive_backoff"`
 }
 
@@ -71,6 +110,7 @@ type AdaptiveBackoff struct {
     PacketLossThreshold float64 `yaml:"packet_loss_threshold"`
     BackoffMultiplier   float64 `yaml:"backoff_multiplier"`
     RecoveryMultiplier  float64 `yaml:"recovery_multiplier"`
+    MinRate             int     `yaml:"min_rate"` // floor for backed-off rates; default 100
 }
 
 type Stages struct {
@@ -122,6 +162,16 @@ type Evidence struct {
     StoreScreenshots  bool   `yaml:"store_screenshots"`
     BodyHashAlgo      string `yaml:"body_hash_algo"`
     NearDupe          bool   `yaml:"near_dupe"`
+    NearDupeDistance  *int   `yaml:"near_dupe_distance,omitempty"` // max SimHash Hamming distance within a page group; nil means 3
+}
+
+// NearDupeMaxDistance returns the effective near_dupe_distance; an
+// explicit 0 groups identical SimHashes only.
+func (e Evidence) NearDupeMaxDistance() int {
+    if e.NearDupeDistance == nil {
+        return 3
+    }
+    return *e.NearDupeDistance
 }
 
 type Report struct {
@@ -129,13 +179,70 @@ type Report struct {
     HTML bool `yaml:"html"`
 }
 
+// Snapshots bounds the copies of the store taken after every run for
+// `hermetica diff` and the change sets of `hermetica watch`.
+type Snapshots struct {
+    Keep *int `yaml:"keep,omitempty"` // newest snapshots kept per domain; nil means 20, 0 keeps all
+}
+
+// KeepPerDomain returns how many snapshots to keep per domain, 0 for all.
+func (s Snapshots) KeepPerDomain() int {
+    if s.Keep == nil {
+        return 20
+    }
+    return *s.Keep
+}
+
+// Watch sets the cadence of `hermetica watch`. Each value is an interval
+// ("90m", "6h", "7d") or a cron expression ("0 3 * * 1"); stages without
+// an entry in Stages follow Schedule.
+type Watch struct {
+    Schedule string            `yaml:"schedule"` // default 24h
+    Stages   map[string]string `yaml:"stages"`   // stage name -> cadence
+}
+
+// StageSchedule returns the cadence configured for stage.
+func (w Watch) StageSchedule(stage string) string {
+    if s := w.Stages[stage]; s != "" {
+        return s
+    }
+    if w.Schedule != "" {
+        return w.Schedule
+    }
+    return "24h"
+}
+
+// DatabasePath returns the SQLite path, defaulting to
+// <workdir>/hermetica.sqlite.
+func (c *Config) DatabasePath() string {
+    if c.Database != "" {
+        return c.Database
+    }
+    return filepath.Join(c.Workdir, "hermetica.sqlite")
+}
+
+// Load reads the config at path, merging any files it extends or includes,
+// then applies HERMETICA_* environment overrides and ${VAR} interpolation.
 func Load(path string) (*Config, error) {
-    b, err := os.ReadFile(path)
+    org := origins{}
+    doc, err := loadLayers(path, nil, org)
     if err != nil {
         return nil, err
     }
+    if err := applyEnv(doc, os.Environ(), org); err != nil {
+        return nil, fmt.Errorf("env override: %w", err)
+    }
+    if err := interpolate(doc, os.LookupEnv, org); err != nil {
+        return nil, err
+    }
+    resolvePaths(doc, org)
     var cfg Config
-    if err := yaml.Unmarshal(b, &cfg); err != nil {
+    if err := doc.Decode(&cfg); err != nil {
+        return nil, fmt.Errorf("parse config: %w", err)
+    }
+    cfg.doc = doc
+    cfg.origins = org
+    if err := cfg.resolveTargets(); err != nil {
         return nil, fmt.Errorf("parse config: %w", err)
     }
     if cfg.Workdir == "" {
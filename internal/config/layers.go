@@ -0,0 +1,311 @@
+package config
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "reflect"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+
+    "gopkg.in/yaml.v3"
+)
+
+// EnvPrefix marks environment variables that override config fields, e.g.
+// HERMETICA_SCAN_NAABU_RATE=1000 or HERMETICA_TOOLS_PATHS_HTTPX=/opt/httpx.
+const EnvPrefix = "HERMETICA_"
+
+// layerKeys are top-level keys that pull in other files. Parents listed in
+// extends are merged first, then include fragments, then the file itself.
+var layerKeys = []string{"extends", "include"}
+
+var interpRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// origins maps every node of the merged tree to where it came from.
+type origins map[*yaml.Node]string
+
+// loadLayers reads path and everything it extends or includes, returning a
+// single merged mapping node.
+func loadLayers(path string, stack []string, org origins) (*yaml.Node, error) {
+    abs, err := filepath.Abs(path)
+    if err != nil { return nil, err }
+    for _, p := range stack {
+        if p == abs { return nil, fmt.Errorf("config include cycle: %s", strings.Join(append(stack, abs), " -> ")) }
+    }
+    stack = append(stack, abs)
+    b, err := os.ReadFile(path)
+    if err != nil { return nil, err }
+    var doc yaml.Node
+    if err := yaml.Unmarshal(b, &doc); err != nil {
+        return nil, fmt.Errorf("parse config %s: %w", path, err)
+    }
+    root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+    if len(doc.Content) > 0 { root = doc.Content[0] }
+    if root.Kind != yaml.MappingNode { return nil, fmt.Errorf("parse config %s: top level must be a mapping", path) }
+    org.mark(root, path)
+
+    var parents []string
+    for _, key := range layerKeys {
+        v := takeKey(root, key)
+        if v == nil { continue }
+        files, err := stringList(v)
+        if err != nil { return nil, fmt.Errorf("%s:%d: %s: %w", path, v.Line, key, err) }
+        for _, f := range files {
+            if !filepath.IsAbs(f) { f = filepath.Join(filepath.Dir(path), f) }
+            parents = append(parents, f)
+        }
+    }
+    base := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+    for _, p := range parents {
+        sub, err := loadLayers(p, stack, org)
+        if err != nil { return nil, err }
+        base = mergeNodes(base, sub)
+    }
+    return mergeNodes(base, root), nil
+}
+
+func (o origins) mark(n *yaml.Node, src string) {
+    o[n] = src
+    for _, c := range n.Content { o.mark(c, src) }
+}
+
+// takeKey removes key from a mapping node and returns its value.
+func takeKey(m *yaml.Node, key string) *yaml.Node {
+    for i := 0; i+1 < len(m.Content); i += 2 {
+        if m.Content[i].Value == key {
+            v := m.Content[i+1]
+            m.Content = append(m.Content[:i], m.Content[i+2:]...)
+            return v
+        }
+    }
+    return nil
+}
+
+func stringList(n *yaml.Node) ([]string, error) {
+    switch n.Kind {
+    case yaml.ScalarNode:
+        return []string{n.Value}, nil
+    case yaml.SequenceNode:
+        var out []string
+        for _, c := range n.Content {
+            if c.Kind != yaml.ScalarNode { return nil, fmt.Errorf("expected a file path") }
+            out = append(out, c.Value)
+        }
+        return out, nil
+    }
+    return nil, fmt.Errorf("expected a file path or list of file paths")
+}
+
+// mergeNodes deep-merges src over dst. Mappings merge key by key; sequences
+// and scalars in src replace those in dst.
+func mergeNodes(dst, src *yaml.Node) *yaml.Node {
+    if dst == nil || dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode { return src }
+    for i := 0; i+1 < len(src.Content); i += 2 {
+        k, v := src.Content[i], src.Content[i+1]
+        found := false
+        for j := 0; j+1 < len(dst.Content); j += 2 {
+            if dst.Content[j].Value == k.Value {
+                dst.Content[j+1] = mergeNodes(dst.Content[j+1], v)
+                found = true
+                break
+            }
+        }
+        if !found { dst.Content = append(dst.Content, k, v) }
+    }
+    return dst
+}
+
+// applyEnv overrides fields from HERMETICA_* variables. Names are matched
+// against the yaml keys of Config, so underscores inside keys are fine.
+func applyEnv(root *yaml.Node, environ []string, org origins) error {
+    sort.Strings(environ)
+    for _, kv := range environ {
+        name, val, ok := strings.Cut(kv, "=")
+        if !ok || !strings.HasPrefix(name, EnvPrefix) { continue }
+        segs, ok := envPath(reflect.TypeOf(Config{}), strings.TrimPrefix(name, EnvPrefix))
+        if !ok { continue }
+        var doc yaml.Node
+        if err := yaml.Unmarshal([]byte(val), &doc); err != nil { return fmt.Errorf("%s: %w", name, err) }
+        vn := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: ""}
+        if len(doc.Content) > 0 { vn = doc.Content[0] }
+        org.mark(vn, "env "+name)
+        setPath(root, segs, vn)
+    }
+    return nil
+}
+
+// envPath resolves an upper-case, underscore separated name to a list of
+// yaml keys (or sequence indexes) within t. Keys are tried longest first,
+// so a key containing underscores wins over a shorter key it starts with
+// and the result does not depend on map order.
+func envPath(t reflect.Type, name string) ([]string, bool) {
+    for t.Kind() == reflect.Pointer { t = t.Elem() }
+    if name == "" { return nil, true }
+    switch t.Kind() {
+    case reflect.Struct:
+        fields := yamlFields(t)
+        keys := make([]string, 0, len(fields))
+        for key := range fields { keys = append(keys, key) }
+        sort.Slice(keys, func(i, j int) bool {
+            if len(keys[i]) != len(keys[j]) { return len(keys[i]) > len(keys[j]) }
+            return keys[i] < keys[j]
+        })
+        for _, key := range keys {
+            ft := fields[key]
+            up := strings.ToUpper(key)
+            if name == up { return []string{key}, true }
+            if strings.HasPrefix(name, up+"_") {
+                if rest, ok := envPath(ft, strings.TrimPrefix(name, up+"_")); ok {
+                    return append([]string{key}, rest...), true
+                }
+            }
+        }
+    case reflect.Map:
+        return []string{strings.ToLower(name)}, true
+    case reflect.Slice:
+        idx, rest, _ := strings.Cut(name, "_")
+        if _, err := strconv.Atoi(idx); err != nil { return nil, false }
+        if tail, ok := envPath(t.Elem(), rest); ok { return append([]string{"[" + idx + "]"}, tail...), true }
+    }
+    return nil, false
+}
+
+// setPath stores val at segs below root, creating intermediate nodes.
+func setPath(root *yaml.Node, segs []string, val *yaml.Node) {
+    n := root
+    for i, seg := range segs {
+        last := i == len(segs)-1
+        if strings.HasPrefix(seg, "[") {
+            idx, _ := strconv.Atoi(strings.Trim(seg, "[]"))
+            if n.Kind != yaml.SequenceNode { *n = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"} }
+            for len(n.Content) <= idx { n.Content = append(n.Content, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}) }
+            if last { n.Content[idx] = val; return }
+            n = n.Content[idx]
+            continue
+        }
+        if n.Kind != yaml.MappingNode { *n = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"} }
+        var next *yaml.Node
+        for j := 0; j+1 < len(n.Content); j += 2 {
+            if n.Content[j].Value == seg {
+                if last { n.Content[j+1] = val; return }
+                next = n.Content[j+1]
+                break
+            }
+        }
+        if next == nil {
+            k := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: seg}
+            if last { n.Content = append(n.Content, k, val); return }
+            next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+            n.Content = append(n.Content, k, next)
+        }
+        n = next
+    }
+}
+
+// interpolate expands ${VAR} and ${VAR:-default} in every scalar value.
+func interpolate(n *yaml.Node, lookup func(string) (string, bool), org origins) error {
+    if n.Kind == yaml.ScalarNode && strings.Contains(n.Value, "${") {
+        var missing []string
+        var used []string
+        out := interpRe.ReplaceAllStringFunc(n.Value, func(m string) string {
+            sub := interpRe.FindStringSubmatch(m)
+            used = append(used, sub[1])
+            if v, ok := lookup(sub[1]); ok { return v }
+            if sub[2] != "" { return sub[3] }
+            missing = append(missing, sub[1])
+            return ""
+        })
+        if len(missing) > 0 {
+            return fmt.Errorf("%s:%d: undefined environment variable %s", org[n], n.Line, strings.Join(missing, ", "))
+        }
+        if len(used) > 0 {
+            n.Value = out
+            // let the decoder re-resolve the type (e.g. "${RATE}" -> int)
+            if n.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle) == 0 { n.Tag = "" }
+            org[n] = org[n] + " via ${" + strings.Join(used, "}, ${") + "}"
+        }
+    }
+    for _, c := range n.Content {
+        if err := interpolate(c, lookup, org); err != nil { return err }
+    }
+    return nil
+}
+
+// pathKeys are the file-name settings below a config (or target) mapping.
+var pathKeys = [][]string{{"stages", "brute_dns", "wordlist"}, {"stages", "vhost_brute", "host_wordlist"}}
+
+// resolvePaths makes the relative file names of pathKeys relative to the
+// directory of the config file that set them, the way includes are
+// resolved. Values from the environment stay relative to the working
+// directory.
+func resolvePaths(root *yaml.Node, org origins) {
+    maps := []*yaml.Node{root}
+    if ts := mapValue(root, "targets"); ts != nil && ts.Kind == yaml.SequenceNode { maps = append(maps, ts.Content...) }
+    for _, m := range maps {
+        for _, keys := range pathKeys {
+            n := m
+            for _, k := range keys {
+                if n = mapValue(n, k); n == nil { break }
+            }
+            if n == nil || n.Kind != yaml.ScalarNode || n.Value == "" || filepath.IsAbs(n.Value) { continue }
+            file, _, _ := strings.Cut(org[n], " via ")
+            if file == "" || strings.HasPrefix(file, "env ") { continue }
+            n.Value = filepath.Join(filepath.Dir(file), n.Value)
+        }
+    }
+}
+
+// mapValue returns the value of key in mapping node m.
+func mapValue(m *yaml.Node, key string) *yaml.Node {
+    if m == nil || m.Kind != yaml.MappingNode { return nil }
+    for i := 0; i+1 < len(m.Content); i += 2 {
+        if m.Content[i].Value == key { return m.Content[i+1] }
+    }
+    return nil
+}
+
+// Annotated returns the effective config as a YAML tree whose values carry
+// a line comment naming the file, environment variable or default they
+// came from.
+func (c *Config) Annotated() (*yaml.Node, error) {
+    var out yaml.Node
+    if err := out.Encode(c); err != nil { return nil, err }
+    c.annotate(&out, c.doc)
+    return &out, nil
+}
+
+func (c *Config) annotate(out, src *yaml.Node) {
+    switch out.Kind {
+    case yaml.MappingNode:
+        for i := 0; i+1 < len(out.Content); i += 2 {
+            k, v := out.Content[i], out.Content[i+1]
+            var sv *yaml.Node
+            if src != nil && src.Kind == yaml.MappingNode {
+                for j := 0; j+1 < len(src.Content); j += 2 {
+                    if src.Content[j].Value == k.Value { sv = src.Content[j+1]; break }
+                }
+            }
+            c.annotate(v, sv)
+            if v.Kind == yaml.ScalarNode || len(v.Content) == 0 { k.LineComment = c.origin(sv) }
+        }
+    case yaml.SequenceNode:
+        for i, item := range out.Content {
+            var sv *yaml.Node
+            if src != nil && src.Kind == yaml.SequenceNode && i < len(src.Content) { sv = src.Content[i] }
+            c.annotate(item, sv)
+            if item.Kind == yaml.ScalarNode { item.LineComment = c.origin(sv) }
+        }
+    }
+}
+
+func (c *Config) origin(n *yaml.Node) string {
+    if n == nil { return "default" }
+    src := c.origins[n]
+    if n.Line > 0 && !strings.HasPrefix(src, "env ") {
+        if file, rest, ok := strings.Cut(src, " via "); ok { return fmt.Sprintf("%s:%d via %s", file, n.Line, rest) }
+        return fmt.Sprintf("%s:%d", src, n.Line)
+    }
+    return src
+}
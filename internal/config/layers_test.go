@@ -0,0 +1,245 @@
+package config
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "slices"
+    "strings"
+    "testing"
+
+    "gopkg.in/yaml.v3"
+)
+
+// parseDoc returns the mapping node of a YAML document, marked as coming
+// from "test.yaml".
+func parseDoc(t *testing.T, src string, org origins) *yaml.Node {
+    t.Helper()
+    var doc yaml.Node
+    if err := yaml.Unmarshal([]byte(src), &doc); err != nil { t.Fatal(err) }
+    root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+    if len(doc.Content) > 0 { root = doc.Content[0] }
+    org.mark(root, "test.yaml")
+    return root
+}
+
+// nodeAt returns the value node at keys below a mapping node.
+func nodeAt(n *yaml.Node, keys ...string) *yaml.Node {
+    for _, k := range keys {
+        var next *yaml.Node
+        for i := 0; n != nil && i+1 < len(n.Content); i += 2 {
+            if n.Content[i].Value == k { next = n.Content[i+1]; break }
+        }
+        n = next
+    }
+    return n
+}
+
+func writeFile(t *testing.T, dir, name, body string) string {
+    t.Helper()
+    p := filepath.Join(dir, name)
+    if err := os.WriteFile(p, []byte(body), 0o644); err != nil { t.Fatal(err) }
+    return p
+}
+
+func TestEnvPath(t *testing.T) {
+    cfg := reflect.TypeOf(Config{})
+    for _, tc := range []struct {
+        name string
+        want []string // nil when the name matches nothing
+    }{
+        {"PROJECT", []string{"project"}},
+        {"SCAN_NAABU_RATE", []string{"scan", "naabu_rate"}},
+        {"SCAN_ADAPTIVE_BACKOFF_ENABLED", []string{"scan", "adaptive_backoff", "enabled"}},
+        {"TOOLS_PATHS_HTTPX", []string{"tools", "paths", "httpx"}},
+        {"TOOLS_RETRY_MAX_BACKOFF_SECONDS", []string{"tools", "retry", "max_backoff_seconds"}},
+        {"TARGETS_0_DOMAIN", []string{"targets", "[0]", "domain"}},
+        {"TARGETS_1_SCAN_NAABU_RATE", []string{"targets", "[1]", "scan", "naabu_rate"}},
+        {"STAGES_TLS_SAN_FEEDBACK_MAX_ROUNDS", []string{"stages", "tls_san_feedback", "max_rounds"}},
+        {"WATCH_STAGES_SCAN_PORTS", []string{"watch", "stages", "scan_ports"}},
+        {"NOPE", nil},
+        {"SCAN_NOPE", nil},
+        {"TARGETS_X_DOMAIN", nil},
+    } {
+        got, ok := envPath(cfg, tc.name)
+        if ok != (tc.want != nil) || !slices.Equal(got, tc.want) { t.Errorf("envPath(%s) = %v, %v; want %v", tc.name, got, ok, tc.want) }
+    }
+}
+
+func TestEnvPathPrefersLongestKey(t *testing.T) {
+    // "a_b" and "a" followed by "b" spell the same variable; the longer key
+    // must win every time, whatever order the fields map yields.
+    type inner struct{ B string `yaml:"b"` }
+    type amb struct {
+        A   inner  `yaml:"a"`
+        AB  string `yaml:"a_b"`
+        ABC inner  `yaml:"a_b_c"`
+    }
+    for i := 0; i < 50; i++ {
+        if got, _ := envPath(reflect.TypeOf(amb{}), "A_B"); !slices.Equal(got, []string{"a_b"}) { t.Fatalf("A_B -> %v, want [a_b]", got) }
+        if got, _ := envPath(reflect.TypeOf(amb{}), "A_B_C_B"); !slices.Equal(got, []string{"a_b_c", "b"}) { t.Fatalf("A_B_C_B -> %v, want [a_b_c b]", got) }
+    }
+    // A longer key that does not lead anywhere falls back to a shorter one.
+    type fallback struct {
+        A  struct{ BC string `yaml:"b_c"` } `yaml:"a"`
+        AB inner                             `yaml:"a_b"`
+    }
+    if got, _ := envPath(reflect.TypeOf(fallback{}), "A_B_C"); !slices.Equal(got, []string{"a", "b_c"}) { t.Errorf("A_B_C -> %v, want [a b_c]", got) }
+}
+
+func TestApplyEnv(t *testing.T) {
+    org := origins{}
+    root := parseDoc(t, `
+project: base
+scan:
+  naabu_rate: 500
+  profile: stealth
+targets:
+  - domain: example.com
+tools:
+  paths:
+    httpx: /usr/bin/httpx
+`, org)
+    environ := []string{
+        "HERMETICA_SCAN_NAABU_RATE=1000",
+        "HERMETICA_TOOLS_PATHS_HTTPX=/opt/httpx",
+        "HERMETICA_TOOLS_PATHS_NAABU=/opt/naabu",
+        "HERMETICA_TARGETS_0_INCLUDE_SUBDOMAINS=false",
+        "HERMETICA_TARGETS_1_DOMAIN=example.org",
+        "HERMETICA_SCOPE_INCLUDE_CIDRS=[10.0.0.0/8, 192.0.2.0/24]",
+        "HERMETICA_DNS_IPV6_ENABLED=true",
+        "HERMETICA_UNKNOWN_KEY=1",
+        "OTHER_PROJECT=ignored",
+        "HERMETICA_PROJECT",
+    }
+    if err := applyEnv(root, environ, org); err != nil { t.Fatal(err) }
+    var c Config
+    if err := root.Decode(&c); err != nil { t.Fatal(err) }
+
+    if c.Project != "base" { t.Errorf("project = %q", c.Project) }
+    if c.Scan.NaabuRate != 1000 || c.Scan.Profile != "stealth" { t.Errorf("scan = %+v", c.Scan) }
+    if c.Tools.Paths["httpx"] != "/opt/httpx" || c.Tools.Paths["naabu"] != "/opt/naabu" { t.Errorf("paths = %v", c.Tools.Paths) }
+    if len(c.Targets) != 2 || c.Targets[0].Domain != "example.com" || c.Targets[1].Domain != "example.org" { t.Fatalf("targets = %+v", c.Targets) }
+    if c.Targets[0].SubdomainsEnabled() { t.Error("targets[0].include_subdomains not overridden") }
+    if !slices.Equal(c.Scope.IncludeCIDRs, []string{"10.0.0.0/8", "192.0.2.0/24"}) { t.Errorf("include_cidrs = %v", c.Scope.IncludeCIDRs) }
+    if !c.DNS.IPv6Enabled { t.Error("dns.ipv6_enabled not overridden") }
+
+    cfg := &Config{doc: root, origins: org}
+    if got := cfg.origin(nodeAt(root, "scan", "naabu_rate")); got != "env HERMETICA_SCAN_NAABU_RATE" { t.Errorf("origin = %q", got) }
+    if got := cfg.origin(nodeAt(root, "scan", "profile")); got != "test.yaml:5" { t.Errorf("origin = %q", got) }
+}
+
+func TestApplyEnvBadValue(t *testing.T) {
+    root := parseDoc(t, "project: x\n", origins{})
+    err := applyEnv(root, []string{"HERMETICA_PROJECT=[unclosed"}, origins{})
+    if err == nil || !strings.Contains(err.Error(), "HERMETICA_PROJECT") { t.Errorf("err = %v", err) }
+}
+
+func TestInterpolate(t *testing.T) {
+    env := map[string]string{"RATE": "1200", "HOST": "example.com", "EMPTY": ""}
+    lookup := func(k string) (string, bool) { v, ok := env[k]; return v, ok }
+    org := origins{}
+    root := parseDoc(t, `
+project: "${HOST}"
+workdir: ./work/${HOST}/${MISSING:-default}
+database: ${EMPTY:-fallback}
+scan:
+  naabu_rate: ${RATE}
+  profile: '${RATE}'
+targets:
+  - domain: plain.example
+`, org)
+    if err := interpolate(root, lookup, org); err != nil { t.Fatal(err) }
+    var c Config
+    if err := root.Decode(&c); err != nil { t.Fatal(err) }
+    if c.Project != "example.com" { t.Errorf("project = %q", c.Project) }
+    if c.Workdir != "./work/example.com/default" { t.Errorf("workdir = %q", c.Workdir) }
+    if c.Database != "" { t.Errorf("database = %q; a set but empty variable is used as is", c.Database) }
+    if c.Scan.NaabuRate != 1200 { t.Errorf("naabu_rate = %d; unquoted values are re-typed", c.Scan.NaabuRate) }
+    if c.Scan.Profile != "1200" { t.Errorf("profile = %q", c.Scan.Profile) }
+    if c.Targets[0].Domain != "plain.example" { t.Errorf("domain = %q", c.Targets[0].Domain) }
+
+    cfg := &Config{doc: root, origins: org}
+    if got := cfg.origin(nodeAt(root, "workdir")); got != "test.yaml:3 via ${HOST}, ${MISSING}" { t.Errorf("origin = %q", got) }
+}
+
+func TestInterpolateMissing(t *testing.T) {
+    org := origins{}
+    root := parseDoc(t, "project: a\nworkdir: ${NOPE}/${ALSO_NOPE}\n", org)
+    err := interpolate(root, func(string) (string, bool) { return "", false }, org)
+    if err == nil { t.Fatal("no error for undefined variables") }
+    if want := "test.yaml:2: undefined environment variable NOPE, ALSO_NOPE"; err.Error() != want { t.Errorf("err = %q, want %q", err, want) }
+}
+
+func TestLoadLayers(t *testing.T) {
+    dir := t.TempDir()
+    writeFile(t, dir, "base.yaml", "project: base\nworkdir: ./base\nscan:\n  naabu_rate: 100\n  profile: stealth\n")
+    writeFile(t, dir, "frag.yaml", "scan:\n  naabu_rate: 200\n")
+    top := writeFile(t, dir, "top.yaml", "extends: base.yaml\ninclude: [frag.yaml]\nproject: top\n")
+    org := origins{}
+    doc, err := loadLayers(top, nil, org)
+    if err != nil { t.Fatal(err) }
+    var c Config
+    if err := doc.Decode(&c); err != nil { t.Fatal(err) }
+    if c.Project != "top" || c.Workdir != "./base" || c.Scan.NaabuRate != 200 || c.Scan.Profile != "stealth" { t.Errorf("merged = %q %q %+v", c.Project, c.Workdir, c.Scan) }
+}
+
+func TestLoadLayersCycle(t *testing.T) {
+    dir := t.TempDir()
+    for _, tc := range []struct {
+        name  string
+        files map[string]string
+        start string
+        chain []string
+    }{
+        {"self", map[string]string{"a.yaml": "include: a.yaml\n"}, "a.yaml", []string{"a.yaml", "a.yaml"}},
+        {"extends", map[string]string{"b.yaml": "extends: c.yaml\n", "c.yaml": "extends: b.yaml\n"}, "b.yaml", []string{"b.yaml", "c.yaml", "b.yaml"}},
+        {"through include", map[string]string{"d.yaml": "include: [e.yaml]\n", "e.yaml": "extends: f.yaml\n", "f.yaml": "include: d.yaml\n"}, "d.yaml", []string{"d.yaml", "e.yaml", "f.yaml", "d.yaml"}},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            for name, body := range tc.files { writeFile(t, dir, name, body) }
+            _, err := loadLayers(filepath.Join(dir, tc.start), nil, origins{})
+            if err == nil { t.Fatal("no error for an include cycle") }
+            var chain []string
+            for _, f := range tc.chain { chain = append(chain, filepath.Join(dir, f)) }
+            if want := "config include cycle: " + strings.Join(chain, " -> "); err.Error() != want { t.Errorf("err = %q\nwant  %q", err, want) }
+        })
+    }
+    // The same file reached along two paths is not a cycle.
+    writeFile(t, dir, "shared.yaml", "project: shared\n")
+    writeFile(t, dir, "left.yaml", "extends: shared.yaml\n")
+    top := writeFile(t, dir, "diamond.yaml", "extends: [shared.yaml, left.yaml]\n")
+    if _, err := loadLayers(top, nil, origins{}); err != nil { t.Errorf("diamond: %v", err) }
+}
+
+func TestLoadResolvesWordlists(t *testing.T) {
+    dir := t.TempDir()
+    if err := os.MkdirAll(filepath.Join(dir, "shared", "lists"), 0o755); err != nil { t.Fatal(err) }
+    if err := os.MkdirAll(filepath.Join(dir, "proj", "lists"), 0o755); err != nil { t.Fatal(err) }
+    subs := writeFile(t, filepath.Join(dir, "shared", "lists"), "subs.txt", "www\n")
+    vhosts := writeFile(t, filepath.Join(dir, "proj", "lists"), "vhosts.txt", "admin\n")
+    writeFile(t, filepath.Join(dir, "shared"), "base.yaml", "stages:\n  brute_dns:\n    enabled: true\n    wordlist: lists/subs.txt\n")
+    top := writeFile(t, filepath.Join(dir, "proj"), "h.yaml", `extends: ../shared/base.yaml
+stages:
+  vhost_brute:
+    enabled: true
+    host_wordlist: lists/vhosts.txt
+targets:
+  - domain: example.com
+    stages:
+      vhost_brute:
+        host_wordlist: lists/vhosts.txt
+`)
+    c, err := Load(top)
+    if err != nil { t.Fatal(err) }
+    if c.Stages.BruteDNS.Wordlist != subs { t.Errorf("brute_dns.wordlist = %q, want %q", c.Stages.BruteDNS.Wordlist, subs) }
+    if c.Stages.VHostBrute.HostWordlist != vhosts { t.Errorf("vhost_brute.host_wordlist = %q, want %q", c.Stages.VHostBrute.HostWordlist, vhosts) }
+    if got := c.Targets[0].Stages.VHostBrute.HostWordlist; got != vhosts { t.Errorf("targets[0] host_wordlist = %q, want %q", got, vhosts) }
+    if err := c.Validate(); err != nil { t.Error(err) }
+
+    // A value from the environment is relative to the working directory.
+    t.Setenv(EnvPrefix+"STAGES_BRUTE_DNS_WORDLIST", "words.txt")
+    c, err = Load(top)
+    if err != nil { t.Fatal(err) }
+    if c.Stages.BruteDNS.Wordlist != "words.txt" { t.Errorf("env wordlist = %q", c.Stages.BruteDNS.Wordlist) }
+}
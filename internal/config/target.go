@@ -0,0 +1,74 @@
+package config
+
+import (
+    "fmt"
+
+    "gopkg.in/yaml.v3"
+)
+
+// ForTarget returns the effective config for targets[i]: a copy of c with
+// the target's limits, scan, stages, scope and probe_matrix blocks, when
+// set, replacing the top-level ones. Load has already filled those blocks
+// with inherited values (see resolveTargets). A target's ipv6_enabled, when
+// set, replaces dns.ipv6_enabled.
+func (c *Config) ForTarget(i int) (*Config, error) {
+    if i < 0 || i >= len(c.Targets) { return nil, fmt.Errorf("target index %d out of range", i) }
+    cp := *c
+    t := c.Targets[i]
+    cp.Targets = []Target{t}
+    if t.IPv6Enabled != nil { cp.DNS.IPv6Enabled = *t.IPv6Enabled }
+    if t.Limits != nil { cp.Limits = *t.Limits }
+    if t.Scan != nil { cp.Scan = *t.Scan }
+    if t.Stages != nil { cp.Stages = *t.Stages }
+    if t.Scope != nil { cp.Scope = *t.Scope }
+    if t.Probe != nil { cp.Probe = *t.Probe }
+    return &cp, nil
+}
+
+// resolveTargets decodes each target's override blocks over a copy of the
+// top-level block, so only keys present in the target's block are replaced
+// and unset fields (including false booleans) are inherited.
+func (c *Config) resolveTargets() error {
+    for i := range c.Targets {
+        tn := c.targetNode(i)
+        if tn == nil { continue }
+        t := &c.Targets[i]
+        for j := 0; j+1 < len(tn.Content); j += 2 {
+            key, n := tn.Content[j].Value, tn.Content[j+1]
+            var err error
+            switch key {
+            case "limits":
+                t.Limits, err = overlay(c.Limits, n)
+            case "scan":
+                t.Scan, err = overlay(c.Scan, n)
+            case "stages":
+                t.Stages, err = overlay(c.Stages, n)
+            case "scope":
+                t.Scope, err = overlay(c.Scope, n)
+            case "probe_matrix":
+                t.Probe, err = overlay(c.Probe, n)
+            }
+            if err != nil { return fmt.Errorf("targets[%d].%s: %w", i, key, err) }
+        }
+    }
+    return nil
+}
+
+// overlay decodes n over a copy of base. The blocks it is used for hold no
+// maps, and yaml.v3 allocates new slices, so base is never modified.
+func overlay[T any](base T, n *yaml.Node) (*T, error) {
+    if err := n.Decode(&base); err != nil { return nil, err }
+    return &base, nil
+}
+
+// targetNode returns the mapping node of targets[i] in the merged document.
+func (c *Config) targetNode(i int) *yaml.Node {
+    if c.doc == nil { return nil }
+    for j := 0; j+1 < len(c.doc.Content); j += 2 {
+        if c.doc.Content[j].Value != "targets" { continue }
+        seq := c.doc.Content[j+1]
+        if seq.Kind != yaml.SequenceNode || i >= len(seq.Content) { return nil }
+        if n := seq.Content[i]; n.Kind == yaml.MappingNode { return n }
+    }
+    return nil
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+    "slices"
+    "strings"
+    "testing"
+)
+
+const targetsYAML = `
+limits:
+  concurrency: 10
+  retries: 2
+scan:
+  profile: stealth
+  naabu_rate: 500
+  batch_size: 128
+  adaptive_backoff:
+    enabled: true
+    min_rate: 50
+stages:
+  crawling:
+    enabled: true
+    katana:
+      max_depth: 3
+scope:
+  include_cidrs: [192.0.2.0/24]
+  allowed_domain_regex: 'example\.com$'
+probe_matrix:
+  include_direct_ip: false
+  sni_host_combinations:
+    - { sni: subdomain, host: subdomain }
+    - { sni: "", host: "" }
+dns:
+  ipv6_enabled: true
+targets:
+  - domain: plain.example.com
+  - domain: partial.example.com
+    ipv6_enabled: false
+    scan:
+      naabu_rate: 2000
+    stages:
+      crawling:
+        enabled: false
+    scope:
+      include_cidrs: [198.51.100.0/24]
+    probe_matrix:
+      include_direct_ip: true
+`
+
+func TestForTarget(t *testing.T) {
+    c, err := Load(writeFile(t, t.TempDir(), "h.yaml", targetsYAML))
+    if err != nil { t.Fatal(err) }
+
+    plain, err := c.ForTarget(0)
+    if err != nil { t.Fatal(err) }
+    if plain.Scan != c.Scan || plain.Limits != c.Limits || plain.Stages != c.Stages || !plain.DNS.IPv6Enabled { t.Errorf("target without overrides differs: %+v", plain.Scan) }
+    if len(plain.Targets) != 1 || plain.Targets[0].Domain != "plain.example.com" { t.Errorf("targets = %+v", plain.Targets) }
+
+    p, err := c.ForTarget(1)
+    if err != nil { t.Fatal(err) }
+    // scan: only naabu_rate is set, the rest is inherited.
+    want := c.Scan
+    want.NaabuRate = 2000
+    if p.Scan != want { t.Errorf("scan = %+v, want %+v", p.Scan, want) }
+    // stages: an explicit false overrides, nested siblings are inherited.
+    if p.Stages.Crawling.Enabled || p.Stages.Crawling.Katana.MaxDepth != 3 { t.Errorf("crawling = %+v", p.Stages.Crawling) }
+    // scope: lists replace, other keys are inherited.
+    if !slices.Equal(p.Scope.IncludeCIDRs, []string{"198.51.100.0/24"}) || p.Scope.AllowedDomainRegex != `example\.com$` { t.Errorf("scope = %+v", p.Scope) }
+    // probe_matrix: include_direct_ip only; the combinations are inherited.
+    if !p.Probe.IncludeDirectIP || len(p.Probe.SNIHostCombinations) != 2 { t.Errorf("probe_matrix = %+v", p.Probe) }
+    // limits: no block, inherited whole.
+    if p.Limits != c.Limits { t.Errorf("limits = %+v", p.Limits) }
+    if p.DNS.IPv6Enabled { t.Error("ipv6_enabled: false not applied") }
+
+    // The top level is left alone.
+    if c.Scan.NaabuRate != 500 || !c.Stages.Crawling.Enabled || c.Probe.IncludeDirectIP || !c.DNS.IPv6Enabled { t.Errorf("top level modified: %+v", c) }
+    if !slices.Equal(c.Scope.IncludeCIDRs, []string{"192.0.2.0/24"}) { t.Errorf("top-level include_cidrs = %v", c.Scope.IncludeCIDRs) }
+
+    if _, err := c.ForTarget(2); err == nil { t.Error("no error for an out-of-range target") }
+}
+
+func TestForTargetWithoutDocument(t *testing.T) {
+    // Configs built in code have no YAML; set blocks are used as they are.
+    scan := Scan{Profile: "thorough", NaabuRate: 10}
+    c := &Config{Scan: Scan{Profile: "stealth", NaabuRate: 500}, Targets: []Target{{Domain: "example.com", Scan: &scan}}}
+    p, err := c.ForTarget(0)
+    if err != nil { t.Fatal(err) }
+    if p.Scan != scan { t.Errorf("scan = %+v", p.Scan) }
+}
+
+func TestValidateTargetOverrides(t *testing.T) {
+    bad := strings.Replace(targetsYAML, "naabu_rate: 2000", "naabu_rate: 2000\n      batch_size: -1", 1)
+    c, err := Load(writeFile(t, t.TempDir(), "h.yaml", bad))
+    if err != nil { t.Fatal(err) }
+    err = c.Validate()
+    if err == nil || !strings.Contains(err.Error(), "targets[1].scan.batch_size") { t.Fatalf("err = %v", err) }
+    if strings.Contains(err.Error(), "targets[0]") { t.Errorf("target without overrides reported: %v", err) }
+}
@@ -0,0 +1,279 @@
+package config
+
+import (
+    "fmt"
+    "net/netip"
+    "os"
+    "reflect"
+    "regexp"
+    "strings"
+
+    "github.com/Masterminds/semver/v3"
+    "gopkg.in/yaml.v3"
+    "hermetica/internal/schedule"
+)
+
+// FieldError is a single validation problem tied to a YAML path such as
+// "scope.include_cidrs[1]". Source is the file (or env var) the value came
+// from; Line is 0 when the field is absent or was set from the environment.
+type FieldError struct {
+    Path   string
+    Source string
+    Line   int
+    Msg    string
+}
+
+func (e FieldError) Error() string {
+    switch {
+    case e.Line > 0:
+        return fmt.Sprintf("%s:%d: %s: %s", e.Source, e.Line, e.Path, e.Msg)
+    case e.Source != "":
+        return fmt.Sprintf("%s: %s: %s", e.Source, e.Path, e.Msg)
+    }
+    return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+}
+
+// ValidationError collects every problem found in a config.
+type ValidationError []FieldError
+
+func (v ValidationError) Error() string {
+    msgs := make([]string, len(v))
+    for i, e := range v { msgs[i] = e.Error() }
+    return fmt.Sprintf("invalid config (%d problems):\n  %s", len(v), strings.Join(msgs, "\n  "))
+}
+
+var knownProfiles = map[string]bool{"stealth": true, "thorough": true}
+var knownHashAlgos = map[string]bool{"xxhash": true, "sha1": true}
+var knownDNSBackends = map[string]bool{"dnsx": true, "native": true}
+
+// Validate checks the loaded config and returns a ValidationError listing
+// every problem, or nil when the config is usable.
+func (c *Config) Validate() error {
+    v := &validator{pos: map[string]*yaml.Node{}, origins: c.origins}
+    if c.doc != nil { v.walk(c.doc, reflect.TypeOf(*c), "") }
+
+    if len(c.Targets) == 0 { v.add("targets", "at least one target is required") }
+    for name, constraint := range c.Tools.Versions {
+        if _, err := semver.NewConstraint(constraint); err != nil {
+            v.add("tools.versions."+name, fmt.Sprintf("invalid version constraint %q: %v", constraint, err))
+        }
+    }
+    v.nonNegative("tools.retry.attempts", c.Tools.Retry.Attempts)
+    v.nonNegative("tools.retry.backoff_seconds", c.Tools.Retry.BackoffSeconds)
+    v.nonNegative("tools.retry.max_backoff_seconds", c.Tools.Retry.MaxBackoffSeconds)
+    v.nonNegative("dns.verify_count", c.DNS.VerifyCount)
+    v.nonNegative("dns.resolver_qps", c.DNS.ResolverQPS)
+    if c.DNS.Backend != "" && !knownDNSBackends[c.DNS.Backend] {
+        v.add("dns.backend", fmt.Sprintf("unknown backend %q (want dnsx or native)", c.DNS.Backend))
+    }
+    if c.Evidence.BodyHashAlgo != "" && !knownHashAlgos[c.Evidence.BodyHashAlgo] {
+        v.add("evidence.body_hash_algo", fmt.Sprintf("unknown algorithm %q (want xxhash or sha1)", c.Evidence.BodyHashAlgo))
+    }
+    if d := c.Evidence.NearDupeMaxDistance(); d < 0 || d > 64 {
+        v.add("evidence.near_dupe_distance", fmt.Sprintf("must be between 0 and 64, got %d", d))
+    }
+
+    if c.Snapshots.Keep != nil { v.nonNegative("snapshots.keep", *c.Snapshots.Keep) }
+    if c.Watch.Schedule != "" { v.schedule("watch.schedule", c.Watch.Schedule) }
+    for stage, spec := range c.Watch.Stages { v.schedule("watch.stages."+stage, spec) }
+
+    v.scope("", c.Scope)
+    v.limits("", c.Limits)
+    v.scan("", c.Scan)
+    v.stages("", c.Stages)
+    v.probe("", c.Probe)
+
+    // Target overrides are checked against their effective values, but only
+    // for the blocks a target sets, so inherited problems are reported once.
+    for i, t := range c.Targets {
+        prefix := fmt.Sprintf("targets[%d].", i)
+        if strings.TrimSpace(t.Domain) == "" { v.add(prefix+"domain", "domain is required") }
+        if t.Scope != nil { v.scope(prefix, *t.Scope) }
+        if t.Limits != nil { v.limits(prefix, *t.Limits) }
+        if t.Scan != nil { v.scan(prefix, *t.Scan) }
+        if t.Stages != nil { v.stages(prefix, *t.Stages) }
+        if t.Probe != nil { v.probe(prefix, *t.Probe) }
+    }
+
+    if len(v.errs) == 0 { return nil }
+    return v.errs
+}
+
+func (v *validator) scope(prefix string, s Scope) {
+    for i, c := range s.IncludeCIDRs { v.cidr(fmt.Sprintf("%sscope.include_cidrs[%d]", prefix, i), c) }
+    for i, c := range s.ExcludeCIDRs { v.cidr(fmt.Sprintf("%sscope.exclude_cidrs[%d]", prefix, i), c) }
+    v.regex(prefix+"scope.allowed_domain_regex", s.AllowedDomainRegex)
+    v.regex(prefix+"scope.denied_domain_regex", s.DeniedDomainRegex)
+}
+
+func (v *validator) limits(prefix string, l Limits) {
+    v.nonNegative(prefix+"limits.concurrency", l.Concurrency)
+    v.nonNegative(prefix+"limits.httpx_timeout_seconds", l.HTTPXTimeoutSec)
+    v.nonNegative(prefix+"limits.retries", l.Retries)
+    v.nonNegative(prefix+"limits.request_jitter_ms", l.RequestJitterMs)
+    v.nonNegative(prefix+"limits.max_body_kb", l.MaxBodyKB)
+}
+
+func (v *validator) scan(prefix string, s Scan) {
+    if s.Profile != "" && !knownProfiles[s.Profile] {
+        v.add(prefix+"scan.profile", fmt.Sprintf("unknown profile %q (want stealth or thorough)", s.Profile))
+    }
+    v.nonNegative(prefix+"scan.naabu_rate", s.NaabuRate)
+    v.nonNegative(prefix+"scan.batch_size", s.BatchSize)
+    if ab := s.AdaptiveBackoff; ab.Enabled {
+        if ab.PacketLossThreshold < 0 || ab.PacketLossThreshold > 1 {
+            v.add(prefix+"scan.adaptive_backoff.packet_loss_threshold", "must be between 0 and 1")
+        }
+        if ab.BackoffMultiplier <= 0 || ab.BackoffMultiplier > 1 {
+            v.add(prefix+"scan.adaptive_backoff.backoff_multiplier", "must be in (0, 1]")
+        }
+        if ab.RecoveryMultiplier < 1 {
+            v.add(prefix+"scan.adaptive_backoff.recovery_multiplier", "must be >= 1")
+        }
+        v.nonNegative(prefix+"scan.adaptive_backoff.min_rate", ab.MinRate)
+    }
+}
+
+func (v *validator) stages(prefix string, st Stages) {
+    p := prefix + "stages."
+    v.nonNegative(p+"brute_dns.max_candidates", st.BruteDNS.MaxCandidates)
+    if st.BruteDNS.Enabled { v.file(p+"brute_dns.wordlist", st.BruteDNS.Wordlist) }
+    v.nonNegative(p+"tls_san_feedback.max_rounds", st.TLSSANFeedback.MaxRounds)
+    v.nonNegative(p+"screenshots.rate_limit_per_min", st.Screenshots.RateLimitPerMin)
+    v.nonNegative(p+"crawling.katana.concurrency", st.Crawling.Katana.Concurrency)
+    v.nonNegative(p+"crawling.katana.timeout_seconds", st.Crawling.Katana.TimeoutSeconds)
+    v.nonNegative(p+"crawling.katana.max_depth", st.Crawling.Katana.MaxDepth)
+    v.nonNegative(p+"vhost_brute.max_hosts_per_ip", st.VHostBrute.MaxHostsPerIP)
+    if st.VHostBrute.Enabled { v.file(p+"vhost_brute.host_wordlist", st.VHostBrute.HostWordlist) }
+}
+
+func (v *validator) probe(prefix string, pm ProbeMatrix) {
+    for i, c := range pm.SNIHostCombinations {
+        p := fmt.Sprintf("%sprobe_matrix.sni_host_combinations[%d]", prefix, i)
+        if c.SNI != "" && c.SNI != "subdomain" { v.add(p+".sni", fmt.Sprintf("unknown value %q (want \"subdomain\" or \"\")", c.SNI)) }
+        if c.Host != "" && c.Host != "subdomain" { v.add(p+".host", fmt.Sprintf("unknown value %q (want \"subdomain\" or \"\")", c.Host)) }
+    }
+}
+
+type validator struct {
+    pos     map[string]*yaml.Node // key (or sequence item) node per path
+    origins origins
+    errs    ValidationError
+}
+
+func (v *validator) add(path, msg string) {
+    v.errs = append(v.errs, v.fieldError(path, v.node(path), msg))
+}
+
+func (v *validator) fieldError(path string, n *yaml.Node, msg string) FieldError {
+    fe := FieldError{Path: path, Msg: msg}
+    if n == nil { return fe }
+    fe.Source = v.origins[n]
+    if !strings.HasPrefix(fe.Source, "env ") { fe.Line = n.Line }
+    return fe
+}
+
+// node returns the node of path, falling back to the closest parent present
+// in the file.
+func (v *validator) node(path string) *yaml.Node {
+    for path != "" {
+        if n, ok := v.pos[path]; ok { return n }
+        i := strings.LastIndexAny(path, ".[")
+        if i < 0 { break }
+        path = path[:i]
+    }
+    return nil
+}
+
+func (v *validator) nonNegative(path string, n int) {
+    if n < 0 { v.add(path, fmt.Sprintf("must not be negative (got %d)", n)) }
+}
+
+func (v *validator) regex(path, expr string) {
+    if expr == "" { return }
+    if _, err := regexp.Compile(expr); err != nil { v.add(path, fmt.Sprintf("invalid regex: %v", err)) }
+}
+
+func (v *validator) schedule(path, spec string) {
+    if _, err := schedule.Parse(spec); err != nil { v.add(path, err.Error()) }
+}
+
+func (v *validator) cidr(path, s string) {
+    s = strings.TrimSpace(s)
+    var err error
+    if strings.Contains(s, "/") { _, err = netip.ParsePrefix(s) } else { _, err = netip.ParseAddr(s) }
+    if err != nil { v.add(path, fmt.Sprintf("invalid CIDR %q", s)) }
+}
+
+func (v *validator) file(path, p string) {
+    if p == "" { v.add(path, "required when the stage is enabled"); return }
+    if _, err := os.Stat(p); err != nil { v.add(path, fmt.Sprintf("cannot read %s: %v", p, err)) }
+}
+
+// walk records the position of every key and reports keys that do not map
+// to a field of t.
+func (v *validator) walk(n *yaml.Node, t reflect.Type, path string) {
+    for t.Kind() == reflect.Pointer { t = t.Elem() }
+    if n.Kind == yaml.DocumentNode {
+        for _, c := range n.Content { v.walk(c, t, path) }
+        return
+    }
+    if n.Kind == yaml.AliasNode && n.Alias != nil { n = n.Alias }
+    switch t.Kind() {
+    case reflect.Struct:
+        if n.Kind != yaml.MappingNode { return }
+        fields := yamlFields(t)
+        for i := 0; i+1 < len(n.Content); i += 2 {
+            k, val := n.Content[i], n.Content[i+1]
+            p := joinPath(path, k.Value)
+            v.pos[p] = v.keyNode(k, val)
+            ft, ok := fields[k.Value]
+            if !ok {
+                v.errs = append(v.errs, v.fieldError(p, v.pos[p], "unknown field"))
+                continue
+            }
+            v.walk(val, ft, p)
+        }
+    case reflect.Map:
+        if n.Kind != yaml.MappingNode { return }
+        for i := 0; i+1 < len(n.Content); i += 2 {
+            k, val := n.Content[i], n.Content[i+1]
+            p := joinPath(path, k.Value)
+            v.pos[p] = v.keyNode(k, val)
+            v.walk(val, t.Elem(), p)
+        }
+    case reflect.Slice:
+        if n.Kind != yaml.SequenceNode { return }
+        for i, item := range n.Content {
+            p := fmt.Sprintf("%s[%d]", path, i)
+            v.pos[p] = item
+            v.walk(item, t.Elem(), p)
+        }
+    }
+}
+
+// keyNode picks the node that best locates a key. Keys created by env
+// overrides have no origin of their own, so the value's origin is used.
+func (v *validator) keyNode(k, val *yaml.Node) *yaml.Node {
+    if _, ok := v.origins[k]; ok { return k }
+    return val
+}
+
+func joinPath(parent, key string) string {
+    if parent == "" { return key }
+    return parent + "." + key
+}
+
+// yamlFields maps the yaml key of every exported field of t to its type.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+    out := map[string]reflect.Type{}
+    for i := 0; i < t.NumField(); i++ {
+        f := t.Field(i)
+        if !f.IsExported() { continue }
+        name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+        if name == "-" { continue }
+        if name == "" { name = strings.ToLower(f.Name) }
+        out[name] = f.Type
+    }
+    return out
+}
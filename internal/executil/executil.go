@@ -3,8 +3,21 @@ package executil
 import (
     "bufio"
     "context"
+    "errors"
+    "io"
+    "io/fs"
+    "net/url"
     "os/exec"
+    "path/filepath"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "syscall"
     "time"
+
+    "github.com/rs/zerolog"
+    "github.com/rs/zerolog/log"
 )
 
 type LineHandler func([]byte) error
@@ -15,36 +28,299 @@ type CmdSpec struct {
     Timeout time.Duration
     Env []string
     Dir string
+    Stderr LineHandler // called for each stderr line in addition to logging it
+    Tool string        // name for logs and errors; defaults to the base name of Path
+    TailLines int      // stderr lines kept for errors; default 20
+    Retry RetryPolicy
+    KillGrace time.Duration // wait between SIGTERM and SIGKILL on cancellation; default 10s
+}
+
+// RetryPolicy retries timeouts and non-zero exits. Lines already handed to
+// the LineHandler cannot be taken back, so an attempt is only retried when
+// it produced no stdout.
+type RetryPolicy struct {
+    Attempts   int           // total attempts; 0 or 1 disables retries
+    Backoff    time.Duration // wait before the second attempt, doubled after each retry
+    MaxBackoff time.Duration // cap for the wait; 0 means no cap
 }
 
+// Kind classifies why a tool failed.
+type Kind string
+
+const (
+    KindTimeout    Kind = "timeout"
+    KindKilled     Kind = "killed"
+    KindMissing    Kind = "missing binary"
+    KindPermission Kind = "permission denied"
+    KindExit       Kind = "non-zero exit"
+    // KindOutput is recorded in Exec.Result when reading the tool's stdout
+    // or the line handler failed; RunJSONL returns that error as is.
+    KindOutput     Kind = "output error"
+)
+
+// Error is returned by RunJSONL when the tool could not be started or did
+// not exit cleanly. Stderr holds the last lines the tool wrote there. The
+// message leaves out Tool since callers already wrap it with the tool name.
+type Error struct {
+    Tool     string
+    Kind     Kind
+    ExitCode int // -1 unless Kind is KindExit or KindPermission
+    Attempts int
+    Stderr   []string
+    Err      error
+}
+
+func (e *Error) Error() string {
+    var b strings.Builder
+    b.WriteString(string(e.Kind))
+    if e.Err != nil { b.WriteString(" (" + e.Err.Error() + ")") }
+    if e.Attempts > 1 { b.WriteString(" after " + strconv.Itoa(e.Attempts) + " attempts") }
+    if len(e.Stderr) > 0 { b.WriteString("; stderr: " + strings.Join(e.Stderr, " | ")) }
+    return b.String()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Retryable reports whether the failure may be transient.
+func (e *Error) Retryable() bool { return e.Kind == KindTimeout || e.Kind == KindExit }
+
+type stageKey struct{}
+type observerKey struct{}
+
+// Exec describes one finished tool invocation. Command is the full command
+// line with secrets redacted; ExitCode is -1 when the tool did not exit on
+// its own.
+type Exec struct {
+    Tool      string    `json:"tool"`
+    Command   []string  `json:"command"`
+    StartedAt time.Time `json:"started_at"`
+    EndedAt   time.Time `json:"ended_at"`
+    ExitCode  int       `json:"exit_code"`
+    Result    string    `json:"result"` // "ok" or the failure Kind
+}
+
+// WithObserver makes RunJSONL report every attempt run under ctx to fn.
+func WithObserver(ctx context.Context, fn func(Exec)) context.Context {
+    return context.WithValue(ctx, observerKey{}, fn)
+}
+
+// WithStage tags ctx with the pipeline stage so tool stderr is logged
+// with a stage field.
+func WithStage(ctx context.Context, stage string) context.Context {
+    return context.WithValue(ctx, stageKey{}, stage)
+}
+
+// RunJSONL runs spec and calls onLine for every stdout line. Stderr is
+// logged line by line with tool and stage fields, and its last lines are
+// attached to the returned *Error.
 func RunJSONL(ctx context.Context, spec CmdSpec, onLine LineHandler) error {
-    cctx := ctx
-    var cancel context.CancelFunc
+    if spec.Tool == "" { spec.Tool = filepath.Base(spec.Path) }
+    if spec.TailLines <= 0 { spec.TailLines = 20 }
+    wait := spec.Retry.Backoff
+    for attempt := 1; ; attempt++ {
+        lines, err := runOnce(ctx, spec, onLine)
+        var xe *Error
+        if err == nil || !errors.As(err, &xe) { return err }
+        xe.Attempts = attempt
+        if attempt >= spec.Retry.Attempts || !xe.Retryable() || lines > 0 || ctx.Err() != nil { return xe }
+        log.Warn().Str("tool", spec.Tool).Str("stage", StageOf(ctx)).Str("kind", string(xe.Kind)).Int("attempt", attempt).Dur("backoff", wait).Msg("retrying tool")
+        select {
+        case <-ctx.Done():
+            return xe
+        case <-time.After(wait):
+        }
+        wait *= 2
+        if spec.Retry.MaxBackoff > 0 && wait > spec.Retry.MaxBackoff { wait = spec.Retry.MaxBackoff }
+    }
+}
+
+// runOnce runs the tool a single time and returns how many stdout lines
+// were passed to onLine.
+func runOnce(ctx context.Context, spec CmdSpec, onLine LineHandler) (int, error) {
+    cctx, cancel := context.WithCancel(ctx)
+    defer cancel()
     if spec.Timeout > 0 {
-        cctx, cancel = context.WithTimeout(ctx, spec.Timeout)
-        defer cancel()
+        var stop context.CancelFunc
+        cctx, stop = context.WithTimeout(cctx, spec.Timeout)
+        defer stop()
     }
     cmd := exec.CommandContext(cctx, spec.Path, spec.Args...)
     if spec.Env != nil { cmd.Env = append(cmd.Env, spec.Env...) }
     if spec.Dir != "" { cmd.Dir = spec.Dir }
+    // Tools run in their own process group so cancellation reaches their
+    // children and a terminal Ctrl-C does not kill them before hermetica
+    // has saved its partial output. Cancel sends SIGTERM to the group and
+    // SIGKILL after KillGrace to whatever is still running. The SIGKILL
+    // timer is stopped once Wait returns so it cannot hit a reused pgid.
+    grace := spec.KillGrace
+    if grace <= 0 { grace = 10 * time.Second }
+    cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+    var kill *time.Timer // set by Cancel, which Wait waits for
+    cmd.Cancel = func() error {
+        pgid := -cmd.Process.Pid
+        kill = time.AfterFunc(grace, func() { _ = syscall.Kill(pgid, syscall.SIGKILL) })
+        return syscall.Kill(pgid, syscall.SIGTERM)
+    }
 
     stdout, err := cmd.StdoutPipe()
-    if err != nil { return err }
+    if err != nil { return 0, err }
     stderr, err := cmd.StderrPipe()
-    if err != nil { return err }
-    if err := cmd.Start(); err != nil { return err }
+    if err != nil { return 0, err }
+    ex := Exec{Tool: spec.Tool, Command: Redact(append([]string{spec.Path}, spec.Args...)), StartedAt: time.Now().UTC(), ExitCode: -1, Result: "ok"}
+    if err := cmd.Start(); err != nil {
+        xe := classify(ctx, cctx, spec.Tool, err, nil)
+        ex.Result = string(xe.Kind)
+        observe(ctx, ex)
+        return 0, xe
+    }
 
-    // Drain stderr to avoid blocking
+    tail := &tail{max: spec.TailLines}
+    logger := log.With().Str("tool", spec.Tool).Str("stage", StageOf(ctx)).Logger()
+    errDone := make(chan struct{})
     go func(){
+        defer close(errDone)
         scanner := bufio.NewScanner(stderr)
-        for scanner.Scan() { /* discard or log */ }
+        for scanner.Scan() {
+            line := scanner.Text()
+            tail.add(line)
+            logger.WithLevel(stderrLevel(line)).Msg(line)
+            if spec.Stderr != nil { _ = spec.Stderr(scanner.Bytes()) }
+        }
     }()
 
-    scanner := bufio.NewScanner(stdout)
+    n, err := stream(stdout, onLine)
+    if err == nil {
+        <-errDone
+        werr := cmd.Wait()
+        if kill != nil { kill.Stop() }
+        if werr != nil {
+            xe := classify(ctx, cctx, spec.Tool, werr, tail.lines())
+            ex.ExitCode, ex.Result, err = xe.ExitCode, string(xe.Kind), xe
+        } else {
+            ex.ExitCode = 0
+        }
+    } else {
+        // The output is unusable: stop the tool and reap it before returning.
+        cancel()
+        <-errDone
+        _ = cmd.Wait()
+        if kill != nil { kill.Stop() }
+        ex.Result = string(KindOutput)
+    }
+    observe(ctx, ex)
+    return n, err
+}
+
+func observe(ctx context.Context, ex Exec) {
+    if fn, ok := ctx.Value(observerKey{}).(func(Exec)); ok {
+        ex.EndedAt = time.Now().UTC()
+        fn(ex)
+    }
+}
+
+// stream passes each line of r to onLine and returns how many it passed.
+func stream(r io.Reader, onLine LineHandler) (int, error) {
+    n := 0
+    scanner := bufio.NewScanner(r)
     for scanner.Scan() {
-        if err := onLine(scanner.Bytes()); err != nil { return err }
+        n++
+        if err := onLine(scanner.Bytes()); err != nil { return n, err }
+    }
+    return n, scanner.Err()
+}
+
+// secretFlag matches flags whose value is a credential.
+var secretFlag = regexp.MustCompile(`(?i)^--?[\w-]*(token|secret|passw(or)?d|api-?key|auth)[\w-]*$`)
+
+// secretHeader matches request headers that carry credentials.
+var secretHeader = regexp.MustCompile(`(?i)^\s*(authorization|proxy-authorization|cookie|x-api-key|[\w-]*token)\s*:`)
+
+// Redact returns a copy of a command line with credentials replaced by
+// "REDACTED": values of flags named like tokens, secrets, passwords, API
+// keys or auth, credential headers, and user:password in URLs.
+func Redact(argv []string) []string {
+    out := make([]string, len(argv))
+    for i, a := range argv {
+        switch {
+        case i > 0 && secretFlag.MatchString(argv[i-1]):
+            a = "REDACTED"
+        case secretHeader.MatchString(a):
+            a = a[:strings.Index(a, ":")+1] + " REDACTED"
+        default:
+            if k := strings.Index(a, "="); k > 0 && secretFlag.MatchString(a[:k]) { a = a[:k+1] + "REDACTED" }
+            if u, err := url.Parse(a); err == nil && u.User != nil && u.Host != "" {
+                if _, ok := u.User.Password(); ok { u.User = url.UserPassword(u.User.Username(), "REDACTED"); a = u.String() }
+            }
+        }
+        out[i] = a
+    }
+    return out
+}
+
+// classify maps a start or wait error to an *Error. ctx is the caller's
+// context and cctx the one carrying the spec timeout.
+func classify(ctx, cctx context.Context, tool string, err error, stderr []string) *Error {
+    e := &Error{Tool: tool, Kind: KindExit, ExitCode: -1, Stderr: stderr, Err: err}
+    var ee *exec.ExitError
+    switch {
+    case errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist):
+        e.Kind = KindMissing
+    case errors.Is(err, fs.ErrPermission):
+        e.Kind = KindPermission
+    case ctx.Err() == nil && errors.Is(cctx.Err(), context.DeadlineExceeded):
+        e.Kind = KindTimeout
+    case ctx.Err() != nil:
+        e.Kind = KindKilled
+    case errors.As(err, &ee):
+        if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+            e.Kind = KindKilled
+            break
+        }
+        e.ExitCode = ee.ExitCode()
+        if mentionsPermission(stderr) { e.Kind = KindPermission }
     }
-    if err := scanner.Err(); err != nil { return err }
-    return cmd.Wait()
+    return e
 }
 
+// mentionsPermission spots tools that exit non-zero for lack of privileges,
+// e.g. naabu SYN scans without CAP_NET_RAW.
+func mentionsPermission(lines []string) bool {
+    for _, l := range lines {
+        l = strings.ToLower(l)
+        if strings.Contains(l, "operation not permitted") || strings.Contains(l, "permission denied") || strings.Contains(l, "cap_net_raw") { return true }
+    }
+    return false
+}
+
+// stderrLevel logs the ProjectDiscovery [ERR]/[FTL] and [WRN] prefixes at
+// error and warn level and everything else at debug.
+func stderrLevel(line string) zerolog.Level {
+    switch {
+    case strings.Contains(line, "[ERR]") || strings.Contains(line, "[FTL]"):
+        return zerolog.ErrorLevel
+    case strings.Contains(line, "[WRN]"):
+        return zerolog.WarnLevel
+    }
+    return zerolog.DebugLevel
+}
+
+// StageOf returns the stage set by WithStage, or "".
+func StageOf(ctx context.Context) string { s, _ := ctx.Value(stageKey{}).(string); return s }
+
+// tail keeps the last max lines written to it.
+type tail struct {
+    mu  sync.Mutex
+    max int
+    buf []string
+}
+
+func (t *tail) add(s string) {
+    t.mu.Lock(); defer t.mu.Unlock()
+    t.buf = append(t.buf, s)
+    if len(t.buf) > t.max { t.buf = t.buf[len(t.buf)-t.max:] }
+}
+
+func (t *tail) lines() []string {
+    t.mu.Lock(); defer t.mu.Unlock()
+    return append([]string(nil), t.buf...)
+}
@@ -0,0 +1,40 @@
+package executil
+
+import (
+    "context"
+    "os/exec"
+    "strings"
+    "time"
+
+    "github.com/Masterminds/semver/v3"
+)
+
+// Version runs the tool at path with -version, falling back to --version,
+// and returns the trimmed output and the version parsed from it. v is nil
+// when the output holds nothing that looks like a version.
+func Version(ctx context.Context, path string) (raw string, v *semver.Version, err error) {
+    ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+    defer cancel()
+    out, err := exec.CommandContext(ctx, path, "-version").CombinedOutput()
+    if err != nil {
+        // Fallbacks
+        out2, err2 := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+        if err2 != nil { return "", nil, err }
+        out = out2
+    }
+    raw = strings.TrimSpace(string(out))
+    v, _ = ParseVersion(raw)
+    return raw, v, nil
+}
+
+// ParseVersion extracts the first field of s that parses as a semantic
+// version, with or without a leading "v".
+func ParseVersion(s string) (*semver.Version, error) {
+    // Attempt to extract something that looks like vX.Y.Z
+    for _, f := range strings.Fields(s) {
+        f = strings.TrimPrefix(f, "v")
+        if v, err := semver.NewVersion(f); err == nil { return v, nil }
+    }
+    // Try as-is
+    return semver.NewVersion(strings.TrimPrefix(s, "v"))
+}
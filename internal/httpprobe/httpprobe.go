@@ -0,0 +1,90 @@
+// Package httpprobe sends single HTTP requests to an IP:port with explicit
+// Host header and TLS SNI control, for stages where httpx cannot vary them
+// per request.
+package httpprobe
+
+import (
+    "context"
+    "crypto/tls"
+    "fmt"
+    "html"
+    "io"
+    "net"
+    "net/http"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+type Request struct {
+    Scheme  string // http or https
+    IP      string
+    Port    int
+    Host    string // Host header; empty means the IP
+    SNI     string // TLS server name; empty sends none
+    Path    string
+    MaxBody int64 // bytes of body to read; 0 reads nothing
+    Timeout time.Duration
+}
+
+type Response struct {
+    Status        int
+    Title         string
+    Location      string
+    ContentLength int64 // bytes actually received, capped by MaxBody
+    Body          []byte
+}
+
+// URL returns the request URL addressed to the IP.
+func (r Request) URL() string {
+    path := r.Path
+    if path == "" { path = "/" }
+    return fmt.Sprintf("%s://%s%s", r.Scheme, net.JoinHostPort(r.IP, strconv.Itoa(r.Port)), path)
+}
+
+// Do performs r without following redirects and without verifying
+// certificates; the connection always goes to r.IP regardless of Host.
+func Do(ctx context.Context, r Request) (*Response, error) {
+    if r.Timeout <= 0 { r.Timeout = 10 * time.Second }
+    addr := net.JoinHostPort(r.IP, strconv.Itoa(r.Port))
+    dialer := &net.Dialer{Timeout: r.Timeout}
+    tr := &http.Transport{
+        DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+            return dialer.DialContext(ctx, network, addr)
+        },
+        TLSClientConfig:   &tls.Config{ServerName: r.SNI, InsecureSkipVerify: true}, //nolint:gosec // probing arbitrary hosts
+        DisableKeepAlives: true,
+        ForceAttemptHTTP2: false,
+    }
+    defer tr.CloseIdleConnections()
+    client := &http.Client{
+        Transport:     tr,
+        Timeout:       r.Timeout,
+        CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL(), nil)
+    if err != nil { return nil, err }
+    if r.Host != "" { req.Host = r.Host }
+    req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) hermetica")
+    resp, err := client.Do(req)
+    if err != nil { return nil, err }
+    defer resp.Body.Close()
+    out := &Response{Status: resp.StatusCode, Location: resp.Header.Get("Location")}
+    if r.MaxBody > 0 {
+        out.Body, err = io.ReadAll(io.LimitReader(resp.Body, r.MaxBody))
+        if err != nil && len(out.Body) == 0 { return nil, err }
+        out.ContentLength = int64(len(out.Body))
+        out.Title = Title(out.Body)
+    }
+    return out, nil
+}
+
+var titleRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// Title extracts the HTML title of body, whitespace-collapsed.
+func Title(body []byte) string {
+    m := titleRe.FindSubmatch(body)
+    if m == nil { return "" }
+    return strings.Join(strings.Fields(html.UnescapeString(string(m[1]))), " ")
+}
@@ -0,0 +1,174 @@
+package pipeline
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    "github.com/rs/zerolog/log"
+    "hermetica/internal/config"
+    "hermetica/internal/scope"
+    "hermetica/internal/store"
+)
+
+// bruteStage resolves wordlist candidates and merges the hits into
+// subdomains.jsonl.
+type bruteStage struct{}
+
+func (bruteStage) Name() string { return "brute_dns" }
+func (bruteStage) Enabled(e *Env) bool { return e.Cfg.Stages.BruteDNS.Enabled && e.Target.SubdomainsEnabled() }
+func (bruteStage) Inputs(e *Env) []string { return []string{e.Path("subdomains.jsonl"), e.Cfg.Stages.BruteDNS.Wordlist} }
+func (bruteStage) Outputs(e *Env) []string { return []string{e.Path("brute.jsonl"), e.Path("subdomains.jsonl")} }
+func (bruteStage) ConfigKey(e *Env) any { return []any{e.Cfg.Stages.BruteDNS, e.Cfg.Scope} }
+
+func (bruteStage) Run(ctx context.Context, e *Env, _ bool) error {
+    subsPath, brutePath := e.Path("subdomains.jsonl"), e.Path("brute.jsonl")
+    log.Info().Str("stage","brute_dns").Str("domain", e.Target.Domain).Msg("brute forcing subdomains")
+    if err := bruteSubdomains(ctx, e.Cfg, e.Target.Domain, e.Dir, subsPath, brutePath, e.gate, e.DB); err != nil { return err }
+    added, err := mergeSubdomains(subsPath, brutePath)
+    if err != nil { return err }
+    log.Info().Str("stage","brute_dns").Int("added", added).Msg("merged into subdomains.jsonl")
+    return nil
+}
+
+// bruteSubdomains builds <word>.<zone> candidates for the target domain and
+// every in-scope parent zone seen in subsPath, resolves them with wildcard
+// filtering and writes the hits to brutePath as subfinder-shaped rows.
+func bruteSubdomains(ctx context.Context, cfg *config.Config, domain, wdir, subsPath, brutePath string, gate *scopeGate, db *store.DB) error {
+    domain = scope.NormalizeHost(domain)
+    words, err := readWordlist(cfg.Stages.BruteDNS.Wordlist)
+    if err != nil { return fmt.Errorf("wordlist: %w", err) }
+    known, err := readSubdomainHosts(subsPath)
+    if err != nil { return err }
+
+    zoneSet := map[string]struct{}{}
+    for h := range known {
+        for _, z := range parentZones(h, domain) { zoneSet[z] = struct{}{} }
+    }
+    delete(zoneSet, domain)
+    zones := []string{domain}
+    for z := range zoneSet {
+        if gate.host("brute_zone", z) { zones = append(zones, z) }
+    }
+    sort.Strings(zones[1:])
+    if err := gate.flush(ctx, "brute_dns"); err != nil { return err }
+
+    // Words are the outer loop so a capped run still covers every zone with
+    // the most common labels first.
+    max := cfg.Stages.BruteDNS.MaxCandidates
+    seen := map[string]struct{}{}
+    var sb strings.Builder
+    n := 0
+    fill:
+    for _, w := range words {
+        for _, z := range zones {
+            c := w + "." + z
+            if _, dup := known[c]; dup { continue }
+            if _, dup := seen[c]; dup { continue }
+            if ok, _ := gate.eng.CheckHost(c); !ok { continue }
+            seen[c] = struct{}{}
+            sb.WriteString(c + "\n")
+            n++
+            if max > 0 && n >= max { break fill }
+        }
+    }
+    log.Info().Str("stage", "brute_dns").Int("words", len(words)).Int("zones", len(zones)).Int("candidates", n).Msg("generated candidates")
+
+    candPath := filepath.Join(wdir, "brute-candidates.txt")
+    if err := os.WriteFile(candPath, []byte(sb.String()), 0o644); err != nil { return err }
+    name, resolve := resolverFor(cfg)
+    rawPath := filepath.Join(wdir, "brute-resolved.raw.jsonl")
+    if err := resolve(ctx, cfg, candPath, rawPath); err != nil { return fmt.Errorf("%s: %w", name, err) }
+    hitsPath := rawPath
+    if cfg.DNS.WildcardFilter {
+        hitsPath = filepath.Join(wdir, "brute-resolved.jsonl")
+        if err := filterWildcards(ctx, cfg, resolve, domain, wdir, rawPath, hitsPath, filepath.Join(wdir, "brute-wildcards.jsonl"), db); err != nil {
+            return fmt.Errorf("wildcard filter: %w", err)
+        }
+    }
+    _, recs, err := readResolved(hitsPath)
+    if err != nil { return err }
+
+    hosts := make([]string, 0, len(recs))
+    for _, r := range recs {
+        if _, ok := seen[r.Host]; ok { hosts = append(hosts, r.Host) }
+    }
+    sort.Strings(hosts)
+    var out strings.Builder
+    for _, h := range hosts {
+        b, _ := json.Marshal(map[string]string{"host": h, "source": "brute"})
+        out.Write(append(b, '\n'))
+    }
+    log.Info().Str("stage", "brute_dns").Int("hits", len(hosts)).Msg("brute force complete")
+    if err := os.WriteFile(brutePath+".tmp", []byte(out.String()), 0o644); err != nil { return err }
+    return os.Rename(brutePath+".tmp", brutePath)
+}
+
+// mergeSubdomains appends rows of extraPath whose host is not yet in
+// subsPath. It is idempotent and returns the number of rows added.
+func mergeSubdomains(subsPath, extraPath string) (int, error) {
+    known, err := readSubdomainHosts(subsPath)
+    if err != nil { return 0, err }
+    existing, err := os.ReadFile(subsPath)
+    if err != nil { return 0, err }
+    in, err := os.Open(extraPath)
+    if err != nil { return 0, err }
+    defer in.Close()
+    var add []byte
+    added := 0
+    sc := bufio.NewScanner(in)
+    for sc.Scan() {
+        var row struct{ Host string `json:"host"` }
+        if err := json.Unmarshal(sc.Bytes(), &row); err != nil || row.Host == "" { continue }
+        h := scope.NormalizeHost(row.Host)
+        if _, ok := known[h]; ok { continue }
+        known[h] = struct{}{}
+        add = append(add, sc.Bytes()...)
+        add = append(add, '\n')
+        added++
+    }
+    if err := sc.Err(); err != nil { return 0, err }
+    if added == 0 { return 0, nil }
+    if len(existing) > 0 && existing[len(existing)-1] != '\n' { existing = append(existing, '\n') }
+    if err := os.WriteFile(subsPath+".tmp", append(existing, add...), 0o644); err != nil { return 0, err }
+    return added, os.Rename(subsPath+".tmp", subsPath)
+}
+
+// readSubdomainHosts returns the normalized hosts of a subfinder JSONL file.
+func readSubdomainHosts(path string) (map[string]struct{}, error) {
+    f, err := os.Open(path)
+    if err != nil { return nil, err }
+    defer f.Close()
+    out := map[string]struct{}{}
+    sc := bufio.NewScanner(f)
+    for sc.Scan() {
+        var row struct{ Host string `json:"host"` }
+        if err := json.Unmarshal(sc.Bytes(), &row); err == nil && row.Host != "" {
+            out[scope.NormalizeHost(row.Host)] = struct{}{}
+        }
+    }
+    return out, sc.Err()
+}
+
+// readWordlist returns unique, lowercased, non-comment lines of path.
+func readWordlist(path string) ([]string, error) {
+    f, err := os.Open(path)
+    if err != nil { return nil, err }
+    defer f.Close()
+    var out []string
+    seen := map[string]struct{}{}
+    sc := bufio.NewScanner(f)
+    for sc.Scan() {
+        w := strings.Trim(strings.ToLower(strings.TrimSpace(sc.Text())), ".")
+        if w == "" || strings.HasPrefix(w, "#") { continue }
+        if _, dup := seen[w]; dup { continue }
+        seen[w] = struct{}{}
+        out = append(out, w)
+    }
+    return out, sc.Err()
+}
@@ -0,0 +1,208 @@
+package pipeline
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// CheckpointFile is written to work/<domain>/ and records the state of every
+// stage of the last run there.
+const CheckpointFile = "checkpoint.json"
+
+const (
+    stageRunning = "running"
+    stageDone    = "done"
+    stageFailed  = "failed"
+    stageInterrupted = "interrupted"
+)
+
+type checkpoint struct {
+    path string
+    wdir string
+    mu   sync.Mutex
+
+    Domain     string                 `json:"domain"`
+    ConfigHash string                 `json:"config_hash"`
+    UpdatedAt  time.Time              `json:"updated_at"`
+    Stages     map[string]*stageState `json:"stages"`
+}
+
+// stageState is one stage's entry. Input and artifact checksums are SHA-256
+// of the file contents, "" for a file that did not exist.
+type stageState struct {
+    Status     string            `json:"status"`
+    StartedAt  time.Time         `json:"started_at"`
+    EndedAt    time.Time         `json:"ended_at"`
+    ConfigHash string            `json:"config_hash"`
+    Inputs     map[string]string `json:"inputs"`
+    Artifact   string            `json:"artifact"`
+    Checksum   string            `json:"checksum"`
+    Error      string            `json:"error,omitempty"`
+    Partial    *partialState     `json:"partial,omitempty"`
+}
+
+// partialState is the progress of a stage that did not finish: the
+// .partial file holding its output so far and stage-specific progress.
+type partialState struct {
+    Path     string          `json:"path"`
+    Progress json.RawMessage `json:"progress"`
+}
+
+// loadCheckpoint reads wdir's checkpoint, or starts an empty one.
+func loadCheckpoint(wdir string) (*checkpoint, error) {
+    c := &checkpoint{path: filepath.Join(wdir, CheckpointFile), wdir: wdir, Stages: map[string]*stageState{}}
+    b, err := os.ReadFile(c.path)
+    if errors.Is(err, os.ErrNotExist) { return c, nil }
+    if err != nil { return nil, err }
+    if err := json.Unmarshal(b, c); err != nil { return nil, fmt.Errorf("%s: %w", c.path, err) }
+    if c.Stages == nil { c.Stages = map[string]*stageState{} }
+    return c, nil
+}
+
+// check reports whether stage completed with cfgHash and its artifact and
+// inputs are unchanged since, with the reason when it did not.
+func (c *checkpoint) check(stage, cfgHash string) (bool, string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    s := c.Stages[stage]
+    switch {
+    case s == nil:
+        return false, "no checkpoint"
+    case s.Status != stageDone:
+        return false, "last attempt " + s.Status
+    case s.ConfigHash != cfgHash:
+        return false, "config changed"
+    case fileSum(c.abs(s.Artifact)) != s.Checksum:
+        return false, s.Artifact + " missing or modified"
+    }
+    for in, sum := range s.Inputs {
+        if fileSum(c.abs(in)) != sum { return false, "input " + in + " changed" }
+    }
+    return true, ""
+}
+
+// stale lists completed stages whose recorded config hash no longer
+// matches hashes[stage]. Stages missing from hashes are ignored.
+func (c *checkpoint) stale(hashes map[string]string) []string {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    var out []string
+    for name, s := range c.Stages {
+        h, ok := hashes[name]
+        if ok && s.Status == stageDone && s.ConfigHash != h { out = append(out, name) }
+    }
+    sort.Strings(out)
+    return out
+}
+
+func (c *checkpoint) begin(stage, cfgHash, artifact string, inputs []string) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    s := &stageState{Status: stageRunning, StartedAt: time.Now().UTC(), ConfigHash: cfgHash, Artifact: c.rel(artifact), Inputs: map[string]string{}}
+    for _, in := range inputs {
+        if in != "" { s.Inputs[c.rel(in)] = "" }
+    }
+    if prev := c.Stages[stage]; prev != nil { s.Partial = prev.Partial }
+    c.Stages[stage] = s
+    return c.save()
+}
+
+// fail marks stage failed, or interrupted when its run was cancelled.
+func (c *checkpoint) fail(stage string, err error, interrupted bool) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    status := stageFailed
+    if interrupted { status = stageInterrupted }
+    if s := c.Stages[stage]; s != nil {
+        s.Status, s.EndedAt, s.Error = status, time.Now().UTC(), err.Error()
+    }
+    return c.save()
+}
+
+// progress records the partial output of a running stage so an
+// interrupted run can continue from it.
+func (c *checkpoint) progress(stage, path string, v any) error {
+    b, err := json.Marshal(v)
+    if err != nil { return err }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    s := c.Stages[stage]
+    if s == nil { return nil }
+    s.Partial = &partialState{Path: c.rel(path), Progress: b}
+    return c.save()
+}
+
+// partial decodes the progress recorded for stage into v and reports
+// whether there was any whose .partial file still exists.
+func (c *checkpoint) partial(stage string, v any) bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    s := c.Stages[stage]
+    if s == nil || s.Partial == nil || !exists(c.abs(s.Partial.Path)) { return false }
+    return json.Unmarshal(s.Partial.Progress, v) == nil
+}
+
+// finish marks stage done. Later stages may legitimately rewrite earlier
+// artifacts (merges, page groups), so the checksums of every completed
+// stage are refreshed; a mismatch on resume then means the files changed
+// outside a run or a run died mid-stage.
+func (c *checkpoint) finish(stage string) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if s := c.Stages[stage]; s != nil { s.Status, s.EndedAt, s.Error, s.Partial = stageDone, time.Now().UTC(), "", nil }
+    for _, s := range c.Stages {
+        if s.Status != stageDone { continue }
+        s.Checksum = fileSum(c.abs(s.Artifact))
+        for in := range s.Inputs { s.Inputs[in] = fileSum(c.abs(in)) }
+    }
+    return c.save()
+}
+
+func (c *checkpoint) save() error {
+    c.UpdatedAt = time.Now().UTC()
+    b, err := json.MarshalIndent(c, "", "  ")
+    if err != nil { return err }
+    if err := os.WriteFile(c.path+".tmp", b, 0o644); err != nil { return err }
+    return os.Rename(c.path+".tmp", c.path)
+}
+
+// rel makes p relative to the target directory when it lives inside it;
+// anything else is stored as an absolute path.
+func (c *checkpoint) rel(p string) string {
+    if r, err := filepath.Rel(c.wdir, p); err == nil && !strings.HasPrefix(r, "..") { return r }
+    if a, err := filepath.Abs(p); err == nil { return a }
+    return p
+}
+
+func (c *checkpoint) abs(p string) string {
+    if filepath.IsAbs(p) { return p }
+    return filepath.Join(c.wdir, p)
+}
+
+// fileSum returns "sha256:<hex>" of the file at path, or "" when it cannot
+// be read.
+func fileSum(path string) string {
+    f, err := os.Open(path)
+    if err != nil { return "" }
+    defer f.Close()
+    h := sha256.New()
+    if _, err := io.Copy(h, f); err != nil { return "" }
+    return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// hashJSON returns the hex SHA-256 of v's JSON encoding.
+func hashJSON(v any) string {
+    b, _ := json.Marshal(v)
+    sum := sha256.Sum256(b)
+    return hex.EncodeToString(sum[:])
+}
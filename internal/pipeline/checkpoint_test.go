@@ -0,0 +1,131 @@
+package pipeline
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func writeFile(t *testing.T, dir, name, body string) string {
+    t.Helper()
+    p := filepath.Join(dir, name)
+    if err := os.WriteFile(p, []byte(body), 0o644); err != nil { t.Fatal(err) }
+    return p
+}
+
+func TestCheckpointCheck(t *testing.T) {
+    for _, tc := range []struct {
+        name   string
+        change func(t *testing.T, dir string, c *checkpoint)
+        hash   string
+        why    string
+    }{
+        {"current", func(*testing.T, string, *checkpoint) {}, "h1", ""},
+        {"config changed", func(*testing.T, string, *checkpoint) {}, "h2", "config changed"},
+        {"artifact modified", func(t *testing.T, dir string, _ *checkpoint) { writeFile(t, dir, "out.jsonl", "edited\n") }, "h1", "out.jsonl missing or modified"},
+        {"artifact missing", func(t *testing.T, dir string, _ *checkpoint) { os.Remove(filepath.Join(dir, "out.jsonl")) }, "h1", "out.jsonl missing or modified"},
+        {"input changed", func(t *testing.T, dir string, _ *checkpoint) { writeFile(t, dir, "in.txt", "b\n") }, "h1", "input in.txt changed"},
+        {"last attempt failed", func(t *testing.T, _ string, c *checkpoint) {
+            if err := c.begin("s", "h1", filepath.Join(c.wdir, "out.jsonl"), nil); err != nil { t.Fatal(err) }
+            if err := c.fail("s", errors.New("boom"), false); err != nil { t.Fatal(err) }
+        }, "h1", "last attempt failed"},
+        {"interrupted", func(t *testing.T, _ string, c *checkpoint) {
+            if err := c.begin("s", "h1", filepath.Join(c.wdir, "out.jsonl"), nil); err != nil { t.Fatal(err) }
+            if err := c.fail("s", errors.New("cancelled"), true); err != nil { t.Fatal(err) }
+        }, "h1", "last attempt interrupted"},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            dir := t.TempDir()
+            in, out := writeFile(t, dir, "in.txt", "a\n"), filepath.Join(dir, "out.jsonl")
+            c, err := loadCheckpoint(dir)
+            if err != nil { t.Fatal(err) }
+            if ok, why := c.check("s", "h1"); ok || why != "no checkpoint" { t.Fatalf("empty checkpoint: %v %q", ok, why) }
+            if err := c.begin("s", "h1", out, []string{in}); err != nil { t.Fatal(err) }
+            writeFile(t, dir, "out.jsonl", "row\n")
+            if err := c.finish("s"); err != nil { t.Fatal(err) }
+
+            // Reload from disk, as a resumed run does.
+            c, err = loadCheckpoint(dir)
+            if err != nil { t.Fatal(err) }
+            tc.change(t, dir, c)
+            ok, why := c.check("s", tc.hash)
+            if ok != (tc.why == "") || why != tc.why { t.Errorf("check = %v %q, want %q", ok, why, tc.why) }
+        })
+    }
+}
+
+func TestCheckpointPaths(t *testing.T) {
+    dir := t.TempDir()
+    c, err := loadCheckpoint(dir)
+    if err != nil { t.Fatal(err) }
+    outside := filepath.Join(t.TempDir(), "words.txt")
+    if err := c.begin("s", "h", filepath.Join(dir, "san", "web.jsonl"), []string{outside, ""}); err != nil { t.Fatal(err) }
+    s := c.Stages["s"]
+    if s.Artifact != filepath.Join("san", "web.jsonl") { t.Errorf("artifact = %q, want relative to the target dir", s.Artifact) }
+    if _, ok := s.Inputs[outside]; !ok || len(s.Inputs) != 1 { t.Errorf("inputs = %v, want only %s", s.Inputs, outside) }
+}
+
+func TestCheckpointFinishRefreshesDone(t *testing.T) {
+    // A later stage rewriting an earlier artifact (page groups) does not
+    // make the earlier stage look modified.
+    dir := t.TempDir()
+    c, err := loadCheckpoint(dir)
+    if err != nil { t.Fatal(err) }
+    web := filepath.Join(dir, "web.jsonl")
+    if err := c.begin("probe", "h", web, nil); err != nil { t.Fatal(err) }
+    writeFile(t, dir, "web.jsonl", "a\n")
+    if err := c.finish("probe"); err != nil { t.Fatal(err) }
+    if err := c.begin("san", "h", filepath.Join(dir, "san.jsonl"), []string{web}); err != nil { t.Fatal(err) }
+    writeFile(t, dir, "web.jsonl", "a page_group\n")
+    writeFile(t, dir, "san.jsonl", "b\n")
+    if err := c.finish("san"); err != nil { t.Fatal(err) }
+    for _, s := range []string{"probe", "san"} {
+        if ok, why := c.check(s, "h"); !ok { t.Errorf("%s: %s", s, why) }
+    }
+}
+
+func TestCheckpointPartial(t *testing.T) {
+    dir := t.TempDir()
+    c, err := loadCheckpoint(dir)
+    if err != nil { t.Fatal(err) }
+    out := filepath.Join(dir, "ports.jsonl")
+    part := writeFile(t, dir, "ports.jsonl.partial", "1\n")
+    type progress struct{ Done int }
+
+    if err := c.begin("scan", "h", out, nil); err != nil { t.Fatal(err) }
+    if err := c.progress("scan", part, progress{Done: 3}); err != nil { t.Fatal(err) }
+    if err := c.fail("scan", errors.New("interrupted"), true); err != nil { t.Fatal(err) }
+
+    // The next attempt keeps the progress across begin.
+    c, err = loadCheckpoint(dir)
+    if err != nil { t.Fatal(err) }
+    if err := c.begin("scan", "h", out, nil); err != nil { t.Fatal(err) }
+    var p progress
+    if !c.partial("scan", &p) || p.Done != 3 { t.Fatalf("partial = %+v", p) }
+    if c.Stages["scan"].Partial.Path != "ports.jsonl.partial" { t.Errorf("partial path = %q", c.Stages["scan"].Partial.Path) }
+
+    // Without its .partial file the progress is useless.
+    os.Remove(part)
+    if c.partial("scan", &p) { t.Error("partial reported without the .partial file") }
+
+    writeFile(t, dir, "ports.jsonl", "1\n2\n")
+    if err := c.finish("scan"); err != nil { t.Fatal(err) }
+    if c.Stages["scan"].Partial != nil { t.Error("partial kept after finish") }
+    b, err := os.ReadFile(filepath.Join(dir, CheckpointFile))
+    if err != nil { t.Fatal(err) }
+    if strings.Contains(string(b), "partial") { t.Errorf("checkpoint still records progress:\n%s", b) }
+}
+
+func TestCheckpointStale(t *testing.T) {
+    dir := t.TempDir()
+    c, err := loadCheckpoint(dir)
+    if err != nil { t.Fatal(err) }
+    for _, s := range []string{"a", "b", "c"} {
+        if err := c.begin(s, "h1", filepath.Join(dir, s), nil); err != nil { t.Fatal(err) }
+        if err := c.finish(s); err != nil { t.Fatal(err) }
+    }
+    got := c.stale(map[string]string{"a": "h1", "b": "h2", "c": "h2", "gone": "h2"})
+    if strings.Join(got, " ") != "b c" { t.Errorf("stale = %v", got) }
+}
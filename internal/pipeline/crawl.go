@@ -0,0 +1,184 @@
+package pipeline
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net"
+    "net/netip"
+    "net/url"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/rs/zerolog/log"
+    "hermetica/internal/config"
+    "hermetica/internal/store"
+    htool "hermetica/internal/tool/httpx"
+    ktool "hermetica/internal/tool/katana"
+)
+
+// crawlStage crawls one URL per unique app with katana.
+type crawlStage struct{}
+
+func (crawlStage) Name() string { return "crawl" }
+func (crawlStage) Enabled(e *Env) bool { return e.Cfg.Stages.Crawling.Enabled }
+func (crawlStage) Inputs(e *Env) []string { return e.withSAN("web.jsonl") }
+func (crawlStage) Outputs(e *Env) []string { return []string{e.Path("crawl.jsonl")} }
+func (crawlStage) ConfigKey(e *Env) any { return []any{e.Cfg.Stages.Crawling.Katana.MaxDepth, e.Cfg.Scope} }
+
+func (crawlStage) Run(ctx context.Context, e *Env, _ bool) error {
+    log.Info().Str("stage","crawl").Msg("running katana")
+    return crawlApps(ctx, e.Cfg, e.Dir, e.withSAN("web.jsonl"), e.Path("crawl.jsonl"), e.gate, e.DB)
+}
+
+// appKey groups web rows that serve the same application: by page group or
+// body hash when evidence is captured, otherwise by a response signature.
+func appKey(r htool.Result) string {
+    if r.PageGroup != "" { return "group:" + r.PageGroup }
+    if r.BodyHash != "" { return fmt.Sprintf("body:%d|%s", r.StatusCode, r.BodyHash) }
+    return fmt.Sprintf("sig:%d|%s|%s|%d", r.StatusCode, r.Title, r.Webserver, r.ContentLength)
+}
+
+// reachableURL returns a URL for r that a tool can fetch on its own, or ""
+// when the row depends on SNI/Host overrides such a tool cannot reproduce.
+// sni_host rows are addressed by hostname, direct_ip rows by IP.
+func reachableURL(r htool.Result) string {
+    u, err := url.Parse(r.URL)
+    if err != nil || u.Host == "" { return "" }
+    switch r.SNIMode {
+    case htool.ModeSNIHost:
+        if r.InputHost == "" { return "" }
+        if p := u.Port(); p != "" { u.Host = net.JoinHostPort(r.InputHost, p) } else { u.Host = r.InputHost }
+        return u.String()
+    case htool.ModeDirectIP, "":
+        return u.String()
+    }
+    return ""
+}
+
+// uniqueApps picks one representative row per application, preferring
+// hostname-addressed rows over bare IPs. Rows that cannot be reached
+// without per-request overrides are skipped.
+func uniqueApps(results []htool.Result) []htool.Result {
+    best := map[string]htool.Result{}
+    for _, r := range results {
+        if reachableURL(r) == "" { continue }
+        k := appKey(r)
+        cur, ok := best[k]
+        if !ok || (cur.SNIMode != htool.ModeSNIHost && r.SNIMode == htool.ModeSNIHost) || (cur.SNIMode == r.SNIMode && reachableURL(r) < reachableURL(cur)) {
+            best[k] = r
+        }
+    }
+    out := make([]htool.Result, 0, len(best))
+    for _, r := range best { out = append(out, r) }
+    sort.Slice(out, func(i, j int) bool { return reachableURL(out[i]) < reachableURL(out[j]) })
+    return out
+}
+
+// crawlApps runs katana over one URL per unique app in webPaths, writes the
+// rows tagged with their seed to crawl.jsonl and stores the endpoints
+// against the seed's web target.
+func crawlApps(ctx context.Context, cfg *config.Config, wdir string, webPaths []string, outPath string, gate *scopeGate, db *store.DB) error {
+    results, err := readWebResults(webPaths)
+    if err != nil { return err }
+    apps := uniqueApps(results)
+    seeds := map[string]htool.Result{} // origin -> seed row
+    var sb strings.Builder
+    for _, r := range apps {
+        u := reachableURL(r)
+        o := origin(u)
+        if _, dup := seeds[o]; dup { continue }
+        seeds[o] = r
+        sb.WriteString(u + "\n")
+    }
+    log.Info().Str("stage", "crawl").Int("web_rows", len(results)).Int("seeds", len(seeds)).Msg("selected unique apps")
+    listPath := filepath.Join(wdir, "crawl-seeds.txt")
+    if err := os.WriteFile(listPath, []byte(sb.String()), 0o644); err != nil { return err }
+    rawPath := filepath.Join(wdir, "crawl.raw.jsonl")
+    if len(seeds) > 0 {
+        if err := ktool.Run(ctx, cfg, listPath, rawPath); err != nil { return fmt.Errorf("katana: %w", err) }
+    } else if err := os.WriteFile(rawPath, nil, 0o644); err != nil { return err }
+
+    in, err := os.Open(rawPath)
+    if err != nil { return err }
+    defer in.Close()
+    wtIDs := map[string]string{}
+    var rows []map[string]json.RawMessage
+    var eps []store.Endpoint
+    sc := bufio.NewScanner(in)
+    sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+    for sc.Scan() {
+        var kr ktool.Result
+        if err := json.Unmarshal(sc.Bytes(), &kr); err != nil { continue }
+        ep := kr.Endpoint()
+        // Endpoints on another origin (e.g. an API host linked from the
+        // app) belong to the seed of the page they were found on.
+        o := origin(ep)
+        seed, ok := seeds[o]
+        if !ok {
+            o = origin(kr.Request.Source)
+            seed, ok = seeds[o]
+        }
+        if !ok || !endpointInScope(gate, ep) { continue }
+        var row map[string]json.RawMessage
+        if err := json.Unmarshal(sc.Bytes(), &row); err != nil { continue }
+        row["seed"], _ = json.Marshal(reachableURL(seed))
+        rows = append(rows, row)
+
+        wid, ok := wtIDs[o]
+        if !ok {
+            if wid, err = seedWebTargetID(ctx, db, seed); err != nil { return err }
+            wtIDs[o] = wid
+        }
+        if wid == "" { continue }
+        method := kr.Request.Method
+        if method == "" { method = "GET" }
+        eps = append(eps, store.Endpoint{WebTargetID: wid, URL: ep, Method: method, Status: kr.Response.StatusCode, Source: kr.Request.Source})
+    }
+    if err := sc.Err(); err != nil { return err }
+    if err := db.UpsertEndpoints(ctx, eps); err != nil { return err }
+    log.Info().Str("stage", "crawl").Int("endpoints", len(eps)).Msg("crawl complete")
+    return writeJSONL(outPath, rows)
+}
+
+// seedWebTargetID looks up the stored web target a crawl seed came from.
+func seedWebTargetID(ctx context.Context, db *store.DB, r htool.Result) (string, error) {
+    ip, err := netip.ParseAddr(r.Host)
+    if err != nil { return "", nil }
+    sid, err := db.ServiceID(ctx, ip.String(), r.Port, "tcp")
+    if err != nil { return "", err }
+    mode := r.SNIMode
+    if mode == "" { mode = htool.ModeDirectIP }
+    return db.WebTargetID(ctx, sid, mode, r.InputHost, r.URL)
+}
+
+// endpointInScope drops endpoints on hosts the scope does not allow.
+func endpointInScope(gate *scopeGate, raw string) bool {
+    u, err := url.Parse(raw)
+    if err != nil { return false }
+    h := u.Hostname()
+    if _, err := netip.ParseAddr(h); err == nil {
+        ok, _ := gate.eng.CheckIP(h)
+        return ok
+    }
+    ok, _ := gate.eng.CheckHost(h)
+    return ok
+}
+
+// origin returns scheme://host:port of a URL, with the default port made
+// explicit so seeds and endpoints compare equal.
+func origin(raw string) string {
+    u, err := url.Parse(raw)
+    if err != nil { return "" }
+    port := u.Port()
+    if port == "" {
+        port = "80"
+        if u.Scheme == "https" { port = "443" }
+    }
+    p, _ := strconv.Atoi(port)
+    return fmt.Sprintf("%s://%s", strings.ToLower(u.Scheme), net.JoinHostPort(strings.ToLower(u.Hostname()), strconv.Itoa(p)))
+}
@@ -0,0 +1,43 @@
+package pipeline
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "os"
+
+    "github.com/rs/zerolog/log"
+    stool "hermetica/internal/tool/subfinder"
+)
+
+// discoverStage collects passive subdomains with subfinder, or just the
+// apex when include_subdomains is off.
+type discoverStage struct{}
+
+func (discoverStage) Name() string { return "discover_subdomains" }
+func (discoverStage) Enabled(*Env) bool { return true }
+func (discoverStage) Inputs(*Env) []string { return nil }
+func (discoverStage) Outputs(e *Env) []string { return []string{e.Path("subdomains.jsonl")} }
+
+func (discoverStage) ConfigKey(e *Env) any {
+    return []any{e.Target.Domain, e.Target.SubdomainsEnabled(), e.Cfg.Tools.ProviderConfig, e.Cfg.Scope}
+}
+
+func (discoverStage) Run(ctx context.Context, e *Env, _ bool) error {
+    subsPath := e.Path("subdomains.jsonl")
+    if !e.Target.SubdomainsEnabled() {
+        log.Info().Str("stage","discover_subdomains").Str("domain", e.Target.Domain).Msg("include_subdomains disabled; using apex only")
+        return writeApexOnly(e.Target.Domain, subsPath)
+    }
+    log.Info().Str("stage","discover_subdomains").Str("domain", e.Target.Domain).Msg("running subfinder")
+    if err := stool.Run(ctx, e.Cfg, e.Target.Domain, subsPath); err != nil { return fmt.Errorf("subfinder: %w", err) }
+    return nil
+}
+
+// writeApexOnly writes a subfinder-shaped artifact holding just the apex domain.
+func writeApexOnly(domain, outJSONL string) error {
+    b, err := json.Marshal(map[string]string{"host": domain, "source": "apex"})
+    if err != nil { return err }
+    if err := os.WriteFile(outJSONL+".tmp", append(b, '\n'), 0o644); err != nil { return err }
+    return os.Rename(outJSONL+".tmp", outJSONL)
+}
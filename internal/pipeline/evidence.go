@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+    "bufio"
+    "context"
+    "crypto/sha1"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+
+    "github.com/cespare/xxhash/v2"
+    "hermetica/internal/config"
+    "hermetica/internal/store"
+    htool "hermetica/internal/tool/httpx"
+)
+
+// probeWeb runs the httpx matrix into <webPath>.raw and turns the raw rows
+// into webPath with captureEvidence.
+func probeWeb(ctx context.Context, cfg *config.Config, groups []htool.ProbeGroup, partsDir, wdir, webPath string, db *store.DB) error {
+    raw := webPath + ".raw"
+    if err := htool.RunMatrix(ctx, cfg, groups, partsDir, raw); err != nil { return fmt.Errorf("httpx: %w", err) }
+    if err := captureEvidence(ctx, cfg, wdir, raw, webPath, db); err != nil { return fmt.Errorf("evidence: %w", err) }
+    return os.Remove(raw)
+}
+
+// captureEvidence copies raw httpx rows to outPath, tagging each with its web
+// target ID and replacing the response body with its hash, its SimHash when
+// near_dupe is set and, when store_body_sample is set, the path of a copy
+// under bodies/<id>.bin. The raw request and response that -irr adds are
+// dropped, so no uncapped body reaches outPath. IDs of
+// rows already in the store are reused so samples stay stable across runs.
+func captureEvidence(ctx context.Context, cfg *config.Config, wdir, rawPath, outPath string, db *store.DB) error {
+    in, err := os.Open(rawPath)
+    if err != nil { return err }
+    defer in.Close()
+    out, err := os.Create(outPath + ".tmp")
+    if err != nil { return err }
+    defer out.Close()
+    bodiesDir := filepath.Join(wdir, "bodies")
+    limit := cfg.Limits.MaxBodyKB * 1024
+    w := bufio.NewWriter(out)
+    sc := bufio.NewScanner(in)
+    sc.Buffer(make([]byte, 0, 1024*1024), 64*1024*1024)
+    for sc.Scan() {
+        line := sc.Bytes()
+        var r htool.Result
+        var row map[string]json.RawMessage
+        if json.Unmarshal(line, &r) != nil || json.Unmarshal(line, &row) != nil { continue }
+        id, err := seedWebTargetID(ctx, db, r)
+        if err != nil { return err }
+        if id == "" { id = store.NewID() }
+        row["webtarget_id"] = quoteJSON(id)
+        if _, ok := row["body"]; ok {
+            body := []byte(r.Body)
+            if limit > 0 && len(body) > limit { body = body[:limit] }
+            if cfg.Evidence.StoreBodyHash { row["body_hash"] = quoteJSON(hashBody(cfg.Evidence.BodyHashAlgo, body)) }
+            if cfg.Evidence.StoreBodySample {
+                p := filepath.Join(bodiesDir, id+".bin")
+                if err := os.MkdirAll(bodiesDir, 0o755); err != nil { return err }
+                if err := os.WriteFile(p, body, 0o644); err != nil { return err }
+                row["body_path"] = quoteJSON(p)
+            }
+            if cfg.Evidence.NearDupe { row["simhash"] = quoteJSON(fmt.Sprintf("%016x", simHash(r.Title, body))) }
+        }
+        for _, k := range rawFields { delete(row, k) }
+        b, err := json.Marshal(row)
+        if err != nil { return err }
+        if _, err := w.Write(append(b, '\n')); err != nil { return err }
+    }
+    if err := sc.Err(); err != nil { return err }
+    if err := w.Flush(); err != nil { return err }
+    out.Close()
+    return os.Rename(outPath+".tmp", outPath)
+}
+
+// rawFields are the parts of an -irr row that carry the full response.
+var rawFields = []string{"body", "request", "response"}
+
+// hashBody returns the hex digest of body with algo (xxhash by default).
+func hashBody(algo string, body []byte) string {
+    if algo == "sha1" {
+        sum := sha1.Sum(body)
+        return hex.EncodeToString(sum[:])
+    }
+    return fmt.Sprintf("%016x", xxhash.Sum64(body))
+}
+
+func quoteJSON(s string) json.RawMessage { b, _ := json.Marshal(s); return b }
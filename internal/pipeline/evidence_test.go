@@ -0,0 +1,104 @@
+package pipeline
+
+import (
+    "context"
+    "crypto/sha1"
+    "encoding/hex"
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "hermetica/internal/config"
+    "hermetica/internal/store"
+)
+
+func openTestDB(t *testing.T) *store.DB {
+    t.Helper()
+    db, err := store.Open(filepath.Join(t.TempDir(), "hermetica.sqlite"))
+    if err != nil { t.Fatal(err) }
+    t.Cleanup(func() { db.Close() })
+    return db
+}
+
+// irrRow is an httpx -json -irr row as captured from httpx v1.7, trimmed to
+// the fields that matter here.
+func irrRow(body string) string {
+    row := map[string]any{
+        "timestamp": "2026-01-05T10:07:00Z", "port": "443", "url": "https://192.0.2.1:443", "input": "192.0.2.1:443",
+        "title": "Welcome", "scheme": "https", "webserver": "nginx", "content_type": "text/html", "method": "GET",
+        "host": "192.0.2.1", "path": "/", "status_code": 200, "content_length": len(body), "tech": []string{"Nginx"},
+        "request":  "GET / HTTP/1.1\r\nHost: www.example.com\r\nUser-Agent: httpx\r\n\r\n",
+        "response": "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nServer: nginx\r\n\r\n" + body,
+        "body":     body,
+        "sni_mode": "sni_host", "input_host": "www.example.com",
+    }
+    b, _ := json.Marshal(row)
+    return string(b)
+}
+
+func TestCaptureEvidence(t *testing.T) {
+    body := "<html><title>Welcome</title>" + strings.Repeat("lorem ipsum dolor sit amet ", 100) + "</html>"
+    capped := body[:1024]
+    for _, tc := range []struct {
+        name               string
+        hash, sample, dupe bool
+    }{
+        {"hash and sample", true, true, true},
+        {"hash only", true, false, false},
+        {"near dupe only", false, false, true},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            dir := t.TempDir()
+            raw, out := filepath.Join(dir, "web.jsonl.raw"), filepath.Join(dir, "web.jsonl")
+            if err := os.WriteFile(raw, []byte(irrRow(body)+"\n"), 0o644); err != nil { t.Fatal(err) }
+            cfg := &config.Config{}
+            cfg.Limits.MaxBodyKB = 1
+            cfg.Evidence = config.Evidence{StoreBodyHash: tc.hash, StoreBodySample: tc.sample, NearDupe: tc.dupe, BodyHashAlgo: "sha1"}
+            if err := captureEvidence(context.Background(), cfg, dir, raw, out, openTestDB(t)); err != nil { t.Fatal(err) }
+
+            b, err := os.ReadFile(out)
+            if err != nil { t.Fatal(err) }
+            var row map[string]any
+            if err := json.Unmarshal(b, &row); err != nil { t.Fatal(err) }
+            for _, k := range []string{"body", "request", "response"} {
+                if _, ok := row[k]; ok { t.Errorf("%s kept in web.jsonl", k) }
+            }
+            if strings.Contains(string(b), "lorem") { t.Error("body text leaked into web.jsonl") }
+            if row["title"] != "Welcome" || row["input_host"] != "www.example.com" { t.Errorf("row lost fields: %v", row) }
+            id, _ := row["webtarget_id"].(string)
+            if id == "" { t.Fatal("no webtarget_id") }
+
+            sum := sha1.Sum([]byte(capped))
+            if got, want := row["body_hash"], hex.EncodeToString(sum[:]); tc.hash && got != want { t.Errorf("body_hash = %v, want sha1 of the first 1024 bytes %s", got, want) }
+            if _, ok := row["body_hash"]; !tc.hash && ok { t.Error("body_hash without store_body_hash") }
+            if _, ok := row["simhash"]; ok != tc.dupe { t.Errorf("simhash present = %v, want %v", ok, tc.dupe) }
+
+            p := filepath.Join(dir, "bodies", id+".bin")
+            sample, err := os.ReadFile(p)
+            if !tc.sample {
+                if err == nil || row["body_path"] != nil { t.Error("sample written without store_body_sample") }
+                return
+            }
+            if err != nil { t.Fatal(err) }
+            if string(sample) != capped { t.Errorf("sample has %d bytes, want the first 1024", len(sample)) }
+            if row["body_path"] != p { t.Errorf("body_path = %v, want %s", row["body_path"], p) }
+        })
+    }
+}
+
+func TestCaptureEvidenceWithoutBodies(t *testing.T) {
+    // Bodies off: httpx runs without -irr, rows pass through with an ID.
+    dir := t.TempDir()
+    raw, out := filepath.Join(dir, "web.jsonl.raw"), filepath.Join(dir, "web.jsonl")
+    line := `{"url":"https://192.0.2.1:443","host":"192.0.2.1","port":"443","status_code":200,"sni_mode":"direct_ip","input_host":""}`
+    if err := os.WriteFile(raw, []byte(line+"\n"), 0o644); err != nil { t.Fatal(err) }
+    if err := captureEvidence(context.Background(), &config.Config{}, dir, raw, out, openTestDB(t)); err != nil { t.Fatal(err) }
+    b, err := os.ReadFile(out)
+    if err != nil { t.Fatal(err) }
+    var row map[string]any
+    if err := json.Unmarshal(b, &row); err != nil { t.Fatal(err) }
+    if row["webtarget_id"] == "" || row["body_hash"] != nil || row["body_path"] != nil { t.Errorf("row = %v", row) }
+    if _, err := os.Stat(filepath.Join(dir, "bodies")); err == nil { t.Error("bodies/ created without store_body_sample") }
+}
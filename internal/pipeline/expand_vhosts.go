@@ -0,0 +1,281 @@
+package pipeline
+
+import (
+    "context"
+    "fmt"
+    "math/rand/v2"
+    "net/netip"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/rs/zerolog/log"
+    "hermetica/internal/config"
+    "hermetica/internal/httpprobe"
+    "hermetica/internal/scope"
+    "hermetica/internal/store"
+    dtool "hermetica/internal/tool/dnsx"
+    htool "hermetica/internal/tool/httpx"
+)
+
+// vhostStage brute-forces Host headers against known web services.
+type vhostStage struct{}
+
+func (vhostStage) Name() string { return "vhost_brute" }
+func (vhostStage) Enabled(e *Env) bool { return e.Cfg.Stages.VHostBrute.Enabled }
+func (vhostStage) Inputs(e *Env) []string {
+    in := append(append(e.withSAN("web.jsonl"), e.withSAN("resolved.jsonl")...), e.withSAN("subdomains.jsonl")...)
+    return append(in, e.Cfg.Stages.VHostBrute.HostWordlist)
+}
+func (vhostStage) Outputs(e *Env) []string { return []string{e.Path("vhosts.jsonl")} }
+func (vhostStage) ConfigKey(e *Env) any { return []any{e.Cfg.Stages.VHostBrute, e.Cfg.Scope} }
+
+func (vhostStage) Run(ctx context.Context, e *Env, _ bool) error {
+    log.Info().Str("stage","vhost_brute").Msg("brute forcing virtual hosts")
+    return expandVHosts(ctx, e.Cfg, e.Target, e.withSAN, e.Path("vhosts.jsonl"), e.gate, e.DB)
+}
+
+// vhostHit is one row of vhosts.jsonl.
+type vhostHit struct {
+    IP             string `json:"ip"`
+    Port           int    `json:"port"`
+    Scheme         string `json:"scheme"`
+    URL            string `json:"url"`
+    InputHost      string `json:"input_host"`
+    SNIMode        string `json:"sni_mode"`
+    StatusCode     int    `json:"status_code"`
+    Title          string `json:"title"`
+    Location       string `json:"location,omitempty"`
+    ContentLength  int64  `json:"content_length"`
+    BaselineStatus int    `json:"baseline_status"`
+    BaselineLength int64  `json:"baseline_length"`
+    Reason         string `json:"reason"`
+}
+
+type webService struct {
+    Scheme string
+    IP     string
+    Port   int
+}
+
+// expandVHosts brute-forces Host headers against every web service in
+// web.jsonl. paths maps an artifact name to the files holding its rows.
+// Candidates are known in-scope hostnames that do not already
+// resolve to the service IP, then <word>.<domain> from the host wordlist.
+// max_hosts_per_ip caps the probes per IP, shared by its services (see
+// hostBudgets). A candidate is kept when its response clearly
+// differs from a baseline request with a random Host.
+func expandVHosts(ctx context.Context, cfg *config.Config, t Target, paths func(name string) []string, outPath string, gate *scopeGate, db *store.DB) error {
+    domain := scope.NormalizeHost(t.Domain)
+    results, err := readWebResults(paths("web.jsonl"))
+    if err != nil { return err }
+    services := map[webService]struct{}{}
+    for _, r := range results {
+        ip, err := netip.ParseAddr(r.Host)
+        if err != nil || r.Port == 0 || (r.Scheme != "http" && r.Scheme != "https") { continue }
+        services[webService{Scheme: r.Scheme, IP: ip.String(), Port: r.Port}] = struct{}{}
+    }
+
+    known := map[string]struct{}{}
+    for _, p := range paths("subdomains.jsonl") {
+        hosts, err := readSubdomainHosts(p)
+        if err != nil { return err }
+        for h := range hosts { known[h] = struct{}{} }
+    }
+    var resolved []dtool.Record
+    for _, p := range paths("resolved.jsonl") {
+        _, recs, err := readResolved(p)
+        if err != nil { return err }
+        resolved = append(resolved, recs...)
+    }
+    onIP := map[string]map[string]struct{}{}
+    for _, r := range resolved {
+        for _, ip := range append(append([]string{}, r.A...), r.AAAA...) {
+            if onIP[ip] == nil { onIP[ip] = map[string]struct{}{} }
+            onIP[ip][r.Host] = struct{}{}
+        }
+    }
+    var words []string
+    if cfg.Stages.VHostBrute.HostWordlist != "" {
+        if words, err = readWordlist(cfg.Stages.VHostBrute.HostWordlist); err != nil { return fmt.Errorf("wordlist: %w", err) }
+    }
+    knownSorted := make([]string, 0, len(known))
+    for h := range known { knownSorted = append(knownSorted, h) }
+    sort.Strings(knownSorted)
+
+    candidates := func(ip string, max int) []string {
+        var out []string
+        seen := map[string]struct{}{}
+        add := func(h string) bool {
+            if _, ok := seen[h]; ok { return true }
+            if _, ok := onIP[ip][h]; ok { return true }
+            if ok, _ := gate.eng.CheckHost(h); !ok { return true }
+            seen[h] = struct{}{}
+            out = append(out, h)
+            return max <= 0 || len(out) < max
+        }
+        for _, h := range knownSorted { if !add(h) { return out } }
+        for _, w := range words { if !add(w + "." + domain) { return out } }
+        return out
+    }
+
+    svcList := make([]webService, 0, len(services))
+    for s := range services { svcList = append(svcList, s) }
+    sort.Slice(svcList, func(i, j int) bool {
+        if svcList[i].IP != svcList[j].IP { return svcList[i].IP < svcList[j].IP }
+        return svcList[i].Port < svcList[j].Port
+    })
+
+    var inScope []webService
+    for _, svc := range svcList {
+        if ok, _ := gate.eng.CheckIP(svc.IP); ok { inScope = append(inScope, svc) }
+    }
+    budget := hostBudgets(inScope, cfg.Stages.VHostBrute.MaxHostsPerIP)
+
+    var hits []vhostHit
+    for _, svc := range inScope {
+        n, ok := budget[svc]
+        if ok && n == 0 { continue }
+        h, err := probeVHosts(ctx, cfg, svc, domain, candidates(svc.IP, n))
+        if err != nil {
+            if ctx.Err() != nil { return ctx.Err() }
+            log.Warn().Str("stage", "vhost_brute").Str("ip", svc.IP).Int("port", svc.Port).Err(err).Msg("baseline failed; skipping service")
+            continue
+        }
+        hits = append(hits, h...)
+    }
+
+    var rows []store.WebTarget
+    for _, h := range hits {
+        gate.record("vhost", h.InputHost, true, fmt.Sprintf("vhost on %s:%d (%s)", h.IP, h.Port, h.Reason))
+        sid, err := db.ServiceID(ctx, h.IP, h.Port, "tcp")
+        if err != nil { return err }
+        rows = append(rows, store.WebTarget{ServiceID: sid, InputHost: h.InputHost, SNIMode: h.SNIMode, URL: h.URL, Status: h.StatusCode, Title: h.Title, FinalURL: h.Location})
+    }
+    if err := gate.flush(ctx, "vhost_brute"); err != nil { return err }
+    if err := db.UpsertWebTargets(ctx, rows); err != nil { return err }
+    log.Info().Str("stage", "vhost_brute").Int("services", len(svcList)).Int("vhosts", len(hits)).Msg("vhost brute complete")
+    return writeJSONL(outPath, hits)
+}
+
+// hostBudgets splits max candidate hosts per IP across that IP's services,
+// in the order given, so an IP with several web ports still gets at most
+// max probes. The remainder goes to the first services; a service may get
+// 0 when the IP has more services than max. It returns nil when max is 0
+// (no cap).
+func hostBudgets(svcs []webService, max int) map[webService]int {
+    if max <= 0 { return nil }
+    perIP := map[string]int{}
+    for _, s := range svcs { perIP[s.IP]++ }
+    out := map[webService]int{}
+    seen := map[string]int{}
+    for _, s := range svcs {
+        n := max / perIP[s.IP]
+        if seen[s.IP] < max%perIP[s.IP] { n++ }
+        seen[s.IP]++
+        out[s] = n
+    }
+    return out
+}
+
+// probeVHosts compares each candidate against a random-Host baseline on svc.
+func probeVHosts(ctx context.Context, cfg *config.Config, svc webService, domain string, cands []string) ([]vhostHit, error) {
+    if len(cands) == 0 { return nil, nil }
+    timeout := time.Duration(cfg.Limits.HTTPXTimeoutSec) * time.Second
+    maxBody := int64(cfg.Limits.MaxBodyKB) * 1024
+    if maxBody <= 0 { maxBody = 128 * 1024 }
+    mode := htool.ModeHostOnly
+    if svc.Scheme == "https" { mode = htool.ModeSNIHost }
+    fetch := func(host string) (*httpprobe.Response, error) {
+        req := httpprobe.Request{Scheme: svc.Scheme, IP: svc.IP, Port: svc.Port, Host: host, MaxBody: maxBody, Timeout: timeout}
+        if mode == htool.ModeSNIHost { req.SNI = host }
+        var resp *httpprobe.Response
+        var err error
+        for attempt := 0; attempt <= cfg.Limits.Retries; attempt++ {
+            if resp, err = httpprobe.Do(ctx, req); err == nil { break }
+        }
+        return resp, err
+    }
+    baseHost := randomLabel() + "." + domain
+    base, err := fetch(baseHost)
+    if err != nil { return nil, err }
+    baseSig := signature(base, baseHost)
+
+    workers := cfg.Limits.Concurrency
+    if workers <= 0 || workers > 20 { workers = 20 } // per service; keep load on one IP modest
+    jobs := make(chan string)
+    var mu sync.Mutex
+    var hits []vhostHit
+    var wg sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for h := range jobs {
+                if j := cfg.Limits.RequestJitterMs; j > 0 { time.Sleep(time.Duration(rand.IntN(j)) * time.Millisecond) }
+                resp, err := fetch(h)
+                if err != nil { continue }
+                reason := differs(baseSig, signature(resp, h))
+                if reason == "" { continue }
+                mu.Lock()
+                hits = append(hits, vhostHit{
+                    IP: svc.IP, Port: svc.Port, Scheme: svc.Scheme, URL: httpprobe.Request{Scheme: svc.Scheme, IP: svc.IP, Port: svc.Port}.URL(),
+                    InputHost: h, SNIMode: mode, StatusCode: resp.Status, Title: resp.Title, Location: resp.Location,
+                    ContentLength: resp.ContentLength, BaselineStatus: base.Status, BaselineLength: base.ContentLength, Reason: reason,
+                })
+                mu.Unlock()
+            }
+        }()
+    }
+    feed:
+    for _, h := range cands {
+        select {
+        case jobs <- h:
+        case <-ctx.Done():
+            break feed
+        }
+    }
+    close(jobs)
+    wg.Wait()
+    sort.Slice(hits, func(i, j int) bool { return hits[i].InputHost < hits[j].InputHost })
+    return hits, ctx.Err()
+}
+
+// respSig is the part of a response compared against the baseline, with the
+// requested host replaced so reflected Host headers do not count as a change.
+type respSig struct {
+    status   int
+    title    string
+    location string
+    length   int
+}
+
+func signature(r *httpprobe.Response, host string) respSig {
+    body := strings.ReplaceAll(string(r.Body), host, "{host}")
+    return respSig{
+        status:   r.Status,
+        title:    strings.ReplaceAll(r.Title, host, "{host}"),
+        location: strings.ReplaceAll(r.Location, host, "{host}"),
+        length:   len(body),
+    }
+}
+
+// differs explains why a candidate response differs from the baseline, or
+// returns "" when they are the same app.
+func differs(base, got respSig) string {
+    switch {
+    case base.status != got.status:
+        return fmt.Sprintf("status %d vs %d", got.status, base.status)
+    case base.title != got.title:
+        return "title differs"
+    case base.location != got.location:
+        return "redirect differs"
+    }
+    delta := got.length - base.length
+    if delta < 0 { delta = -delta }
+    tol := base.length / 10
+    if tol < 100 { tol = 100 }
+    if delta > tol { return fmt.Sprintf("length %d vs %d", got.length, base.length) }
+    return ""
+}
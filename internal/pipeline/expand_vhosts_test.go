@@ -0,0 +1,34 @@
+package pipeline
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestHostBudgets(t *testing.T) {
+    a80 := webService{Scheme: "http", IP: "192.0.2.1", Port: 80}
+    a443 := webService{Scheme: "https", IP: "192.0.2.1", Port: 443}
+    a8443 := webService{Scheme: "https", IP: "192.0.2.1", Port: 8443}
+    b443 := webService{Scheme: "https", IP: "192.0.2.2", Port: 443}
+    for _, tc := range []struct {
+        name string
+        svcs []webService
+        max  int
+        want map[webService]int
+    }{
+        {"no cap", []webService{a80, a443}, 0, nil},
+        {"one service per ip", []webService{a443, b443}, 100, map[webService]int{a443: 100, b443: 100}},
+        {"shared by ports", []webService{a80, a443, a8443, b443}, 100, map[webService]int{a80: 34, a443: 33, a8443: 33, b443: 100}},
+        {"more ports than hosts", []webService{a80, a443, a8443}, 2, map[webService]int{a80: 1, a443: 1, a8443: 0}},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            got := hostBudgets(tc.svcs, tc.max)
+            if !reflect.DeepEqual(got, tc.want) { t.Errorf("budgets = %v, want %v", got, tc.want) }
+            perIP := map[string]int{}
+            for s, n := range got { perIP[s.IP] += n }
+            for ip, n := range perIP {
+                if n > tc.max { t.Errorf("%s gets %d probes, max %d", ip, n, tc.max) }
+            }
+        })
+    }
+}
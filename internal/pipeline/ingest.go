@@ -0,0 +1,41 @@
+package pipeline
+
+import (
+    "context"
+    "os"
+    "strings"
+
+    "hermetica/internal/store"
+)
+
+// ingestAssets records every answer in a dnsx-shaped JSONL file as an asset
+// of domain.
+func ingestAssets(ctx context.Context, db *store.DB, domain, resolvedPath string) error {
+    _, recs, err := readResolved(resolvedPath)
+    if err != nil { return err }
+    var rows []store.Asset
+    for _, r := range recs {
+        sub := ""
+        if r.Host != domain { sub = strings.TrimSuffix(r.Host, "."+domain) }
+        add := func(rrtype string, vals []string) {
+            for _, v := range vals { rows = append(rows, store.Asset{Domain: domain, Subdomain: sub, FQDN: r.Host, IP: v, RRType: rrtype}) }
+        }
+        add("A", r.A)
+        add("AAAA", r.AAAA)
+        add("CNAME", r.CNAME)
+    }
+    return db.UpsertAssets(ctx, rows)
+}
+
+// ingestServices records every open port in a naabu JSONL file. A missing
+// file is not an error.
+func ingestServices(ctx context.Context, db *store.DB, portsPath string) error {
+    ports, err := readOpenPorts(portsPath, nil)
+    if os.IsNotExist(err) { return nil }
+    if err != nil { return err }
+    var rows []store.Service
+    for ip, ps := range ports {
+        for _, p := range ps { rows = append(rows, store.Service{IP: ip, Port: p, Proto: "tcp"}) }
+    }
+    return db.UpsertServices(ctx, rows)
+}
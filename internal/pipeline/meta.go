@@ -1,25 +1,167 @@
 package pipeline
 
 import (
+    "bufio"
+    "context"
     "encoding/json"
+    "errors"
     "os"
+    "sync"
     "time"
+
     "hermetica/internal/config"
+    "hermetica/internal/executil"
 )
 
+// runMeta is written to work/<domain>/run.meta.json after every run,
+// including failed and interrupted ones, as a record of exactly what ran.
 type runMeta struct {
-    GeneratedAt time.Time        `json:"generated_at"`
-    ConfigWorkdir string         `json:"workdir"`
-    ToolVersions map[string]string `json:"tool_versions"`
+    RunID       string              `json:"run_id"`
+    Snapshot    string              `json:"snapshot"` // copy of the store taken when the run ended
+    Domain      string              `json:"domain"`
+    Mode        string              `json:"mode"` // run | resume | watch
+    Force       bool                `json:"force"`
+    Due         []string            `json:"due,omitempty"` // watch: stages whose cadence came up
+    StartedAt   time.Time           `json:"started_at"`
+    EndedAt     time.Time           `json:"ended_at"`
+    DurationSec float64             `json:"duration_seconds"`
+    Status      string              `json:"status"` // done | failed | interrupted
+    Error       string              `json:"error,omitempty"`
+    Workdir     string              `json:"workdir"`
+    ConfigHash  string              `json:"config_hash"`
+    Tools       map[string]toolMeta `json:"tools"`
+    Stages      []*stageMeta        `json:"stages"`
+
+    mu    sync.Mutex
+    execs map[string][]executil.Exec
+}
+
+// toolMeta is the version a tool reported when the run started, next to
+// the constraint the config asks for.
+type toolMeta struct {
+    Path       string `json:"path"`
+    Version    string `json:"version,omitempty"`
+    Raw        string `json:"raw,omitempty"` // version output when it did not parse
+    Constraint string `json:"constraint,omitempty"`
+    Error      string `json:"error,omitempty"`
+}
+
+// stageMeta summarises one planned stage. Skipped stages keep the times of
+// the run that produced their artifact.
+type stageMeta struct {
+    Name        string          `json:"name"`
+    Status      string          `json:"status"` // done | failed | interrupted | skipped | not run
+    Ran         bool            `json:"ran"`
+    StartedAt   *time.Time      `json:"started_at,omitempty"`
+    EndedAt     *time.Time      `json:"ended_at,omitempty"`
+    DurationSec float64         `json:"duration_seconds"`
+    Inputs      []fileMeta      `json:"inputs"`
+    Outputs     []fileMeta      `json:"outputs"`
+    Commands    []executil.Exec `json:"commands"`
+    Error       string          `json:"error,omitempty"`
+}
+
+// fileMeta counts the non-empty lines (records) of a stage file. SHA256 is
+// only set for outputs.
+type fileMeta struct {
+    Path    string `json:"path"`
+    Exists  bool   `json:"exists"`
+    Records int    `json:"records"`
+    SHA256  string `json:"sha256,omitempty"`
+}
+
+// newRunMeta starts the metadata for a run and detects the version of
+// every configured tool.
+func newRunMeta(ctx context.Context, cfg *config.Config, t Target, force, resume bool) *runMeta {
+    m := &runMeta{Domain: t.Domain, Mode: "run", Force: force, StartedAt: time.Now().UTC(), Workdir: cfg.Workdir,
+        ConfigHash: hashJSON(cfg), Tools: map[string]toolMeta{}, execs: map[string][]executil.Exec{}}
+    if resume { m.Mode = "resume" }
+    for name, path := range cfg.Tools.Paths {
+        tm := toolMeta{Path: path, Constraint: cfg.Tools.Versions[name]}
+        if path == "" { continue }
+        raw, v, err := executil.Version(ctx, path)
+        switch {
+        case err != nil:
+            tm.Error = err.Error()
+        case v != nil:
+            tm.Version = v.String()
+        default:
+            tm.Raw = raw
+        }
+        m.Tools[name] = tm
+    }
+    return m
 }
 
-func writeRunMeta(path string, cfg *config.Config) error {
-    m := runMeta{
-        GeneratedAt: time.Now(),
-        ConfigWorkdir: cfg.Workdir,
-        ToolVersions: cfg.Tools.Versions,
+// observe returns a context that records the tool invocations of stage.
+func (m *runMeta) observe(ctx context.Context, stage string) context.Context {
+    return executil.WithObserver(ctx, func(ex executil.Exec) {
+        m.mu.Lock()
+        defer m.mu.Unlock()
+        m.execs[stage] = append(m.execs[stage], ex)
+    })
+}
+
+// finish fills in the stage summaries from the checkpoint and the files on
+// disk and writes the metadata to path.
+func (m *runMeta) finish(path string, e *Env, ck *checkpoint, ran map[string]bool, runErr error, interrupted bool) error {
+    m.EndedAt = time.Now().UTC()
+    m.DurationSec = m.EndedAt.Sub(m.StartedAt).Seconds()
+    m.Status = stageDone
+    if runErr != nil {
+        m.Status, m.Error = stageFailed, runErr.Error()
+        if interrupted { m.Status = stageInterrupted }
+    }
+    stages, _ := plan(e)
+    ck.mu.Lock()
+    defer ck.mu.Unlock()
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for _, s := range stages {
+        name := s.Name()
+        sm := &stageMeta{Name: name, Status: "not run", Ran: ran[name], Inputs: []fileMeta{}, Outputs: []fileMeta{}, Commands: m.execs[name]}
+        if sm.Commands == nil { sm.Commands = []executil.Exec{} }
+        outs := s.Outputs(e)
+        if !sm.Ran && exists(outs[0]) { sm.Status = "skipped" }
+        if st := ck.Stages[name]; st != nil && (sm.Ran || st.Status == stageDone) {
+            if sm.Ran { sm.Status, sm.Error = st.Status, st.Error }
+            if !st.StartedAt.IsZero() { at := st.StartedAt; sm.StartedAt = &at }
+            if !st.EndedAt.IsZero() && !st.EndedAt.Before(st.StartedAt) {
+                at := st.EndedAt
+                sm.EndedAt = &at
+                sm.DurationSec = at.Sub(st.StartedAt).Seconds()
+            }
+        }
+        for _, in := range s.Inputs(e) {
+            if in != "" { sm.Inputs = append(sm.Inputs, describeFile(ck, in, false)) }
+        }
+        for _, out := range outs { sm.Outputs = append(sm.Outputs, describeFile(ck, out, true)) }
+        m.Stages = append(m.Stages, sm)
     }
-    b, _ := json.MarshalIndent(m, "", "  ")
+    b, err := json.MarshalIndent(m, "", "  ")
+    if err != nil { return err }
     return os.WriteFile(path, b, 0o644)
 }
 
+func describeFile(ck *checkpoint, path string, sum bool) fileMeta {
+    fm := fileMeta{Path: ck.rel(path)}
+    n, err := countRecords(path)
+    if errors.Is(err, os.ErrNotExist) { return fm }
+    fm.Exists, fm.Records = true, n
+    if sum { fm.SHA256 = fileSum(path) }
+    return fm
+}
+
+// countRecords counts the non-empty lines of a JSONL or text file.
+func countRecords(path string) (int, error) {
+    f, err := os.Open(path)
+    if err != nil { return 0, err }
+    defer f.Close()
+    n := 0
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+    for sc.Scan() {
+        if len(sc.Bytes()) > 0 { n++ }
+    }
+    return n, sc.Err()
+}
@@ -0,0 +1,153 @@
+package pipeline
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "math/bits"
+    "os"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/cespare/xxhash/v2"
+    "github.com/rs/zerolog/log"
+    "hermetica/internal/config"
+    "hermetica/internal/store"
+)
+
+const shingleWords = 3
+
+var (
+    reScript = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+    reTag    = regexp.MustCompile(`(?s)<[^>]*>`)
+    reDigits = regexp.MustCompile(`[0-9]+`)
+)
+
+// normalizeBody reduces an HTML body to lower-case words, dropping markup,
+// scripts and digit runs so that per-request tokens, timestamps and counters
+// do not split otherwise identical pages.
+func normalizeBody(body []byte) []string {
+    s := reScript.ReplaceAllString(string(body), " ")
+    s = reTag.ReplaceAllString(s, " ")
+    s = reDigits.ReplaceAllString(s, "0")
+    return strings.Fields(strings.ToLower(s))
+}
+
+// simHash returns the 64-bit SimHash of the title and body, built from
+// shingles of shingleWords consecutive words.
+func simHash(title string, body []byte) uint64 {
+    words := append(normalizeBody([]byte(title)), normalizeBody(body)...)
+    if len(words) == 0 { return 0 }
+    var v [64]int
+    add := func(s string) {
+        h := xxhash.Sum64String(s)
+        for i := 0; i < 64; i++ {
+            if h&(1<<uint(i)) != 0 { v[i]++ } else { v[i]-- }
+        }
+    }
+    if len(words) < shingleWords { add(strings.Join(words, " ")) }
+    for i := 0; i+shingleWords <= len(words); i++ { add(strings.Join(words[i:i+shingleWords], " ")) }
+    var out uint64
+    for i := 0; i < 64; i++ {
+        if v[i] > 0 { out |= 1 << uint(i) }
+    }
+    return out
+}
+
+// assignPageGroups clusters the rows of webPath whose SimHashes are within
+// evidence.near_dupe_distance bits of each other and tags every row with its
+// page_group, both in the file and in the store. Rows of the also files are
+// clustered with them and grouped in the store but their files are left
+// alone. A group is named after the SimHash of its oldest member (lowest web
+// target ULID), so the ID survives re-runs as long as that page is still
+// served.
+func assignPageGroups(ctx context.Context, cfg *config.Config, stage, webPath string, db *store.DB, also ...string) error {
+    if !cfg.Evidence.NearDupe { return nil }
+    maxDist := cfg.Evidence.NearDupeMaxDistance()
+    var rows []map[string]json.RawMessage
+    type member struct { row int; id string; hash uint64 } // row is -1 outside webPath
+    var members []member
+    for i, path := range append([]string{webPath}, also...) {
+        b, err := os.ReadFile(path)
+        if err != nil { return err }
+        for _, l := range strings.Split(string(b), "\n") {
+            var row map[string]json.RawMessage
+            if json.Unmarshal([]byte(l), &row) != nil { continue }
+            idx := -1
+            if i == 0 { rows = append(rows, row); idx = len(rows) - 1 }
+            var id, sh string
+            _ = json.Unmarshal(row["webtarget_id"], &id)
+            _ = json.Unmarshal(row["simhash"], &sh)
+            h, err := strconv.ParseUint(sh, 16, 64)
+            if err != nil || id == "" || h == 0 { continue }
+            members = append(members, member{row: idx, id: id, hash: h})
+        }
+    }
+    sort.Slice(members, func(i, j int) bool { return members[i].id < members[j].id })
+
+    // Union-find over candidate pairs within range; the root is always the
+    // oldest member since unions keep the lower index.
+    parent := make([]int, len(members))
+    for i := range parent { parent[i] = i }
+    var find func(int) int
+    find = func(i int) int {
+        if parent[i] != i { parent[i] = find(parent[i]) }
+        return parent[i]
+    }
+    hashes := make([]uint64, len(members))
+    for i, m := range members { hashes[i] = m.hash }
+    for _, p := range nearPairs(hashes, maxDist) {
+        a, b := find(p[0]), find(p[1])
+        if a == b { continue }
+        if a < b { parent[b] = a } else { parent[a] = b }
+    }
+    groups := map[string]string{} // webtarget id -> page group
+    named := map[int]struct{}{}
+    for i, m := range members {
+        root := find(i)
+        named[root] = struct{}{}
+        g := fmt.Sprintf("pg_%016x", members[root].hash)
+        groups[m.id] = g
+        if m.row >= 0 { rows[m.row]["page_group"] = quoteJSON(g) }
+    }
+    log.Info().Str("stage", stage).Int("pages", len(members)).Int("groups", len(named)).Msg("grouped near-duplicate pages")
+    if err := writeJSONL(webPath, rows); err != nil { return err }
+    return db.SetPageGroups(ctx, groups)
+}
+
+// nearPairs returns the index pairs (i < j) of hashes within maxDist bits of
+// each other. The 64 bits are cut into maxDist+1 bands (4 bands of 16 bits
+// at the default distance of 3); two hashes that differ in at most maxDist
+// bits agree on at least one whole band, so only hashes sharing a band
+// value are compared.
+func nearPairs(hashes []uint64, maxDist int) [][2]int {
+    if maxDist < 0 { return nil }
+    nb := maxDist + 1
+    if nb < 4 { nb = 4 }
+    if nb > 64 { nb = 64 }
+    seen := map[[2]int]struct{}{}
+    var out [][2]int
+    for b := 0; b < nb; b++ {
+        lo, hi := b*64/nb, (b+1)*64/nb
+        mask := (uint64(1)<<uint(hi-lo) - 1) << uint(lo)
+        buckets := map[uint64][]int{}
+        for i, h := range hashes { buckets[h&mask] = append(buckets[h&mask], i) }
+        for _, idx := range buckets {
+            for x := 0; x < len(idx); x++ {
+                for y := x + 1; y < len(idx); y++ {
+                    p := [2]int{idx[x], idx[y]}
+                    if _, ok := seen[p]; ok { continue }
+                    seen[p] = struct{}{}
+                    if bits.OnesCount64(hashes[p[0]]^hashes[p[1]]) <= maxDist { out = append(out, p) }
+                }
+            }
+        }
+    }
+    sort.Slice(out, func(i, j int) bool {
+        if out[i][0] != out[j][0] { return out[i][0] < out[j][0] }
+        return out[i][1] < out[j][1]
+    })
+    return out
+}
@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "math/bits"
+    "math/rand/v2"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+
+    "hermetica/internal/config"
+)
+
+func TestNearPairs(t *testing.T) {
+    const h0 = uint64(0xa5a5_5a5a_f0f0_0f0f)
+    hashes := []uint64{
+        h0,
+        h0 ^ 0b111,                      // 3 bits, all in band 0
+        h0 ^ (1 | 1<<16 | 1<<32 | 1<<48), // 4 bits, one in every band
+        h0 ^ (1<<16 | 1<<32 | 1<<48),     // 3 bits over three bands
+    }
+    for _, tc := range []struct {
+        dist int
+        want [][2]int
+    }{
+        {0, nil},
+        {1, [][2]int{{2, 3}}},
+        {3, [][2]int{{0, 1}, {0, 3}, {2, 3}}},
+        {5, [][2]int{{0, 1}, {0, 2}, {0, 3}, {1, 2}, {2, 3}}},
+    } {
+        t.Run(fmt.Sprint("distance ", tc.dist), func(t *testing.T) {
+            if got := nearPairs(hashes, tc.dist); !reflect.DeepEqual(got, tc.want) { t.Errorf("pairs = %v, want %v", got, tc.want) }
+        })
+    }
+}
+
+func TestNearPairsMatchesPairwise(t *testing.T) {
+    // Banding must find exactly the pairs a full pairwise scan finds.
+    r := rand.New(rand.NewPCG(1, 2))
+    var hashes []uint64
+    for i := 0; i < 40; i++ {
+        h := r.Uint64()
+        hashes = append(hashes, h)
+        for j := 0; j < 5; j++ { // near copies, 1-6 bits off
+            v := h
+            for k := 0; k <= j; k++ { v ^= 1 << uint(r.IntN(64)) }
+            hashes = append(hashes, v)
+        }
+    }
+    for _, dist := range []int{0, 2, 3, 6, 10} {
+        var want [][2]int
+        for i := range hashes {
+            for j := i + 1; j < len(hashes); j++ {
+                if bits.OnesCount64(hashes[i]^hashes[j]) <= dist { want = append(want, [2]int{i, j}) }
+            }
+        }
+        if got := nearPairs(hashes, dist); !reflect.DeepEqual(got, want) { t.Errorf("distance %d: %d pairs, want %d", dist, len(got), len(want)) }
+    }
+}
+
+func TestAssignPageGroups(t *testing.T) {
+    dir := t.TempDir()
+    web, other := filepath.Join(dir, "web.jsonl"), filepath.Join(dir, "san", "web.jsonl")
+    const base = uint64(0x1234_5678_9abc_def0)
+    row := func(id string, h uint64) string { return fmt.Sprintf(`{"webtarget_id":%q,"simhash":"%016x"}`, id, h) }
+    rows := []string{
+        row("01C", base^0b11),       // joins 01A's group
+        row("01A", base),
+        row("01D", base^0xf0),       // 4 bits off: its own group
+        `{"webtarget_id":"01E"}`,    // no simhash: untouched
+    }
+    if err := os.WriteFile(web, []byte(strings.Join(rows, "\n")+"\n"), 0o644); err != nil { t.Fatal(err) }
+    if err := os.MkdirAll(filepath.Dir(other), 0o755); err != nil { t.Fatal(err) }
+    if err := os.WriteFile(other, []byte(row("01B", base^1<<40)+"\n"), 0o644); err != nil { t.Fatal(err) }
+    cfg := &config.Config{}
+    cfg.Evidence.NearDupe = true
+    if err := assignPageGroups(context.Background(), cfg, "test", web, openTestDB(t), other); err != nil { t.Fatal(err) }
+
+    b, err := os.ReadFile(web)
+    if err != nil { t.Fatal(err) }
+    got := map[string]string{}
+    for _, l := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+        var r struct { ID string `json:"webtarget_id"`; PageGroup string `json:"page_group"` }
+        if err := json.Unmarshal([]byte(l), &r); err != nil { t.Fatal(err) }
+        got[r.ID] = r.PageGroup
+    }
+    g := fmt.Sprintf("pg_%016x", base)
+    want := map[string]string{"01A": g, "01C": g, "01D": fmt.Sprintf("pg_%016x", base^0xf0), "01E": ""}
+    if !reflect.DeepEqual(got, want) { t.Errorf("groups = %v, want %v", got, want) }
+    if b, _ := os.ReadFile(other); strings.Contains(string(b), "page_group") { t.Error("also file rewritten") }
+}
@@ -0,0 +1,161 @@
+package pipeline
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net"
+    "net/netip"
+    "os"
+    "slices"
+    "sort"
+    "strconv"
+
+    "github.com/rs/zerolog/log"
+    "hermetica/internal/config"
+    "hermetica/internal/store"
+    htool "hermetica/internal/tool/httpx"
+)
+
+// probeStage probes every open service with the configured SNI/Host matrix
+// for each hostname resolving to it, plus bare ip:port when enabled.
+type probeStage struct{}
+
+func (probeStage) Name() string { return "probe_http" }
+func (probeStage) Enabled(*Env) bool { return true }
+func (probeStage) Inputs(e *Env) []string { return []string{e.Path("resolved.jsonl"), e.Path("ports.jsonl")} }
+func (probeStage) Outputs(e *Env) []string { return []string{e.Path("web.jsonl")} }
+
+func (probeStage) ConfigKey(e *Env) any {
+    ev := e.Cfg.Evidence
+    return []any{e.Cfg.Probe, ev.StoreBodyHash, ev.StoreBodySample, ev.BodyHashAlgo, ev.NearDupe, ev.NearDupeMaxDistance(), e.Cfg.Limits.MaxBodyKB, e.Cfg.Scope}
+}
+
+func (probeStage) Run(ctx context.Context, e *Env, fresh bool) error {
+    portsPath, webPath := e.Path("ports.jsonl"), e.Path("web.jsonl")
+    // ports.jsonl has no hostnames; rejections are recorded under the IP.
+    ports, err := readOpenPorts(portsPath, func(ip string) bool { return e.gate.ip("naabu", ip, ip) })
+    if err != nil { return err }
+    if err := e.gate.flush(ctx, "probe_http"); err != nil { return err }
+    groups, err := buildProbeGroups(e.Cfg, e.Path("resolved.jsonl"), ports, nil)
+    if err != nil { return err }
+    log.Info().Str("stage","probe_http").Int("groups", len(groups)).Msg("running httpx matrix")
+    partsDir := e.Path("probe")
+    if fresh { _ = os.RemoveAll(partsDir) }
+    if err := probeWeb(ctx, e.Cfg, groups, partsDir, e.Dir, webPath, e.DB); err != nil { return err }
+    if err := ingestWeb(ctx, e.DB, webPath); err != nil { return fmt.Errorf("ingest web: %w", err) }
+    if err := assignPageGroups(ctx, e.Cfg, "probe_http", webPath, e.DB); err != nil { return fmt.Errorf("page groups: %w", err) }
+    return nil
+}
+
+// buildProbeGroups expands open services into one group per configured
+// SNI/Host combination and hostname, holding the ip:port of every service
+// that hostname resolves to. Bare ip:port probes are added for every
+// service when include_direct_ip is set, and for services with hostnames
+// when the matrix contains {sni: "", host: ""}. direct, when non-nil,
+// limits which IPs get bare probes.
+func buildProbeGroups(cfg *config.Config, resolvedPath string, ports map[string][]int, direct func(ip string) bool) ([]htool.ProbeGroup, error) {
+    _, recs, err := readResolved(resolvedPath)
+    if err != nil { return nil, err }
+    var modes []string
+    bare := false
+    for _, c := range cfg.Probe.SNIHostCombinations {
+        m := htool.ModeDirectIP
+        switch {
+        case c.SNI != "" && c.Host != "":
+            m = htool.ModeSNIHost
+        case c.SNI != "":
+            m = htool.ModeSNIOnly
+        case c.Host != "":
+            m = htool.ModeHostOnly
+        default:
+            bare = true
+        }
+        if m != htool.ModeDirectIP && !slices.Contains(modes, m) { modes = append(modes, m) }
+    }
+
+    byHost := map[string][]string{}
+    named := map[string]bool{}
+    for _, r := range recs {
+        ips := append([]string{}, r.A...)
+        if cfg.DNS.IPv6Enabled { ips = append(ips, r.AAAA...) }
+        for _, ip := range ips {
+            for _, p := range ports[ip] {
+                byHost[r.Host] = append(byHost[r.Host], net.JoinHostPort(ip, strconv.Itoa(p)))
+                named[ip] = true
+            }
+        }
+    }
+    hosts := make([]string, 0, len(byHost))
+    for h, in := range byHost {
+        sort.Strings(in)
+        byHost[h] = slices.Compact(in)
+        hosts = append(hosts, h)
+    }
+    sort.Strings(hosts)
+    var groups []htool.ProbeGroup
+    for _, m := range modes {
+        for _, h := range hosts {
+            groups = append(groups, htool.ProbeGroup{Mode: m, Host: h, Inputs: byHost[h]})
+        }
+    }
+
+    var bareInputs []string
+    for ip, ps := range ports {
+        if !(cfg.Probe.IncludeDirectIP || bare && named[ip]) { continue }
+        if direct != nil && !direct(ip) { continue }
+        for _, p := range ps { bareInputs = append(bareInputs, net.JoinHostPort(ip, strconv.Itoa(p))) }
+    }
+    if len(bareInputs) > 0 {
+        sort.Strings(bareInputs)
+        groups = append(groups, htool.ProbeGroup{Mode: htool.ModeDirectIP, Inputs: bareInputs})
+    }
+    return groups, nil
+}
+
+// readOpenPorts maps each IP in a naabu JSONL file to its open ports,
+// dropping IPs rejected by keep.
+func readOpenPorts(path string, keep func(ip string) bool) (map[string][]int, error) {
+    f, err := os.Open(path)
+    if err != nil { return nil, err }
+    defer f.Close()
+    out := map[string][]int{}
+    seen := map[string]bool{}
+    decided := map[string]bool{}
+    sc := bufio.NewScanner(f)
+    for sc.Scan() {
+        var r struct { IP string `json:"ip"`; Port int `json:"port"` }
+        if err := json.Unmarshal(sc.Bytes(), &r); err != nil || r.IP == "" || r.Port == 0 { continue }
+        if keep != nil {
+            ok, done := decided[r.IP]
+            if !done { ok = keep(r.IP); decided[r.IP] = ok }
+            if !ok { continue }
+        }
+        k := r.IP + ":" + strconv.Itoa(r.Port)
+        if seen[k] { continue }
+        seen[k] = true
+        out[r.IP] = append(out[r.IP], r.Port)
+    }
+    for ip := range out { sort.Ints(out[ip]) }
+    return out, sc.Err()
+}
+
+// ingestWeb records every row of an httpx JSONL file in webtargets, tagged
+// with the probe mode and input host it was produced by.
+func ingestWeb(ctx context.Context, db *store.DB, webPath string) error {
+    results, err := htool.ReadResults(webPath)
+    if err != nil { return err }
+    var rows []store.WebTarget
+    for _, r := range results {
+        ip, err := netip.ParseAddr(r.Host)
+        if err != nil || r.Port == 0 { continue }
+        sid, err := db.ServiceID(ctx, ip.String(), r.Port, "tcp")
+        if err != nil { return fmt.Errorf("service %s:%d: %w", ip, r.Port, err) }
+        w := store.WebTarget{ID: r.WebTargetID, BodyHash: r.BodyHash, BodyPath: r.BodyPath, PageGroup: r.PageGroup, ServiceID: sid, InputHost: r.InputHost, SNIMode: r.SNIMode, URL: r.URL, Status: r.StatusCode, Title: r.Title, FinalURL: r.FinalURL, CDNHint: r.CDNName, Tech: r.Tech}
+        if w.SNIMode == "" { w.SNIMode = htool.ModeDirectIP }
+        if r.TLS != nil { w.TLSIssuer = r.TLS.IssuerCN }
+        rows = append(rows, w)
+    }
+    return db.UpsertWebTargets(ctx, rows)
+}
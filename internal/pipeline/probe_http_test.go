@@ -0,0 +1,66 @@
+package pipeline
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+
+    "gopkg.in/yaml.v3"
+    "hermetica/internal/config"
+    htool "hermetica/internal/tool/httpx"
+)
+
+func TestBuildProbeGroups(t *testing.T) {
+    resolved := filepath.Join(t.TempDir(), "resolved.jsonl")
+    rows := `{"host":"www.example.com","a":["192.0.2.1"]}
+{"host":"API.example.com.","a":["192.0.2.1","192.0.2.2"],"aaaa":["2001:db8::1"]}
+{"host":"idle.example.com","a":["192.0.2.50"]}
+`
+    if err := os.WriteFile(resolved, []byte(rows), 0o644); err != nil { t.Fatal(err) }
+    ports := map[string][]int{"192.0.2.1": {80, 443}, "192.0.2.2": {8443}, "2001:db8::1": {443}, "198.51.100.9": {80}}
+    api := []string{"192.0.2.1:443", "192.0.2.1:80", "192.0.2.2:8443"}
+    www := []string{"192.0.2.1:443", "192.0.2.1:80"}
+    named := []string{"192.0.2.1:443", "192.0.2.1:80", "192.0.2.2:8443"}
+    all := append(append([]string{}, named...), "198.51.100.9:80", "[2001:db8::1]:443") // every scanned service
+    for _, tc := range []struct {
+        name   string
+        matrix string
+        ipv6   bool
+        direct func(ip string) bool
+        want   []htool.ProbeGroup
+    }{
+        {"full matrix", `sni_host_combinations: [{sni: s, host: h}, {sni: s, host: ""}, {sni: "", host: h}, {sni: "", host: ""}]`, false, nil, []htool.ProbeGroup{
+            {Mode: htool.ModeSNIHost, Host: "api.example.com", Inputs: api},
+            {Mode: htool.ModeSNIHost, Host: "www.example.com", Inputs: www},
+            {Mode: htool.ModeSNIOnly, Host: "api.example.com", Inputs: api},
+            {Mode: htool.ModeSNIOnly, Host: "www.example.com", Inputs: www},
+            {Mode: htool.ModeHostOnly, Host: "api.example.com", Inputs: api},
+            {Mode: htool.ModeHostOnly, Host: "www.example.com", Inputs: www},
+            {Mode: htool.ModeDirectIP, Inputs: named},
+        }},
+        {"host only", `sni_host_combinations: [{sni: "", host: h}]`, false, nil, []htool.ProbeGroup{
+            {Mode: htool.ModeHostOnly, Host: "api.example.com", Inputs: api},
+            {Mode: htool.ModeHostOnly, Host: "www.example.com", Inputs: www},
+        }},
+        {"ipv6 services", `sni_host_combinations: [{sni: s, host: h}]`, true, nil, []htool.ProbeGroup{
+            {Mode: htool.ModeSNIHost, Host: "api.example.com", Inputs: append(append([]string{}, api...), "[2001:db8::1]:443")},
+            {Mode: htool.ModeSNIHost, Host: "www.example.com", Inputs: www},
+        }},
+        {"include_direct_ip only", `include_direct_ip: true`, false, nil, []htool.ProbeGroup{
+            {Mode: htool.ModeDirectIP, Inputs: all},
+        }},
+        {"direct filter", `include_direct_ip: true`, false, func(ip string) bool { return ip == "198.51.100.9" }, []htool.ProbeGroup{
+            {Mode: htool.ModeDirectIP, Inputs: []string{"198.51.100.9:80"}},
+        }},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            cfg := &config.Config{}
+            if err := yaml.Unmarshal([]byte(tc.matrix), &cfg.Probe); err != nil { t.Fatal(err) }
+            cfg.DNS.IPv6Enabled = tc.ipv6
+            got, err := buildProbeGroups(cfg, resolved, ports, tc.direct)
+            if err != nil { t.Fatal(err) }
+            if !reflect.DeepEqual(got, tc.want) { t.Errorf("groups =\n%+v\nwant\n%+v", got, tc.want) }
+        })
+    }
+}
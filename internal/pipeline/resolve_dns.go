@@ -0,0 +1,41 @@
+package pipeline
+
+import (
+    "context"
+    "fmt"
+
+    "github.com/rs/zerolog/log"
+    dtool "hermetica/internal/tool/dnsx"
+)
+
+// resolveStage resolves the in-scope subdomains and drops wildcard answers.
+type resolveStage struct{}
+
+func (resolveStage) Name() string { return "resolve_dns" }
+func (resolveStage) Enabled(*Env) bool { return true }
+func (resolveStage) Inputs(e *Env) []string { return []string{e.Path("subdomains.jsonl"), e.Cfg.Tools.ResolversFile} }
+
+func (resolveStage) Outputs(e *Env) []string {
+    return []string{e.Path("resolved.jsonl"), e.Path("subdomains.txt"), e.Path("wildcards.jsonl")}
+}
+
+func (resolveStage) ConfigKey(e *Env) any {
+    return []any{e.Cfg.DNS.Backend, e.Cfg.DNS.WildcardFilter, e.Cfg.DNS.VerifyCount, e.Cfg.Scope}
+}
+
+func (resolveStage) Run(ctx context.Context, e *Env, _ bool) error {
+    listPath, resolvedPath := e.Path("subdomains.txt"), e.Path("resolved.jsonl")
+    keep := func(h, src string) bool { return e.gate.host(orDefault(src, "subfinder"), h) }
+    if err := dtool.BuildInputFromSubfinder(e.Path("subdomains.jsonl"), listPath, keep); err != nil { return err }
+    if err := e.gate.flush(ctx, "resolve_dns"); err != nil { return err }
+    name, resolve := resolverFor(e.Cfg)
+    log.Info().Str("stage","resolve_dns").Str("backend", name).Msg("resolving hosts")
+    rawPath := resolvedPath
+    if e.Cfg.DNS.WildcardFilter { rawPath = e.Path("resolved.raw.jsonl") }
+    if err := resolve(ctx, e.Cfg, listPath, rawPath); err != nil { return fmt.Errorf("%s: %w", name, err) }
+    if e.Cfg.DNS.WildcardFilter {
+        if err := filterWildcards(ctx, e.Cfg, resolve, e.Target.Domain, e.Dir, rawPath, resolvedPath, e.Path("wildcards.jsonl"), e.DB); err != nil { return fmt.Errorf("wildcard filter: %w", err) }
+    }
+    if err := ingestAssets(ctx, e.DB, e.Target.Domain, resolvedPath); err != nil { return fmt.Errorf("ingest assets: %w", err) }
+    return nil
+}
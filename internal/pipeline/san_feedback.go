@@ -0,0 +1,314 @@
+package pipeline
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/netip"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    "github.com/rs/zerolog/log"
+    "hermetica/internal/config"
+    "hermetica/internal/scope"
+    "hermetica/internal/store"
+    htool "hermetica/internal/tool/httpx"
+    ntool "hermetica/internal/tool/naabu"
+)
+
+// sanStage feeds certificate names back into resolution, scanning and
+// probing. What it finds goes to san/ next to, never into, the upstream
+// artifacts; later stages read both (see Env.withSAN).
+type sanStage struct{}
+
+func (sanStage) Name() string { return "tls_san_feedback" }
+func (sanStage) Enabled(e *Env) bool { return e.Cfg.Stages.TLSSANFeedback.Enabled }
+
+func (sanStage) Inputs(e *Env) []string {
+    return []string{e.Path("web.jsonl"), e.Path("subdomains.jsonl"), e.Path("resolved.jsonl"), e.Path("ips.txt"), e.Path("ports.jsonl")}
+}
+
+func (sanStage) Outputs(e *Env) []string {
+    out := []string{e.Path("san.jsonl")}
+    for _, name := range sanArtifacts { out = append(out, e.Path(filepath.Join("san", name))) }
+    return out
+}
+
+func (sanStage) ConfigKey(e *Env) any {
+    return []any{e.Cfg.Stages.TLSSANFeedback, e.Cfg.Scope, e.Cfg.DNS.WildcardFilter, e.Cfg.DNS.VerifyCount}
+}
+
+func (sanStage) Run(ctx context.Context, e *Env, fresh bool) error {
+    log.Info().Str("stage","tls_san_feedback").Msg("harvesting certificate names")
+    if fresh { _ = os.RemoveAll(e.Path("san")) }
+    if err := sanFeedback(ctx, e.Cfg, e.Target, e.Dir, e.Path("san.jsonl"), e.gate, e.DB); err != nil { return err }
+    if err := assignPageGroups(ctx, e.Cfg, "tls_san_feedback", e.Path("san/web.jsonl"), e.DB, e.Path("web.jsonl")); err != nil { return fmt.Errorf("page groups: %w", err) }
+    return nil
+}
+
+// sanArtifacts are the files tls_san_feedback writes under san/, each
+// holding the rows it adds to the top-level artifact of the same name.
+var sanArtifacts = []string{"subdomains.jsonl", "resolved.jsonl", "ips.txt", "ports.jsonl", "web.jsonl"}
+
+// withSAN returns the top-level artifact name followed, when
+// tls_san_feedback is enabled, by its san/ counterpart. Stages after
+// tls_san_feedback read and declare both.
+func (e *Env) withSAN(name string) []string {
+    paths := []string{e.Path(name)}
+    if e.Cfg.Stages.TLSSANFeedback.Enabled { paths = append(paths, e.Path(filepath.Join("san", name))) }
+    return paths
+}
+
+// readWebResults reads the httpx rows of every path in order.
+func readWebResults(paths []string) ([]htool.Result, error) {
+    var out []htool.Result
+    for _, p := range paths {
+        rs, err := htool.ReadResults(p)
+        if err != nil { return nil, err }
+        out = append(out, rs...)
+    }
+    return out, nil
+}
+
+// sanName is one row of san.jsonl.
+type sanName struct {
+    Host   string `json:"host"`
+    Source string `json:"source"`
+    Round  int    `json:"round"`
+    From   string `json:"from"`
+}
+
+// sanFeedback harvests certificate names from web.jsonl and feeds the new,
+// in-scope ones back through resolution, port scanning and probing, for at
+// most max_rounds rounds. Each round works in san/round-N/, so an
+// interrupted stage resumes at the first unfinished round; the rounds are
+// then combined into san/<artifact>.
+func sanFeedback(ctx context.Context, cfg *config.Config, t Target, wdir, sanPath string, gate *scopeGate, db *store.DB) error {
+    rounds := cfg.Stages.TLSSANFeedback.MaxRounds
+    if rounds <= 0 { rounds = 1 }
+    subsPath := filepath.Join(wdir, "subdomains.jsonl")
+    known, err := readSubdomainHosts(subsPath)
+    if err != nil { return err }
+
+    var all []sanName
+    var done []string // round directories
+    source := filepath.Join(wdir, "web.jsonl")
+    for r := 1; r <= rounds; r++ {
+        rdir := filepath.Join(wdir, "san", fmt.Sprintf("round-%d", r))
+        if err := os.MkdirAll(rdir, 0o755); err != nil { return err }
+        namesPath := filepath.Join(rdir, "names.jsonl")
+        if !exists(namesPath) {
+            names, err := harvestSANs(source, r, known, gate)
+            if err != nil { return err }
+            if err := gate.flush(ctx, "tls_san_feedback"); err != nil { return err }
+            if err := writeJSONL(namesPath, names); err != nil { return err }
+        }
+        names, err := readSANNames(namesPath)
+        if err != nil { return err }
+        for _, n := range names { known[n.Host] = struct{}{} }
+        all = append(all, names...)
+        log.Info().Str("stage", "tls_san_feedback").Int("round", r).Int("new_names", len(names)).Msg("harvested certificate names")
+        if len(names) == 0 { break }
+
+        if err := sanRound(ctx, cfg, t, wdir, rdir, done, names, gate, db); err != nil { return fmt.Errorf("round %d: %w", r, err) }
+        done = append(done, rdir)
+        source = filepath.Join(rdir, "web.jsonl")
+    }
+    for _, name := range sanArtifacts {
+        if err := combineRounds(filepath.Join(wdir, "san", name), done, name); err != nil { return err }
+    }
+    return writeJSONL(sanPath, all)
+}
+
+// combineRounds writes to path the concatenation of the file name in every
+// round directory; rounds without one contribute nothing.
+func combineRounds(path string, rdirs []string, name string) error {
+    var b []byte
+    for _, rdir := range rdirs {
+        part, err := os.ReadFile(filepath.Join(rdir, name))
+        if os.IsNotExist(err) { continue }
+        if err != nil { return err }
+        b = append(b, part...)
+    }
+    if err := os.WriteFile(path+".tmp", b, 0o644); err != nil { return err }
+    return os.Rename(path+".tmp", path)
+}
+
+// harvestSANs collects subject CNs and SANs from an httpx JSONL file and
+// returns the names not yet known that pass the scope check.
+func harvestSANs(webPath string, round int, known map[string]struct{}, gate *scopeGate) ([]sanName, error) {
+    results, err := htool.ReadResults(webPath)
+    if err != nil { return nil, err }
+    found := map[string]string{}
+    for _, res := range results {
+        if res.TLS == nil { continue }
+        for _, n := range append([]string{res.TLS.SubjectCN}, res.TLS.DNSNames...) {
+            h := normalizeSAN(n)
+            if h == "" { continue }
+            if _, ok := known[h]; ok { continue }
+            if _, ok := found[h]; !ok { found[h] = res.URL }
+        }
+    }
+    hosts := make([]string, 0, len(found))
+    for h := range found { hosts = append(hosts, h) }
+    sort.Strings(hosts)
+    var out []sanName
+    for _, h := range hosts {
+        if gate.host("tls_san", h) {
+            out = append(out, sanName{Host: h, Source: "tls_san", Round: round, From: found[h]})
+        }
+    }
+    return out, nil
+}
+
+// normalizeSAN lowercases a certificate name and strips a leading wildcard
+// label. IPs and names that are not valid hostnames yield "".
+func normalizeSAN(n string) string {
+    h := scope.NormalizeHost(n)
+    h = strings.TrimPrefix(h, "*.")
+    if h == "" || !strings.Contains(h, ".") { return "" }
+    if _, err := netip.ParseAddr(h); err == nil { return "" }
+    for _, c := range h {
+        if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_') { return "" }
+    }
+    return h
+}
+
+// sanRound resolves, scans and probes one round of names inside rdir and
+// stores the results. prev are the directories of the earlier rounds,
+// whose IPs and ports count as known alongside the top-level artifacts.
+func sanRound(ctx context.Context, cfg *config.Config, t Target, wdir, rdir string, prev []string, names []sanName, gate *scopeGate, db *store.DB) error {
+    earlier := func(name string) []string {
+        paths := []string{filepath.Join(wdir, name)}
+        for _, d := range prev { paths = append(paths, filepath.Join(d, name)) }
+        return paths
+    }
+    webPath := filepath.Join(rdir, "web.jsonl")
+    if !exists(webPath) {
+        var sb strings.Builder
+        for _, n := range names { sb.WriteString(n.Host + "\n") }
+        listPath := filepath.Join(rdir, "subdomains.txt")
+        if err := os.WriteFile(listPath, []byte(sb.String()), 0o644); err != nil { return err }
+        resolvedPath := filepath.Join(rdir, "resolved.jsonl")
+        if !exists(resolvedPath) {
+            // Certificates name wildcard parents, so SAN names get the same
+            // wildcard filter as brute-forced ones.
+            name, resolve := resolverFor(cfg)
+            rawPath := filepath.Join(rdir, "resolved.raw.jsonl")
+            if err := resolve(ctx, cfg, listPath, rawPath); err != nil { return fmt.Errorf("%s: %w", name, err) }
+            if cfg.DNS.WildcardFilter {
+                if err := filterWildcards(ctx, cfg, resolve, t.Domain, rdir, rawPath, resolvedPath, filepath.Join(rdir, "wildcards.jsonl"), db); err != nil {
+                    return fmt.Errorf("wildcard filter: %w", err)
+                }
+            } else if err := os.Rename(rawPath, resolvedPath); err != nil { return err }
+            if err := ingestAssets(ctx, db, t.Domain, resolvedPath); err != nil { return err }
+        }
+
+        // Only IPs that have not been scanned yet go to naabu.
+        scanned, err := readLines(earlier("ips.txt")...)
+        if err != nil { return err }
+        ipsPath := filepath.Join(rdir, "ips.txt")
+        keep := func(h, ip string) bool {
+            if _, ok := scanned[ip]; ok { return false }
+            return gate.ip("tls_san", h, ip)
+        }
+        if err := ntool.BuildIPsFromDNSX(resolvedPath, ipsPath, cfg.DNS.IPv6Enabled, keep); err != nil { return err }
+        if err := gate.flush(ctx, "tls_san_feedback"); err != nil { return err }
+        portsPath := filepath.Join(rdir, "ports.jsonl")
+        if !exists(portsPath) {
+            if err := ntool.Run(ctx, cfg, ipsPath, portsPath, ntool.Options{OnRate: logRate("tls_san_feedback")}); err != nil { return fmt.Errorf("naabu: %w", err) }
+            if err := ingestServices(ctx, db, portsPath); err != nil { return err }
+        }
+        // New names are probed on every open service of their IPs, including
+        // IPs scanned earlier; bare ip:port probes only cover the new IPs.
+        ports := map[string][]int{}
+        for _, p := range earlier("ports.jsonl") {
+            old, err := readOpenPorts(p, nil)
+            if err != nil && !os.IsNotExist(err) { return err }
+            for ip, ps := range old { ports[ip] = append(ports[ip], ps...) }
+        }
+        newPorts, err := readOpenPorts(portsPath, nil)
+        if err != nil { return err }
+        for ip, ps := range newPorts { ports[ip] = append(ports[ip], ps...) }
+        for ip := range ports {
+            if ok, _ := gate.eng.CheckIP(ip); !ok { delete(ports, ip) }
+        }
+        groups, err := buildProbeGroups(cfg, resolvedPath, ports, func(ip string) bool { _, ok := newPorts[ip]; return ok })
+        if err != nil { return err }
+        if err := probeWeb(ctx, cfg, groups, filepath.Join(rdir, "probe"), wdir, webPath, db); err != nil { return err }
+    }
+
+    marker := filepath.Join(rdir, ".merged")
+    if exists(marker) { return nil }
+    if err := ingestWeb(ctx, db, webPath); err != nil { return err }
+    filtered, err := wildcardFiltered(filepath.Join(rdir, "wildcards.jsonl"))
+    if err != nil { return err }
+    var rows []map[string]string
+    for _, n := range names {
+        if filtered[n.Host] { continue }
+        rows = append(rows, map[string]string{"host": n.Host, "source": n.Source})
+    }
+    if err := writeJSONL(filepath.Join(rdir, "subdomains.jsonl"), rows); err != nil { return err }
+    return os.WriteFile(marker, nil, 0o644)
+}
+
+// wildcardFiltered returns the hosts a wildcards.jsonl file lists as
+// filtered. A missing file filters nothing.
+func wildcardFiltered(path string) (map[string]bool, error) {
+    f, err := os.Open(path)
+    if os.IsNotExist(err) { return nil, nil }
+    if err != nil { return nil, err }
+    defer f.Close()
+    out := map[string]bool{}
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+    for sc.Scan() {
+        var v zoneVerdict
+        if err := json.Unmarshal(sc.Bytes(), &v); err != nil { continue }
+        for _, h := range v.Filtered { out[h] = true }
+    }
+    return out, sc.Err()
+}
+
+func readSANNames(path string) ([]sanName, error) {
+    f, err := os.Open(path)
+    if err != nil { return nil, err }
+    defer f.Close()
+    var out []sanName
+    sc := bufio.NewScanner(f)
+    for sc.Scan() {
+        var n sanName
+        if err := json.Unmarshal(sc.Bytes(), &n); err == nil && n.Host != "" { out = append(out, n) }
+    }
+    return out, sc.Err()
+}
+
+// writeJSONL writes one JSON document per row via a temp file and rename.
+func writeJSONL[T any](path string, rows []T) error {
+    var sb strings.Builder
+    for _, r := range rows {
+        b, err := json.Marshal(r)
+        if err != nil { return err }
+        sb.Write(append(b, '\n'))
+    }
+    if err := os.WriteFile(path+".tmp", []byte(sb.String()), 0o644); err != nil { return err }
+    return os.Rename(path+".tmp", path)
+}
+
+// readLines returns the non-empty lines of paths as a set. Missing files
+// add nothing.
+func readLines(paths ...string) (map[string]struct{}, error) {
+    out := map[string]struct{}{}
+    for _, path := range paths {
+        b, err := os.ReadFile(path)
+        if os.IsNotExist(err) { continue }
+        if err != nil { return nil, err }
+        for _, l := range strings.Split(string(b), "\n") {
+            if l = strings.TrimSpace(l); l != "" { out[l] = struct{}{} }
+        }
+    }
+    return out, nil
+}
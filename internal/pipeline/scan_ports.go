@@ -0,0 +1,53 @@
+package pipeline
+
+import (
+    "context"
+    "fmt"
+
+    "github.com/rs/zerolog/log"
+    ntool "hermetica/internal/tool/naabu"
+)
+
+// scanStage port-scans the in-scope IPs with naabu.
+type scanStage struct{}
+
+func (scanStage) Name() string { return "scan_ports" }
+func (scanStage) Enabled(*Env) bool { return true }
+func (scanStage) Inputs(e *Env) []string { return []string{e.Path("resolved.jsonl")} }
+func (scanStage) Outputs(e *Env) []string { return []string{e.Path("ports.jsonl"), e.Path("ips.txt")} }
+
+func (scanStage) ConfigKey(e *Env) any {
+    return []any{e.Cfg.Scan.Profile, e.Cfg.DNS.IPv6Enabled, e.Cfg.Scope}
+}
+
+// Run scans in batches and checkpoints after each one. Unless fresh, a scan
+// interrupted earlier continues from ports.jsonl.partial with only the IPs
+// it had not covered.
+func (scanStage) Run(ctx context.Context, e *Env, fresh bool) error {
+    ipsPath, portsPath := e.Path("ips.txt"), e.Path("ports.jsonl")
+    keep := func(h, ip string) bool { return e.gate.ip("dnsx", h, ip) }
+    if err := ntool.BuildIPsFromDNSX(e.Path("resolved.jsonl"), ipsPath, e.Cfg.DNS.IPv6Enabled, keep); err != nil { return err }
+    if err := e.gate.flush(ctx, "scan_ports"); err != nil { return err }
+    log.Info().Str("stage","scan_ports").Msg("running naabu")
+    opt := ntool.Options{OnRate: logRate("scan_ports")}
+    var prog ntool.Progress
+    if !fresh && e.ck.partial("scan_ports", &prog) {
+        log.Info().Str("stage","scan_ports").Int("covered", len(prog.Covered)).Msg("continuing interrupted scan")
+        opt.Resume = &prog
+    }
+    opt.OnBatch = func(p ntool.Progress) {
+        if err := e.ck.progress("scan_ports", portsPath+".partial", p); err != nil { log.Warn().Err(err).Str("stage","scan_ports").Msg("saving checkpoint") }
+    }
+    if err := ntool.Run(ctx, e.Cfg, ipsPath, portsPath, opt); err != nil { return fmt.Errorf("naabu: %w", err) }
+    if err := ingestServices(ctx, e.DB, portsPath); err != nil { return fmt.Errorf("ingest services: %w", err) }
+    return nil
+}
+
+// logRate returns a naabu rate-change hook that logs under stage.
+func logRate(stage string) func(ntool.RateChange) {
+    return func(c ntool.RateChange) {
+        msg := "raising naabu rate"
+        if c.To < c.From { msg = "lowering naabu rate" }
+        log.Info().Str("stage", stage).Int("batch", c.Batch).Float64("loss", c.Loss).Int("from", c.From).Int("to", c.To).Msg(msg)
+    }
+}
@@ -0,0 +1,122 @@
+package pipeline
+
+import (
+    "context"
+    "fmt"
+
+    "github.com/rs/zerolog/log"
+    "hermetica/internal/executil"
+)
+
+// plan returns the enabled stages in registration order and, for each, the
+// names of the stages it waits for.
+func plan(e *Env) ([]Stage, map[string][]string) {
+    var stages []Stage
+    for _, s := range registry {
+        if s.Enabled(e) { stages = append(stages, s) }
+    }
+    deps := map[string][]string{}
+    writers := map[string][]string{} // path -> earlier stages writing it
+    for _, s := range stages {
+        seen := map[string]bool{}
+        for _, in := range s.Inputs(e) {
+            for _, w := range writers[in] {
+                if !seen[w] { seen[w] = true; deps[s.Name()] = append(deps[s.Name()], w) }
+            }
+        }
+        for _, out := range s.Outputs(e) { writers[out] = append(writers[out], s.Name()) }
+    }
+    return stages, deps
+}
+
+// scheduler runs the planned stages, each as soon as the stages it waits
+// for have finished, and owns the skip/force/checkpoint decisions.
+type scheduler struct {
+    env    *Env
+    ck     *checkpoint
+    force  bool
+    resume bool
+    due    map[string]bool // watch mode: only these stages are fresh
+    meta   *runMeta        // records tool invocations; may be nil
+    ran    map[string]bool // stages that ran in this run
+    done   map[string]bool // stages that ran and finished in this run
+}
+
+type stageResult struct {
+    name string
+    ran  bool
+    err  error
+}
+
+func (sc *scheduler) run(ctx context.Context) error {
+    stages, deps := plan(sc.env)
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    done := map[string]bool{}
+    sc.ran, sc.done = map[string]bool{}, map[string]bool{}
+    started := map[string]bool{}
+    results := make(chan stageResult)
+    running := 0
+    var firstErr error
+    for {
+        if firstErr == nil {
+            for _, s := range stages {
+                name := s.Name()
+                if started[name] { continue }
+                ready, fresh := true, sc.force
+                if sc.due != nil { fresh = sc.due[name] }
+                for _, d := range deps[name] {
+                    if !done[d] { ready = false; break }
+                    if sc.ran[d] && sc.due == nil { fresh = true }
+                }
+                if !ready { continue }
+                started[name] = true
+                running++
+                go func(s Stage, fresh bool) {
+                    r, err := sc.stage(ctx, s, fresh)
+                    results <- stageResult{name: s.Name(), ran: r, err: err}
+                }(s, fresh)
+            }
+        }
+        if running == 0 { return firstErr }
+        res := <-results
+        running--
+        done[res.name], sc.ran[res.name] = true, res.ran
+        if res.ran && res.err == nil { sc.done[res.name] = true }
+        if res.err != nil && firstErr == nil {
+            firstErr = res.err
+            cancel()
+        }
+    }
+}
+
+// stage runs s unless its artifact is current and reports whether it ran.
+// In run mode an existing artifact is current; in resume mode the
+// checkpoint must also show the stage done with the same config, artifact
+// and inputs. A fresh stage always runs.
+func (sc *scheduler) stage(ctx context.Context, s Stage, fresh bool) (bool, error) {
+    name := s.Name()
+    artifact := s.Outputs(sc.env)[0]
+    cfgHash := stageConfigHash(s, sc.env)
+    if !fresh {
+        if sc.resume {
+            ok, why := sc.ck.check(name, cfgHash)
+            if ok {
+                log.Info().Str("stage", name).Msg("skipping (checkpoint current)")
+                return false, nil
+            }
+            log.Info().Str("stage", name).Str("reason", why).Msg("resuming here")
+        } else if exists(artifact) {
+            log.Info().Str("stage", name).Msg("skipping (artifact exists)")
+            return false, nil
+        }
+    }
+    if err := sc.ck.begin(name, cfgHash, artifact, s.Inputs(sc.env)); err != nil { return false, err }
+    ctx = executil.WithStage(ctx, name)
+    if sc.meta != nil { ctx = sc.meta.observe(ctx, name) }
+    if err := s.Run(ctx, sc.env, fresh); err != nil {
+        _ = sc.ck.fail(name, err, ctx.Err() != nil)
+        return true, fmt.Errorf("%s: %w", name, err)
+    }
+    return true, sc.ck.finish(name)
+}
@@ -0,0 +1,206 @@
+package pipeline
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "reflect"
+    "slices"
+    "sort"
+    "strings"
+    "sync"
+    "testing"
+
+    "hermetica/internal/config"
+)
+
+// fakeStage reads its inputs and writes its name plus their contents to
+// its outputs, logging every run to log.
+type fakeStage struct {
+    name    string
+    in, out []string
+    log     *fakeLog
+}
+
+// fakeLog records the stages that ran and lets tests set the config key
+// or replace the Run of a stage.
+type fakeLog struct {
+    mu   sync.Mutex
+    runs []string // "name" or "name(fresh)"
+    keys map[string]string
+    run  map[string]func(e *Env, fresh bool) error
+}
+
+func (s fakeStage) Name() string { return s.name }
+func (s fakeStage) Enabled(*Env) bool { return true }
+func (s fakeStage) Inputs(e *Env) []string { return paths(e, s.in) }
+func (s fakeStage) Outputs(e *Env) []string { return paths(e, s.out) }
+func (s fakeStage) ConfigKey(*Env) any { return s.log.keys[s.name] }
+
+func (s fakeStage) Run(_ context.Context, e *Env, fresh bool) error {
+    s.log.mu.Lock()
+    r := s.name
+    if fresh { r += "(fresh)" }
+    s.log.runs = append(s.log.runs, r)
+    run := s.log.run[s.name]
+    s.log.mu.Unlock()
+    if run != nil { return run(e, fresh) }
+    body := s.name + "\n"
+    for _, in := range s.Inputs(e) {
+        b, _ := os.ReadFile(in)
+        body += string(b)
+    }
+    for _, out := range s.Outputs(e) {
+        if err := os.WriteFile(out, []byte(body), 0o644); err != nil { return err }
+    }
+    return nil
+}
+
+func paths(e *Env, names []string) []string {
+    out := make([]string, len(names))
+    for i, n := range names { out[i] = e.Path(n) }
+    return out
+}
+
+// take returns the runs logged so far, sorted, and clears the log.
+func (l *fakeLog) take() []string {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    out := l.runs
+    l.runs = nil
+    sort.Strings(out)
+    return out
+}
+
+// withRegistry replaces the stage registry for the duration of the test.
+func withRegistry(t *testing.T, stages ...Stage) {
+    old := registry
+    registry = stages
+    t.Cleanup(func() { registry = old })
+}
+
+// fakePipeline registers a diamond, a stage appending to a's artifact and
+// an independent stage:
+//
+//    a, a2 -> b -> d
+//          \> c /
+//    e
+func fakePipeline(t *testing.T) *fakeLog {
+    l := &fakeLog{keys: map[string]string{}, run: map[string]func(*Env, bool) error{}}
+    withRegistry(t,
+        fakeStage{name: "a", out: []string{"a.txt"}, log: l},
+        fakeStage{name: "a2", in: []string{"a.txt"}, out: []string{"a2.txt", "a.txt"}, log: l},
+        fakeStage{name: "b", in: []string{"a.txt"}, out: []string{"b.txt"}, log: l},
+        fakeStage{name: "c", in: []string{"a.txt"}, out: []string{"c.txt"}, log: l},
+        fakeStage{name: "d", in: []string{"b.txt", "c.txt"}, out: []string{"d.txt"}, log: l},
+        fakeStage{name: "e", out: []string{"e.txt"}, log: l},
+    )
+    return l
+}
+
+func TestPlan(t *testing.T) {
+    fakePipeline(t)
+    stages, deps := plan(&Env{Cfg: &config.Config{}, Dir: t.TempDir()})
+    var names []string
+    for _, s := range stages { names = append(names, s.Name()) }
+    if strings.Join(names, " ") != "a a2 b c d e" { t.Errorf("stages = %v", names) }
+    want := map[string][]string{"a2": {"a"}, "b": {"a", "a2"}, "c": {"a", "a2"}, "d": {"b", "c"}}
+    if !reflect.DeepEqual(deps, want) { t.Errorf("deps = %v, want %v", deps, want) }
+}
+
+func TestSchedulerResume(t *testing.T) {
+    for _, tc := range []struct {
+        name   string
+        change func(t *testing.T, dir string, l *fakeLog)
+        force  bool
+        want   []string
+    }{
+        {"nothing changed", func(*testing.T, string, *fakeLog) {}, false, nil},
+        {"artifact modified", func(t *testing.T, dir string, _ *fakeLog) { writeFile(t, dir, "b.txt", "edited\n") }, false, []string{"b", "d(fresh)"}},
+        {"config changed", func(_ *testing.T, _ string, l *fakeLog) { l.keys["c"] = "v2" }, false, []string{"c", "d(fresh)"}},
+        {"upstream config changed", func(_ *testing.T, _ string, l *fakeLog) { l.keys["a"] = "v2" }, false, []string{"a", "a2(fresh)", "b(fresh)", "c(fresh)", "d(fresh)"}},
+        {"independent stage failed", func(t *testing.T, dir string, l *fakeLog) {
+            ck, err := loadCheckpoint(dir)
+            if err != nil { t.Fatal(err) }
+            if err := ck.fail("e", errors.New("boom"), false); err != nil { t.Fatal(err) }
+        }, false, []string{"e"}},
+        {"force", func(*testing.T, string, *fakeLog) {}, true, []string{"a(fresh)", "a2(fresh)", "b(fresh)", "c(fresh)", "d(fresh)", "e(fresh)"}},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            l := fakePipeline(t)
+            dir := t.TempDir()
+            if err := runFake(dir, false, false); err != nil { t.Fatal(err) }
+            if got := l.take(); len(got) != 6 { t.Fatalf("first run = %v", got) }
+            tc.change(t, dir, l)
+            if err := runFake(dir, tc.force, true); err != nil { t.Fatal(err) }
+            if got := l.take(); !reflect.DeepEqual(got, tc.want) { t.Errorf("ran %v, want %v", got, tc.want) }
+        })
+    }
+}
+
+func TestSchedulerRunMode(t *testing.T) {
+    // Without --resume an existing artifact is enough to skip a stage,
+    // and a stage that ran makes every stage after it fresh.
+    l := fakePipeline(t)
+    dir := t.TempDir()
+    if err := runFake(dir, false, false); err != nil { t.Fatal(err) }
+    l.take()
+    writeFile(t, dir, "b.txt", "edited\n")
+    l.keys["c"] = "v2"
+    if err := runFake(dir, false, false); err != nil { t.Fatal(err) }
+    if got := l.take(); got != nil { t.Errorf("ran %v with every artifact present", got) }
+    if err := os.Remove(filepath.Join(dir, "a2.txt")); err != nil { t.Fatal(err) }
+    if err := runFake(dir, false, false); err != nil { t.Fatal(err) }
+    if got, want := l.take(), []string{"a2", "b(fresh)", "c(fresh)", "d(fresh)"}; !reflect.DeepEqual(got, want) { t.Errorf("ran %v, want %v", got, want) }
+}
+
+func TestSchedulerFailure(t *testing.T) {
+    // A failed stage stops its dependents; the resumed run starts at the
+    // failed stage and continues from its recorded progress.
+    l := fakePipeline(t)
+    dir := t.TempDir()
+    attempt := 0
+    l.run["c"] = func(e *Env, fresh bool) error {
+        attempt++
+        var done int
+        if !fresh { e.ck.partial("c", &done) }
+        part := e.Path("c.txt.partial")
+        for ; done < 4; done++ {
+            if attempt == 1 && done == 2 { return errors.New("connection reset") }
+            f, err := os.OpenFile(part, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+            if err != nil { return err }
+            fmt.Fprintf(f, "%d\n", done)
+            f.Close()
+            if err := e.ck.progress("c", part, done+1); err != nil { return err }
+        }
+        return os.Rename(part, e.Path("c.txt"))
+    }
+    err := runFake(dir, false, true)
+    if err == nil || !strings.Contains(err.Error(), "c: connection reset") { t.Fatalf("err = %v", err) }
+    ran := l.take()
+    for _, s := range ran {
+        if s == "d" || s == "d(fresh)" { t.Errorf("d ran after c failed: %v", ran) }
+    }
+    ck, err := loadCheckpoint(dir)
+    if err != nil { t.Fatal(err) }
+    if s := ck.Stages["c"]; s.Status != stageFailed || s.Error != "connection reset" || s.Partial == nil { t.Errorf("checkpoint for c = %+v", s) }
+
+    if err := runFake(dir, false, true); err != nil { t.Fatal(err) }
+    got := l.take()
+    if len(got) == 0 || got[0] != "c" { t.Errorf("resume ran %v, want c first", got) }
+    if !slices.Contains(got, "d(fresh)") { t.Errorf("resume ran %v, want d after c", got) }
+    b, err := os.ReadFile(filepath.Join(dir, "c.txt"))
+    if err != nil { t.Fatal(err) }
+    if string(b) != "0\n1\n2\n3\n" { t.Errorf("c.txt = %q, want the rows of both attempts once", b) }
+}
+
+// runFake runs the registered stages once against dir.
+func runFake(dir string, force, resume bool) error {
+    ck, err := loadCheckpoint(dir)
+    if err != nil { return err }
+    env := &Env{Cfg: &config.Config{}, Dir: dir, ck: ck}
+    sc := &scheduler{env: env, ck: ck, force: force, resume: resume}
+    return sc.run(context.Background())
+}
@@ -0,0 +1,64 @@
+package pipeline
+
+import (
+    "context"
+    "sync"
+    "time"
+
+    "github.com/rs/zerolog/log"
+    "hermetica/internal/scope"
+    "hermetica/internal/store"
+)
+
+// scopeGate wraps the scope engine and buffers discovery rows so that each
+// filtering pass is written to the store in one transaction. It is safe for
+// use by concurrent stages.
+type scopeGate struct {
+    eng     *scope.Engine
+    db      *store.DB
+    mu      sync.Mutex
+    pending []store.Discovery
+}
+
+func newScopeGate(eng *scope.Engine, db *store.DB) *scopeGate {
+    return &scopeGate{eng: eng, db: db}
+}
+
+// host checks a candidate hostname before resolution and records the decision.
+func (g *scopeGate) host(source, h string) bool {
+    ok, reason := g.eng.CheckHost(h)
+    g.record(source, scope.NormalizeHost(h), ok, reason)
+    if !ok { log.Debug().Str("host", h).Str("reason", reason).Msg("out of scope") }
+    return ok
+}
+
+// ip checks a resolved IP before scanning or probing. Only rejections are
+// recorded; the hostname was already recorded when it was discovered.
+func (g *scopeGate) ip(source, h, ip string) bool {
+    ok, reason := g.eng.CheckIP(ip)
+    if !ok {
+        g.record(source, h, false, reason)
+        log.Debug().Str("host", h).Str("ip", ip).Str("reason", reason).Msg("out of scope")
+    }
+    return ok
+}
+
+func (g *scopeGate) record(source, h string, inScope bool, note string) {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    g.pending = append(g.pending, store.Discovery{Source: source, Hostname: h, InScope: inScope, Note: note, SeenAt: time.Now().UTC()})
+}
+
+// flush writes buffered discovery rows and logs a summary for the stage.
+func (g *scopeGate) flush(ctx context.Context, stage string) error {
+    g.mu.Lock()
+    batch := g.pending
+    g.pending = nil
+    g.mu.Unlock()
+    rejected := 0
+    for _, d := range batch { if !d.InScope { rejected++ } }
+    if len(batch) > 0 {
+        log.Info().Str("stage", stage).Int("recorded", len(batch)).Int("rejected", rejected).Msg("scope filter")
+    }
+    return g.db.AddDiscoveries(ctx, batch)
+}
@@ -0,0 +1,137 @@
+package pipeline
+
+import (
+    "context"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/rs/zerolog/log"
+    "hermetica/internal/config"
+    "hermetica/internal/store"
+    gtool "hermetica/internal/tool/gowitness"
+    htool "hermetica/internal/tool/httpx"
+)
+
+// screenshotStage captures one screenshot per page.
+type screenshotStage struct{}
+
+func (screenshotStage) Name() string { return "screenshots" }
+func (screenshotStage) Enabled(e *Env) bool { return e.Cfg.Stages.Screenshots.Enabled || e.Cfg.Evidence.StoreScreenshots }
+func (screenshotStage) Inputs(e *Env) []string { return e.withSAN("web.jsonl") }
+func (screenshotStage) Outputs(e *Env) []string { return []string{e.Path("screenshots.jsonl")} }
+
+func (screenshotStage) Run(ctx context.Context, e *Env, _ bool) error {
+    log.Info().Str("stage","screenshots").Msg("running gowitness")
+    return takeScreenshots(ctx, e.Cfg, e.Dir, e.withSAN("web.jsonl"), e.Path("screenshots.jsonl"), e.DB)
+}
+
+const shotWorkers = 4
+
+// shotRow is one row of screenshots.jsonl. Path is relative to the target
+// directory, like shot_path in the store.
+type shotRow struct {
+    ID         string   `json:"id"`
+    URL        string   `json:"url"`
+    PageGroup  string   `json:"page_group,omitempty"`
+    Path       string   `json:"path,omitempty"`
+    WebTargets []string `json:"webtargets"`
+    err        error
+}
+
+// takeScreenshots captures every page once, at no more than
+// rate_limit_per_min shots per minute, into shots/<id>.png where id is the
+// first web target showing that page. Rows sharing a page_group share one
+// shot taken from a reachable member; other rows are shot per URL. Only
+// shots that were taken get a row in outPath; failed ones are logged and
+// tried again the next time the stage runs. Shots already on disk are
+// reused, so a crashed run resumes where it stopped.
+func takeScreenshots(ctx context.Context, cfg *config.Config, wdir string, webPaths []string, outPath string, db *store.DB) error {
+    results, err := readWebResults(webPaths)
+    if err != nil { return err }
+    type page struct { best htool.Result; ids []string }
+    pages := map[string]*page{}
+    for _, r := range results {
+        key := reachableURL(r)
+        if r.PageGroup != "" { key = "group:" + r.PageGroup }
+        if key == "" { continue }
+        id := r.WebTargetID
+        if id == "" {
+            if id, err = seedWebTargetID(ctx, db, r); err != nil { return err }
+        }
+        p := pages[key]
+        if p == nil { p = &page{}; pages[key] = p }
+        if id != "" { p.ids = append(p.ids, id) }
+        u := reachableURL(r)
+        if u == "" { continue }
+        cur := reachableURL(p.best)
+        if cur == "" || (p.best.SNIMode != htool.ModeSNIHost && r.SNIMode == htool.ModeSNIHost) || (p.best.SNIMode == r.SNIMode && u < cur) { p.best = r }
+    }
+    jobs := make([]shotRow, 0, len(pages))
+    for _, p := range pages {
+        u := reachableURL(p.best)
+        if u == "" || len(p.ids) == 0 { continue }
+        sort.Strings(p.ids)
+        jobs = append(jobs, shotRow{ID: p.ids[0], URL: u, PageGroup: p.best.PageGroup, Path: filepath.Join("shots", p.ids[0]+".png"), WebTargets: p.ids})
+    }
+    sort.Slice(jobs, func(i, j int) bool { return jobs[i].URL < jobs[j].URL })
+
+    timeout := time.Duration(cfg.Limits.HTTPXTimeoutSec*3) * time.Second
+    var interval time.Duration
+    if r := cfg.Stages.Screenshots.RateLimitPerMin; r > 0 { interval = time.Minute / time.Duration(r) }
+    log.Info().Str("stage", "screenshots").Int("urls", len(jobs)).Dur("interval", interval).Msg("taking screenshots")
+
+    work := make(chan int)
+    var wg sync.WaitGroup
+    for w := 0; w < shotWorkers; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range work {
+                j := &jobs[i]
+                if j.err = gtool.Shoot(ctx, cfg, j.URL, filepath.Join(wdir, j.Path), timeout); j.err != nil {
+                    log.Debug().Str("stage", "screenshots").Str("url", j.URL).Err(j.err).Msg("screenshot failed")
+                    continue
+                }
+                j.err = db.SetShotPath(ctx, j.WebTargets, j.Path)
+            }
+        }()
+    }
+    var tick <-chan time.Time
+    if interval > 0 {
+        t := time.NewTicker(interval)
+        defer t.Stop()
+        tick = t.C
+    }
+    taken := 0
+    feed:
+    for i := range jobs {
+        if exists(filepath.Join(wdir, jobs[i].Path)) {
+            if err := db.SetShotPath(ctx, jobs[i].WebTargets, jobs[i].Path); err != nil { return err }
+            continue
+        }
+        if tick != nil && taken > 0 {
+            select {
+            case <-tick:
+            case <-ctx.Done():
+                break feed
+            }
+        }
+        select {
+        case work <- i:
+            taken++
+        case <-ctx.Done():
+            break feed
+        }
+    }
+    close(work)
+    wg.Wait()
+    if err := ctx.Err(); err != nil { return err }
+    var rows []shotRow
+    for _, j := range jobs {
+        if j.err == nil { rows = append(rows, j) }
+    }
+    log.Info().Str("stage", "screenshots").Int("taken", taken).Int("failed", len(jobs)-len(rows)).Msg("screenshots complete")
+    return writeJSONL(outPath, rows)
+}
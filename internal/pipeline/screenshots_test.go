@@ -0,0 +1,54 @@
+package pipeline
+
+import (
+    "context"
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "hermetica/internal/config"
+)
+
+// fakeGowitness writes a PNG into --screenshot-path unless the URL contains
+// "broken", where it exits non-zero like a page that never loads.
+const fakeGowitness = `#!/bin/sh
+while [ $# -gt 0 ]; do
+    case "$1" in
+    --url) url=$2; shift ;;
+    --screenshot-path) dir=$2; shift ;;
+    esac
+    shift
+done
+case "$url" in *broken*) echo "navigation failed" >&2; exit 2 ;; esac
+printf 'PNG' > "$dir/shot.png"
+`
+
+func TestTakeScreenshots(t *testing.T) {
+    dir := t.TempDir()
+    bin := filepath.Join(dir, "gowitness")
+    if err := os.WriteFile(bin, []byte(fakeGowitness), 0o755); err != nil { t.Fatal(err) }
+    web := filepath.Join(dir, "web.jsonl")
+    rows := `{"url":"https://192.0.2.1:443","host":"192.0.2.1","port":"443","sni_mode":"direct_ip","webtarget_id":"01A"}
+{"url":"https://192.0.2.2:443","host":"192.0.2.2","port":"443","sni_mode":"sni_host","input_host":"broken.example.com","webtarget_id":"01B"}
+`
+    if err := os.WriteFile(web, []byte(rows), 0o644); err != nil { t.Fatal(err) }
+    cfg := &config.Config{}
+    cfg.Tools.Paths = map[string]string{"gowitness": bin}
+    cfg.Limits.HTTPXTimeoutSec = 1
+    out := filepath.Join(dir, "screenshots.jsonl")
+
+    for run := 1; run <= 2; run++ {
+        if err := takeScreenshots(context.Background(), cfg, dir, []string{web}, out, openTestDB(t)); err != nil { t.Fatal(err) }
+        b, err := os.ReadFile(out)
+        if err != nil { t.Fatal(err) }
+        lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+        if len(lines) != 1 { t.Fatalf("run %d: %d rows, want only the taken shot:\n%s", run, len(lines), b) }
+        var row shotRow
+        if err := json.Unmarshal([]byte(lines[0]), &row); err != nil { t.Fatal(err) }
+        if row.URL != "https://192.0.2.1:443" || row.Path != filepath.Join("shots", "01A.png") { t.Errorf("run %d: row = %+v", run, row) }
+        if !exists(filepath.Join(dir, row.Path)) { t.Errorf("run %d: %s not written under the target dir", run, row.Path) }
+        if exists(filepath.Join(dir, "shots", "01B.png")) { t.Errorf("run %d: failed shot left a file", run) }
+    }
+}
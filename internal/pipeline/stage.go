@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+    "context"
+    "fmt"
+    "path/filepath"
+
+    "hermetica/internal/config"
+    "hermetica/internal/store"
+)
+
+// Stage is one step of the pipeline. Stages are linked only through the
+// files they read and write: a stage runs after every earlier-registered
+// enabled stage that writes one of its inputs, and stages with no such link
+// run concurrently.
+type Stage interface {
+    // Name identifies the stage in logs and the checkpoint.
+    Name() string
+    // Enabled reports whether the stage runs for this target.
+    Enabled(e *Env) bool
+    // Inputs lists the files the stage reads.
+    Inputs(e *Env) []string
+    // Outputs lists the files the stage writes or appends to. The first is
+    // the stage's artifact: when it exists and is current the stage is
+    // skipped.
+    Outputs(e *Env) []string
+    // Run produces the outputs. fresh is set when an upstream stage ran
+    // in this run, so partial state kept from an earlier attempt is stale.
+    Run(ctx context.Context, e *Env, fresh bool) error
+}
+
+// configKeyer is implemented by stages whose results depend on settings;
+// the checkpoint hashes the returned value to detect stale results. Rates,
+// timeouts and tool paths belong out of it.
+type configKeyer interface {
+    ConfigKey(e *Env) any
+}
+
+// Env is what a stage sees of the run.
+type Env struct {
+    Cfg    *config.Config
+    Target Target
+    Dir    string // work/<domain>
+    DB     *store.DB
+
+    gate *scopeGate
+    ck   *checkpoint
+}
+
+// Path returns name inside the target directory.
+func (e *Env) Path(name string) string { return filepath.Join(e.Dir, name) }
+
+// registry holds the stages in registration order, which breaks ties when
+// several stages write the same file: a stage that appends to an upstream
+// artifact (brute_dns) is registered after its creator and before its
+// other readers.
+var registry = []Stage{
+    discoverStage{},
+    bruteStage{},
+    resolveStage{},
+    scanStage{},
+    probeStage{},
+    sanStage{},
+    vhostStage{},
+    crawlStage{},
+    screenshotStage{},
+}
+
+// Register appends s to the pipeline. It panics when the name is taken.
+func Register(s Stage) {
+    if lookupStage(s.Name()) != nil { panic(fmt.Sprintf("pipeline: stage %q registered twice", s.Name())) }
+    registry = append(registry, s)
+}
+
+// Registered lists the names of all registered stages, enabled or not.
+func Registered() []string {
+    names := make([]string, len(registry))
+    for i, s := range registry { names[i] = s.Name() }
+    return names
+}
+
+func lookupStage(name string) Stage {
+    for _, s := range registry {
+        if s.Name() == name { return s }
+    }
+    return nil
+}
+
+// stageConfigHash hashes the settings s depends on.
+func stageConfigHash(s Stage, e *Env) string {
+    if k, ok := s.(configKeyer); ok { return hashJSON(k.ConfigKey(e)) }
+    return hashJSON(nil)
+}
@@ -3,86 +3,131 @@ package pipeline
 import (
     "context"
     "fmt"
-    "encoding/json"
-    "bufio"
     "os"
     "path/filepath"
+    "strings"
 
     "github.com/rs/zerolog/log"
     "hermetica/internal/config"
+    "hermetica/internal/resolver"
+    "hermetica/internal/scope"
+    "hermetica/internal/store"
     dtool "hermetica/internal/tool/dnsx"
-    htool "hermetica/internal/tool/httpx"
-    ntool "hermetica/internal/tool/naabu"
-    stool "hermetica/internal/tool/subfinder"
 )
 
 type Target = config.Target
 
+// Run executes the pipeline for t, skipping stages whose artifact already
+// exists unless force is set.
 func Run(ctx context.Context, cfg *config.Config, t Target, force bool) error {
-    wdir := filepath.Join(cfg.Workdir, t.Domain)
-    if err := os.MkdirAll(wdir, 0o755); err != nil { return err }
-    // Stage 1: discover_subdomains
-    subsPath := filepath.Join(wdir, "subdomains.jsonl")
-    if force || !exists(subsPath) {
-        log.Info().Str("stage","discover_subdomains").Str("domain", t.Domain).Msg("running subfinder")
-        if err := stool.Run(ctx, cfg, t.Domain, subsPath); err != nil { return fmt.Errorf("subfinder: %w", err) }
-    } else { log.Info().Str("stage","discover_subdomains").Msg("skipping (artifact exists)") }
+    _, _, err := run(ctx, cfg, t, force, false, nil)
+    return err
+}
+
+// Resume continues the last run in work/<domain>/ from its checkpoint. Stages
+// that completed with unchanged artifacts and inputs are skipped; the first
+// incomplete or invalidated stage and every stage depending on it run again.
+// It refuses to continue when the config changed for a completed stage.
+func Resume(ctx context.Context, cfg *config.Config, t Target) error {
+    _, _, err := run(ctx, cfg, t, false, true, nil)
+    return err
+}
+
+// RunStages runs the stages named in due as if forced. Any other stage runs
+// only when its artifact is missing, even when a stage it depends on ran,
+// which lets `hermetica watch` give every stage its own cadence. It returns
+// the ID of the recorded run, empty when the run could not start, and the
+// stages that ran to completion; after a failure that may leave out some
+// of due.
+func RunStages(ctx context.Context, cfg *config.Config, t Target, due map[string]bool) (string, map[string]bool, error) {
+    return run(ctx, cfg, t, false, false, due)
+}
 
-    // Stage 2: resolve_dns
-    listPath := filepath.Join(wdir, "subdomains.txt")
-    if force || !exists(listPath) { if err := dtool.BuildInputFromSubfinder(subsPath, listPath); err != nil { return err } }
-    resolvedPath := filepath.Join(wdir, "resolved.jsonl")
-    if force || !exists(resolvedPath) {
-        log.Info().Str("stage","resolve_dns").Msg("running dnsx")
-        if err := dtool.Run(ctx, cfg, listPath, resolvedPath); err != nil { return fmt.Errorf("dnsx: %w", err) }
-    } else { log.Info().Str("stage","resolve_dns").Msg("skipping (artifact exists)") }
+// StageNames lists the stages enabled for t, in the order they are planned.
+func StageNames(cfg *config.Config, t Target) []string {
+    stages, _ := plan(&Env{Cfg: cfg, Target: t, Dir: filepath.Join(cfg.Workdir, t.Domain)})
+    names := make([]string, len(stages))
+    for i, s := range stages { names[i] = s.Name() }
+    return names
+}
 
-    // Stage 3: scan_ports
-    ipsPath := filepath.Join(wdir, "ips.txt")
-    if force || !exists(ipsPath) { if err := ntool.BuildIPsFromDNSX(resolvedPath, ipsPath, cfg.DNS.IPv6Enabled || t.IPv6Enabled); err != nil { return err } }
-    portsPath := filepath.Join(wdir, "ports.jsonl")
-    if force || !exists(portsPath) {
-        log.Info().Str("stage","scan_ports").Msg("running naabu")
-        if err := ntool.Run(ctx, cfg, ipsPath, portsPath); err != nil { return fmt.Errorf("naabu: %w", err) }
-    } else { log.Info().Str("stage","scan_ports").Msg("skipping (artifact exists)") }
+func run(ctx context.Context, cfg *config.Config, t Target, force, resume bool, due map[string]bool) (string, map[string]bool, error) {
+    wdir := filepath.Join(cfg.Workdir, t.Domain)
+    if err := os.MkdirAll(wdir, 0o755); err != nil { return "", nil, err }
+    eng, err := scope.New(cfg.Scope)
+    if err != nil { return "", nil, fmt.Errorf("scope: %w", err) }
+    env := &Env{Cfg: cfg, Target: t, Dir: wdir}
+    ck, err := loadCheckpoint(wdir)
+    if err != nil { return "", nil, err }
+    if resume {
+        hashes := map[string]string{}
+        for name := range ck.Stages {
+            if s := lookupStage(name); s != nil { hashes[name] = stageConfigHash(s, env) }
+        }
+        if stale := ck.stale(hashes); len(stale) > 0 {
+            return "", nil, fmt.Errorf("config changed for completed stage(s) %s; rerun with `hermetica run --force`", strings.Join(stale, ", "))
+        }
+    }
+    ck.Domain, ck.ConfigHash = t.Domain, hashJSON(cfg)
+    db, err := openStore(cfg)
+    if err != nil { return "", nil, fmt.Errorf("store: %w", err) }
+    defer db.Close()
+    env.DB, env.gate, env.ck = db, newScopeGate(eng, db), ck
 
-    // Stage 4: probe_http (basic version)
-    // Derive host:port list for httpx input using resolved hosts and open ports.
-    // For v1 minimal, probe IP:port directly. Host/SNI matrix will be added in a follow-up.
-    hpList := filepath.Join(wdir, "targets.txt")
-    if force || !exists(hpList) {
-        if err := buildIPPortList(portsPath, hpList); err != nil { return err }
+    meta := newRunMeta(ctx, cfg, t, force, resume)
+    if due != nil {
+        meta.Mode = "watch"
+        for _, name := range StageNames(cfg, t) {
+            if due[name] { meta.Due = append(meta.Due, name) }
+        }
+    }
+    rec, err := db.StartRun(ctx, t.Domain, meta.Mode)
+    if err != nil { return "", nil, fmt.Errorf("store: %w", err) }
+    meta.RunID = rec.ID
+    sc := &scheduler{env: env, ck: ck, force: force, resume: resume, due: due, meta: meta}
+    err = sc.run(ctx)
+    // Snapshot the store even after an interruption so the run can be
+    // diffed; ctx may already be cancelled. The snapshot is recorded only
+    // once it was written.
+    sctx := context.WithoutCancel(ctx)
+    snap := filepath.Join(filepath.Dir(cfg.DatabasePath()), "snapshots", rec.ID+".sqlite")
+    if serr := snapshot(sctx, db, snap); serr != nil {
+        log.Warn().Err(serr).Str("stage", "run").Msg("snapshotting store")
+    } else {
+        meta.Snapshot = snap
+    }
+    if merr := meta.finish(filepath.Join(wdir, "run.meta.json"), env, ck, sc.ran, err, ctx.Err() != nil); merr != nil {
+        log.Warn().Err(merr).Str("stage", "run").Msg("writing run.meta.json")
+    }
+    if serr := db.FinishRun(sctx, rec.ID, meta.Status, meta.Snapshot); serr != nil {
+        log.Warn().Err(serr).Str("stage", "run").Msg("recording run")
+    } else if keep := cfg.Snapshots.KeepPerDomain(); keep > 0 && meta.Snapshot != "" {
+        removed, perr := db.PruneSnapshots(sctx, t.Domain, keep)
+        if perr != nil { log.Warn().Err(perr).Str("stage", "run").Msg("pruning snapshots") }
+        if len(removed) > 0 { log.Debug().Str("stage", "run").Int("removed", len(removed)).Int("keep", keep).Msg("pruned snapshots") }
     }
-    webPath := filepath.Join(wdir, "web.jsonl")
-    if force || !exists(webPath) {
-        log.Info().Str("stage","probe_http").Msg("running httpx")
-        if err := htool.RunBasic(ctx, cfg, hpList, webPath); err != nil { return fmt.Errorf("httpx: %w", err) }
-    } else { log.Info().Str("stage","probe_http").Msg("skipping (artifact exists)") }
+    return rec.ID, sc.done, err
+}
 
-    // TODO: optional stages (TLS SAN feedback, vhost brute, crawl, screenshots)
+func snapshot(ctx context.Context, db *store.DB, path string) error {
+    if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { return err }
+    return db.Snapshot(ctx, path)
+}
 
-    // Write run.meta.json
-    _ = writeRunMeta(filepath.Join(wdir, "run.meta.json"), cfg)
-    return nil
+// resolverFor picks the resolve_dns backend configured in dns.backend.
+func resolverFor(cfg *config.Config) (string, resolveFunc) {
+    if cfg.DNS.Backend == "native" { return "native", resolver.Run }
+    return "dnsx", dtool.Run
 }
 
 func exists(p string) bool { _, err := os.Stat(p); return err == nil }
 
-// buildIPPortList creates a list of ip:port pairs from naabu JSONL output.
-func buildIPPortList(portsJSONL, outList string) error {
-    in, err := os.Open(portsJSONL)
-    if err != nil { return err }
-    defer in.Close()
-    out, err := os.Create(outList)
-    if err != nil { return err }
-    defer out.Close()
-    sc := bufio.NewScanner(in)
-    type rec struct { IP string `json:"ip"`; Port int `json:"port"` }
-    for sc.Scan() {
-        var r rec
-        if err := json.Unmarshal(sc.Bytes(), &r); err == nil && r.IP != "" && r.Port != 0 {
-            _, _ = out.WriteString(fmt.Sprintf("%s:%d\n", r.IP, r.Port))
-        }
-    }
-    return sc.Err()
+func orDefault(s, def string) string { if s == "" { return def }; return s }
+
+// openStore opens the SQLite database from cfg.Database, defaulting to
+// <workdir>/hermetica.sqlite.
+func openStore(cfg *config.Config) (*store.DB, error) {
+    p := cfg.DatabasePath()
+    if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil { return nil, err }
+    return store.Open(p)
 }
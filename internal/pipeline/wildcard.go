@@ -0,0 +1,181 @@
+package pipeline
+
+import (
+    "bufio"
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/rs/zerolog/log"
+    "hermetica/internal/config"
+    "hermetica/internal/scope"
+    "hermetica/internal/store"
+    dtool "hermetica/internal/tool/dnsx"
+)
+
+// resolveFunc resolves a list of hostnames into dnsx-shaped JSONL.
+type resolveFunc func(ctx context.Context, cfg *config.Config, inList, outJSONL string) error
+
+// zoneVerdict is one row of wildcards.jsonl.
+type zoneVerdict struct {
+    Zone     string   `json:"zone"`
+    Wildcard bool     `json:"wildcard"`
+    Probes   int      `json:"probes"`
+    Answered int      `json:"answered"`
+    Answers  []string `json:"answers,omitempty"`
+    Filtered []string `json:"filtered,omitempty"`
+}
+
+// filterWildcards probes verify_count random labels under every parent zone
+// of the resolved hosts. A zone is a wildcard when every probe answers; hosts
+// whose answers are all part of their nearest wildcard zone's answer set are
+// dropped from outPath. Verdicts go to verdictPath and the store.
+func filterWildcards(ctx context.Context, cfg *config.Config, resolve resolveFunc, domain, wdir, rawPath, outPath, verdictPath string, db *store.DB) error {
+    domain = scope.NormalizeHost(domain)
+    lines, recs, err := readResolved(rawPath)
+    if err != nil { return err }
+
+    zones := map[string]*zoneVerdict{}
+    for _, r := range recs {
+        for _, z := range parentZones(r.Host, domain) {
+            if zones[z] == nil { zones[z] = &zoneVerdict{Zone: z} }
+        }
+    }
+    verify := cfg.DNS.VerifyCount
+    if verify <= 0 { verify = 2 }
+
+    if len(zones) > 0 {
+        base := strings.TrimSuffix(filepath.Base(verdictPath), ".jsonl")
+        probeList := filepath.Join(wdir, base+"-probe.txt")
+        probeOut := filepath.Join(wdir, base+"-probe.jsonl")
+        probeZone := map[string]string{}
+        var sb strings.Builder
+        for z, v := range zones {
+            v.Probes = verify
+            for i := 0; i < verify; i++ {
+                h := randomLabel() + "." + z
+                probeZone[h] = z
+                sb.WriteString(h + "\n")
+            }
+        }
+        if err := os.WriteFile(probeList, []byte(sb.String()), 0o644); err != nil { return err }
+        if err := resolve(ctx, cfg, probeList, probeOut); err != nil { return fmt.Errorf("probe: %w", err) }
+        _, probes, err := readResolved(probeOut)
+        if err != nil { return err }
+        sets := map[string]map[string]struct{}{}
+        for _, p := range probes {
+            z, ok := probeZone[scope.NormalizeHost(p.Host)]
+            if !ok || len(p.Answers()) == 0 { continue }
+            zones[z].Answered++
+            if sets[z] == nil { sets[z] = map[string]struct{}{} }
+            for _, a := range p.Answers() { sets[z][a] = struct{}{} }
+        }
+        for z, v := range zones {
+            v.Wildcard = v.Answered >= v.Probes
+            for a := range sets[z] { v.Answers = append(v.Answers, a) }
+            sort.Strings(v.Answers)
+        }
+    }
+
+    tmp := outPath + ".tmp"
+    out, err := os.Create(tmp)
+    if err != nil { return err }
+    defer out.Close()
+    var disc []store.Discovery
+    now := time.Now().UTC()
+    for i, r := range recs {
+        if z := wildcardMatch(r, domain, zones); z != nil {
+            z.Filtered = append(z.Filtered, r.Host)
+            disc = append(disc, store.Discovery{Source: "wildcard", Hostname: r.Host, InScope: true, Note: "filtered: answers match wildcard *." + z.Zone, SeenAt: now})
+            continue
+        }
+        if _, err := out.Write(append(lines[i], '\n')); err != nil { return err }
+    }
+
+    names := make([]string, 0, len(zones))
+    for z := range zones { names = append(names, z) }
+    sort.Strings(names)
+    var sb strings.Builder
+    var rows []store.Wildcard
+    filtered := 0
+    for _, z := range names {
+        v := zones[z]
+        sort.Strings(v.Filtered)
+        filtered += len(v.Filtered)
+        b, _ := json.Marshal(v)
+        sb.Write(append(b, '\n'))
+        rows = append(rows, store.Wildcard{Zone: z, Domain: domain, IsWildcard: v.Wildcard, Answers: v.Answers, Filtered: len(v.Filtered), CheckedAt: now})
+        if v.Wildcard { log.Info().Str("stage", "resolve_dns").Str("zone", z).Strs("answers", v.Answers).Int("filtered", len(v.Filtered)).Msg("wildcard zone") }
+    }
+    if err := os.WriteFile(verdictPath, []byte(sb.String()), 0o644); err != nil { return err }
+    if err := db.UpsertWildcards(ctx, rows); err != nil { return err }
+    if err := db.AddDiscoveries(ctx, disc); err != nil { return err }
+    log.Info().Str("stage", "resolve_dns").Int("zones", len(zones)).Int("hosts", len(recs)).Int("filtered", filtered).Msg("wildcard filter")
+
+    if err := out.Close(); err != nil { return err }
+    return os.Rename(tmp, outPath)
+}
+
+// readResolved returns the raw lines of a dnsx JSONL file alongside the
+// parsed records. Lines that do not parse are skipped.
+func readResolved(path string) ([][]byte, []dtool.Record, error) {
+    f, err := os.Open(path)
+    if err != nil { return nil, nil, err }
+    defer f.Close()
+    var lines [][]byte
+    var recs []dtool.Record
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+    for sc.Scan() {
+        var r dtool.Record
+        if err := json.Unmarshal(sc.Bytes(), &r); err != nil || r.Host == "" { continue }
+        r.Host = scope.NormalizeHost(r.Host)
+        lines = append(lines, append([]byte(nil), sc.Bytes()...))
+        recs = append(recs, r)
+    }
+    return lines, recs, sc.Err()
+}
+
+// parentZones lists the proper parents of host down to (and including) the
+// target domain, most specific first.
+func parentZones(host, domain string) []string {
+    if host == domain || !strings.HasSuffix(host, "."+domain) { return nil }
+    var out []string
+    for h := host; h != domain; {
+        _, rest, _ := strings.Cut(h, ".")
+        out = append(out, rest)
+        h = rest
+    }
+    return out
+}
+
+// wildcardMatch returns the nearest wildcard zone whose answer set covers
+// every answer of r, or nil when r should be kept.
+func wildcardMatch(r dtool.Record, domain string, zones map[string]*zoneVerdict) *zoneVerdict {
+    answers := r.Answers()
+    if len(answers) == 0 { return nil }
+    for _, z := range parentZones(r.Host, domain) {
+        v := zones[z]
+        if v == nil || !v.Wildcard { continue }
+        set := make(map[string]struct{}, len(v.Answers))
+        for _, a := range v.Answers { set[a] = struct{}{} }
+        for _, a := range answers {
+            if _, ok := set[a]; !ok { return nil }
+        }
+        return v
+    }
+    return nil
+}
+
+func randomLabel() string {
+    b := make([]byte, 8)
+    _, _ = rand.Read(b)
+    return hex.EncodeToString(b)
+}
@@ -0,0 +1,51 @@
+package pipeline
+
+import (
+    "context"
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "hermetica/internal/config"
+)
+
+// fakeResolve answers every name under wild.example.com with 203.0.113.9,
+// like a wildcard zone, and a few fixed names with their own address.
+func fakeResolve(_ context.Context, _ *config.Config, inList, outJSONL string) error {
+    fixed := map[string]string{"www.example.com": "192.0.2.1", "real.wild.example.com": "192.0.2.7"}
+    b, err := os.ReadFile(inList)
+    if err != nil { return err }
+    var out strings.Builder
+    for _, h := range strings.Fields(string(b)) {
+        ip, ok := fixed[h]
+        if !ok && strings.HasSuffix(h, ".wild.example.com") { ip, ok = "203.0.113.9", true }
+        if !ok { continue }
+        row, _ := json.Marshal(map[string]any{"host": h, "a": []string{ip}})
+        out.Write(append(row, '\n'))
+    }
+    return os.WriteFile(outJSONL, []byte(out.String()), 0o644)
+}
+
+func TestFilterWildcards(t *testing.T) {
+    dir := t.TempDir()
+    list := filepath.Join(dir, "names.txt")
+    if err := os.WriteFile(list, []byte("www.example.com\nlogin.wild.example.com\nreal.wild.example.com\nnx.example.com\n"), 0o644); err != nil { t.Fatal(err) }
+    raw, out, verdicts := filepath.Join(dir, "raw.jsonl"), filepath.Join(dir, "resolved.jsonl"), filepath.Join(dir, "wildcards.jsonl")
+    if err := fakeResolve(context.Background(), nil, list, raw); err != nil { t.Fatal(err) }
+    cfg := &config.Config{}
+    cfg.DNS.VerifyCount = 3
+    if err := filterWildcards(context.Background(), cfg, fakeResolve, "example.com", dir, raw, out, verdicts, openTestDB(t)); err != nil { t.Fatal(err) }
+
+    _, recs, err := readResolved(out)
+    if err != nil { t.Fatal(err) }
+    var kept []string
+    for _, r := range recs { kept = append(kept, r.Host) }
+    if got := strings.Join(kept, " "); got != "www.example.com real.wild.example.com" { t.Errorf("kept %s", got) }
+
+    filtered, err := wildcardFiltered(verdicts)
+    if err != nil { t.Fatal(err) }
+    if len(filtered) != 1 || !filtered["login.wild.example.com"] { t.Errorf("filtered = %v", filtered) }
+    if f, err := wildcardFiltered(filepath.Join(dir, "missing.jsonl")); err != nil || len(f) != 0 { t.Errorf("missing file = %v, %v", f, err) }
+}
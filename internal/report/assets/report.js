@@ -0,0 +1,38 @@
+(function () {
+  "use strict";
+  function cellValue(row, i) {
+    var c = row.cells[i];
+    return c ? (c.getAttribute("data-sort") || c.textContent.trim()) : "";
+  }
+  function compare(a, b) {
+    var na = parseFloat(a), nb = parseFloat(b);
+    if (!isNaN(na) && !isNaN(nb) && String(na) === a && String(nb) === b) return na - nb;
+    return a.localeCompare(b, undefined, { numeric: true });
+  }
+  document.querySelectorAll("table.sortable").forEach(function (table) {
+    table.querySelectorAll("th").forEach(function (th, i) {
+      th.addEventListener("click", function () {
+        var asc = !th.classList.contains("asc");
+        table.querySelectorAll("th").forEach(function (h) { h.classList.remove("asc", "desc"); });
+        th.classList.add(asc ? "asc" : "desc");
+        var body = table.tBodies[0];
+        var rows = Array.prototype.slice.call(body.rows);
+        rows.sort(function (a, b) {
+          var r = compare(cellValue(a, i), cellValue(b, i));
+          return asc ? r : -r;
+        });
+        rows.forEach(function (r) { body.appendChild(r); });
+      });
+    });
+  });
+  document.querySelectorAll("input.filter").forEach(function (input) {
+    var scope = document.getElementById(input.getAttribute("data-target"));
+    if (!scope) return;
+    input.addEventListener("input", function () {
+      var q = input.value.toLowerCase();
+      scope.querySelectorAll("[data-row]").forEach(function (el) {
+        el.style.display = el.textContent.toLowerCase().indexOf(q) === -1 ? "none" : "";
+      });
+    });
+  });
+})();
@@ -0,0 +1,267 @@
+package report
+
+import (
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "hermetica/internal/store"
+)
+
+// DiffFormats lists the supported diff output formats.
+var DiffFormats = []string{"text", "json", "csv"}
+
+// State is what one run saw of a domain: the assets, services and web
+// targets current in a store snapshot.
+type State struct {
+    Label    string
+    Assets   []store.Asset
+    Services []store.Service
+    Web      []store.WebTarget
+}
+
+// LoadState reads the rows of domain (all domains when empty) from db. The
+// store is cumulative, so rows not seen by the latest run that wrote a table
+// are left out: per table the cutoff is the start of the run whose window
+// holds the newest last_seen. Without recorded runs every row counts.
+func LoadState(ctx context.Context, db *store.DB, domain, label string) (*State, error) {
+    f := store.Filter{Domain: domain}
+    runs, err := db.ListRuns(ctx, domain)
+    if err != nil { return nil, err }
+    st := &State{Label: label}
+    if st.Assets, err = db.ListAssets(ctx, f); err != nil { return nil, err }
+    if st.Services, err = db.ListServices(ctx, f); err != nil { return nil, err }
+    if st.Web, err = db.ListWebTargets(ctx, f); err != nil { return nil, err }
+    st.Assets = keepSeen(st.Assets, runs, func(a store.Asset) time.Time { return a.LastSeen })
+    st.Services = keepSeen(st.Services, runs, func(s store.Service) time.Time { return s.LastSeen })
+    st.Web = keepSeen(st.Web, runs, func(w store.WebTarget) time.Time { return w.LastSeen })
+    return st, nil
+}
+
+// keepSeen drops rows last seen before the run that last touched the table.
+func keepSeen[T any](rows []T, runs []store.Run, last func(T) time.Time) []T {
+    var newest time.Time
+    for _, r := range rows {
+        if t := last(r); t.After(newest) { newest = t }
+    }
+    var cutoff time.Time
+    for _, r := range runs {
+        if !r.StartedAt.After(newest) && (r.EndedAt.IsZero() || !r.EndedAt.Before(newest)) { cutoff = r.StartedAt }
+    }
+    if cutoff.IsZero() { return rows }
+    out := rows[:0]
+    for _, r := range rows {
+        if !last(r).Before(cutoff) { out = append(out, r) }
+    }
+    return out
+}
+
+// Diff lists what changed between two states.
+type Diff struct {
+    From        string      `json:"from"`
+    To          string      `json:"to"`
+    Domain      string      `json:"domain,omitempty"`
+    NewHosts    []string    `json:"new_subdomains"`
+    GoneHosts   []string    `json:"removed_subdomains"`
+    IPChanges   []IPChange  `json:"ip_changes"`
+    OpenPorts   []string    `json:"opened_ports"` // ip:port/proto
+    ClosedPorts []string    `json:"closed_ports"`
+    NewWeb      []string    `json:"new_webtargets"`
+    GoneWeb     []string    `json:"removed_webtargets"`
+    WebChanges  []WebChange `json:"changed_webtargets"`
+}
+
+// IPChange is an FQDN present in both states whose addresses changed.
+type IPChange struct {
+    FQDN    string   `json:"fqdn"`
+    Added   []string `json:"added"`
+    Removed []string `json:"removed"`
+}
+
+// WebChange is a web target present in both states with changed fields.
+type WebChange struct {
+    Target string        `json:"target"`
+    Fields []FieldChange `json:"fields"`
+}
+
+type FieldChange struct {
+    Field string `json:"field"`
+    Old   string `json:"old"`
+    New   string `json:"new"`
+}
+
+// Compare diffs state a (older) against b (newer).
+func Compare(a, b *State, domain string) *Diff {
+    d := &Diff{From: a.Label, To: b.Label, Domain: domain, IPChanges: []IPChange{}, WebChanges: []WebChange{}}
+    ha, hb := hostIPs(a.Assets), hostIPs(b.Assets)
+    d.NewHosts, d.GoneHosts = setDiff(keys(hb), keys(ha)), setDiff(keys(ha), keys(hb))
+    for _, h := range keys(hb) {
+        old, ok := ha[h]
+        if !ok { continue }
+        add, rm := setDiff(hb[h], old), setDiff(old, hb[h])
+        if len(add) > 0 || len(rm) > 0 { d.IPChanges = append(d.IPChanges, IPChange{FQDN: h, Added: add, Removed: rm}) }
+    }
+    pa, pb := ports(a.Services), ports(b.Services)
+    d.OpenPorts, d.ClosedPorts = setDiff(pb, pa), setDiff(pa, pb)
+    wa, wb := webByKey(a.Web), webByKey(b.Web)
+    d.NewWeb, d.GoneWeb = setDiff(keys(wb), keys(wa)), setDiff(keys(wa), keys(wb))
+    for _, k := range keys(wb) {
+        old, ok := wa[k]
+        if !ok { continue }
+        if fc := webFields(old, wb[k]); len(fc) > 0 { d.WebChanges = append(d.WebChanges, WebChange{Target: k, Fields: fc}) }
+    }
+    return d
+}
+
+// Empty reports whether nothing changed.
+func (d *Diff) Empty() bool {
+    return len(d.NewHosts)+len(d.GoneHosts)+len(d.IPChanges)+len(d.OpenPorts)+len(d.ClosedPorts)+len(d.NewWeb)+len(d.GoneWeb)+len(d.WebChanges) == 0
+}
+
+// hostIPs maps every FQDN to its sorted A/AAAA addresses. CNAME-only hosts
+// map to an empty list so they still count as subdomains.
+func hostIPs(assets []store.Asset) map[string][]string {
+    m := map[string][]string{}
+    for _, a := range assets {
+        if _, ok := m[a.FQDN]; !ok { m[a.FQDN] = []string{} }
+        if a.RRType == "A" || a.RRType == "AAAA" { m[a.FQDN] = append(m[a.FQDN], a.IP) }
+    }
+    for h := range m { sort.Strings(m[h]) }
+    return m
+}
+
+func ports(svcs []store.Service) []string {
+    out := make([]string, 0, len(svcs))
+    for _, s := range svcs { out = append(out, fmt.Sprintf("%s:%d/%s", s.IP, s.Port, s.Proto)) }
+    sort.Strings(out)
+    return out
+}
+
+// webKey names a web target by what was probed: the URL plus the SNI/Host
+// addressing when it was not a direct-IP probe.
+func webKey(w store.WebTarget) string {
+    if w.InputHost == "" { return w.URL + " [" + w.SNIMode + "]" }
+    return w.URL + " [" + w.SNIMode + " " + w.InputHost + "]"
+}
+
+func webByKey(ws []store.WebTarget) map[string]store.WebTarget {
+    m := map[string]store.WebTarget{}
+    for _, w := range ws { m[webKey(w)] = w }
+    return m
+}
+
+func webFields(a, b store.WebTarget) []FieldChange {
+    var out []FieldChange
+    add := func(field, old, new string) {
+        if old != new { out = append(out, FieldChange{Field: field, Old: old, New: new}) }
+    }
+    add("status", strconv.Itoa(a.Status), strconv.Itoa(b.Status))
+    add("title", a.Title, b.Title)
+    add("tech", joinSorted(a.Tech), joinSorted(b.Tech))
+    add("tls_issuer", a.TLSIssuer, b.TLSIssuer)
+    add("body_hash", a.BodyHash, b.BodyHash)
+    return out
+}
+
+func joinSorted(s []string) string {
+    c := append([]string(nil), s...)
+    sort.Strings(c)
+    return strings.Join(c, TechSep)
+}
+
+func keys[V any](m map[string]V) []string {
+    out := make([]string, 0, len(m))
+    for k := range m { out = append(out, k) }
+    sort.Strings(out)
+    return out
+}
+
+// setDiff returns the sorted elements of a missing from b.
+func setDiff(a, b []string) []string {
+    in := map[string]bool{}
+    for _, s := range b { in[s] = true }
+    out := []string{}
+    for _, s := range a {
+        if !in[s] { out = append(out, s) }
+    }
+    sort.Strings(out)
+    return out
+}
+
+// WriteDiff writes d to w as text, json or csv.
+func WriteDiff(d *Diff, format string, w io.Writer) error {
+    switch format {
+    case "text":
+        return diffText(d, w)
+    case "json":
+        enc := json.NewEncoder(w)
+        enc.SetIndent("", "  ")
+        return enc.Encode(d)
+    case "csv":
+        return diffCSV(d, w)
+    }
+    return fmt.Errorf("unknown format %q (want %s)", format, strings.Join(DiffFormats, ", "))
+}
+
+func diffText(d *Diff, w io.Writer) error {
+    var b strings.Builder
+    fmt.Fprintf(&b, "%s -> %s", d.From, d.To)
+    if d.Domain != "" { fmt.Fprintf(&b, " (%s)", d.Domain) }
+    b.WriteString("\n")
+    if d.Empty() {
+        b.WriteString("no changes\n")
+        _, err := io.WriteString(w, b.String())
+        return err
+    }
+    fmt.Fprintf(&b, "\nSubdomains: +%d -%d\n", len(d.NewHosts), len(d.GoneHosts))
+    for _, h := range d.NewHosts { fmt.Fprintf(&b, "  + %s\n", h) }
+    for _, h := range d.GoneHosts { fmt.Fprintf(&b, "  - %s\n", h) }
+    fmt.Fprintf(&b, "\nIP changes: %d\n", len(d.IPChanges))
+    for _, c := range d.IPChanges {
+        var parts []string
+        for _, ip := range c.Added { parts = append(parts, "+"+ip) }
+        for _, ip := range c.Removed { parts = append(parts, "-"+ip) }
+        fmt.Fprintf(&b, "  ~ %s %s\n", c.FQDN, strings.Join(parts, " "))
+    }
+    fmt.Fprintf(&b, "\nPorts: +%d -%d\n", len(d.OpenPorts), len(d.ClosedPorts))
+    for _, p := range d.OpenPorts { fmt.Fprintf(&b, "  + %s\n", p) }
+    for _, p := range d.ClosedPorts { fmt.Fprintf(&b, "  - %s\n", p) }
+    fmt.Fprintf(&b, "\nWeb targets: +%d -%d ~%d\n", len(d.NewWeb), len(d.GoneWeb), len(d.WebChanges))
+    for _, t := range d.NewWeb { fmt.Fprintf(&b, "  + %s\n", t) }
+    for _, t := range d.GoneWeb { fmt.Fprintf(&b, "  - %s\n", t) }
+    for _, c := range d.WebChanges {
+        fmt.Fprintf(&b, "  ~ %s\n", c.Target)
+        for _, f := range c.Fields { fmt.Fprintf(&b, "      %s: %q -> %q\n", f.Field, f.Old, f.New) }
+    }
+    _, err := io.WriteString(w, b.String())
+    return err
+}
+
+// diffCSV writes one row per change: kind (subdomain, ip, port, webtarget),
+// change (added, removed, changed), the key, and for changes the field with
+// its old and new value.
+func diffCSV(d *Diff, w io.Writer) error {
+    cw := csv.NewWriter(w)
+    rows := [][]string{{"kind", "change", "key", "field", "old", "new"}}
+    for _, h := range d.NewHosts { rows = append(rows, []string{"subdomain", "added", h, "", "", ""}) }
+    for _, h := range d.GoneHosts { rows = append(rows, []string{"subdomain", "removed", h, "", "", ""}) }
+    for _, c := range d.IPChanges {
+        for _, ip := range c.Added { rows = append(rows, []string{"ip", "added", c.FQDN, "ip", "", ip}) }
+        for _, ip := range c.Removed { rows = append(rows, []string{"ip", "removed", c.FQDN, "ip", ip, ""}) }
+    }
+    for _, p := range d.OpenPorts { rows = append(rows, []string{"port", "added", p, "", "", ""}) }
+    for _, p := range d.ClosedPorts { rows = append(rows, []string{"port", "removed", p, "", "", ""}) }
+    for _, t := range d.NewWeb { rows = append(rows, []string{"webtarget", "added", t, "", "", ""}) }
+    for _, t := range d.GoneWeb { rows = append(rows, []string{"webtarget", "removed", t, "", "", ""}) }
+    for _, c := range d.WebChanges {
+        for _, f := range c.Fields { rows = append(rows, []string{"webtarget", "changed", c.Target, f.Field, f.Old, f.New}) }
+    }
+    if err := cw.WriteAll(rows); err != nil { return err }
+    return cw.Error()
+}
@@ -0,0 +1,119 @@
+package report
+
+import (
+    "context"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+
+    "hermetica/internal/store"
+)
+
+func TestKeepSeen(t *testing.T) {
+    t0 := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+    at := func(min int) time.Time { return t0.Add(time.Duration(min) * time.Minute) }
+    run := func(start, end int) store.Run {
+        r := store.Run{StartedAt: at(start)}
+        if end >= 0 { r.EndedAt = at(end) }
+        return r
+    }
+    type row struct {
+        name string
+        seen int
+    }
+    rows := []row{{"old", 5}, {"both", 25}, {"late", 28}}
+    for _, tc := range []struct {
+        name string
+        runs []store.Run
+        rows []row
+        want []string
+    }{
+        {"no runs", nil, rows, []string{"old", "both", "late"}},
+        {"last run wrote the table", []store.Run{run(0, 10), run(20, 30)}, rows, []string{"both", "late"}},
+        // The last run only ran later stages, so it did not touch this
+        // table: the run before it still defines what is current.
+        {"partial-stage run", []store.Run{run(0, 10), run(20, 30), run(40, 50)}, rows, []string{"both", "late"}},
+        {"unfinished run", []store.Run{run(0, 10), run(20, -1)}, rows, []string{"both", "late"}},
+        {"unfinished earlier run", []store.Run{run(0, -1), run(20, 30)}, rows, []string{"both", "late"}},
+        {"crashed run before the writer", []store.Run{run(0, -1), run(40, 50)}, []row{{"old", 5}, {"mid", 15}}, []string{"old", "mid"}},
+        {"rows outside every run", []store.Run{run(0, 10)}, []row{{"a", 5}, {"b", 15}}, []string{"a", "b"}},
+        {"no rows", []store.Run{run(0, 10)}, nil, nil},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            in := append([]row(nil), tc.rows...)
+            var got []string
+            for _, r := range keepSeen(in, tc.runs, func(r row) time.Time { return at(r.seen) }) { got = append(got, r.name) }
+            if !reflect.DeepEqual(got, tc.want) { t.Errorf("kept %v, want %v", got, tc.want) }
+        })
+    }
+}
+
+func TestCompare(t *testing.T) {
+    a := &State{Label: "run1",
+        Assets: []store.Asset{
+            {FQDN: "www.example.com", IP: "192.0.2.1", RRType: "A"},
+            {FQDN: "www.example.com", IP: "192.0.2.2", RRType: "A"},
+            {FQDN: "old.example.com", IP: "192.0.2.9", RRType: "A"},
+            {FQDN: "cdn.example.com", IP: "edge.example.net", RRType: "CNAME"},
+        },
+        Services: []store.Service{{IP: "192.0.2.1", Port: 443, Proto: "tcp"}, {IP: "192.0.2.1", Port: 22, Proto: "tcp"}},
+        Web: []store.WebTarget{
+            {URL: "https://192.0.2.1:443", SNIMode: "sni_host", InputHost: "www.example.com", Status: 200, Title: "Welcome", Tech: []string{"Nginx", "PHP"}},
+            {URL: "https://192.0.2.1:443", SNIMode: "direct_ip", Status: 404},
+        },
+    }
+    b := &State{Label: "run2",
+        Assets: []store.Asset{
+            {FQDN: "www.example.com", IP: "192.0.2.2", RRType: "A"},
+            {FQDN: "www.example.com", IP: "192.0.2.3", RRType: "A"},
+            {FQDN: "cdn.example.com", IP: "edge.example.net", RRType: "CNAME"},
+            {FQDN: "api.example.com", IP: "2001:db8::1", RRType: "AAAA"},
+        },
+        Services: []store.Service{{IP: "192.0.2.1", Port: 443, Proto: "tcp"}, {IP: "192.0.2.3", Port: 8443, Proto: "tcp"}},
+        Web: []store.WebTarget{
+            {URL: "https://192.0.2.1:443", SNIMode: "sni_host", InputHost: "www.example.com", Status: 302, Title: "Welcome", Tech: []string{"PHP", "Nginx"}, BodyHash: "abc"},
+            {URL: "https://192.0.2.3:8443", SNIMode: "sni_host", InputHost: "api.example.com", Status: 200},
+        },
+    }
+    want := &Diff{From: "run1", To: "run2", Domain: "example.com",
+        NewHosts:    []string{"api.example.com"},
+        GoneHosts:   []string{"old.example.com"},
+        IPChanges:   []IPChange{{FQDN: "www.example.com", Added: []string{"192.0.2.3"}, Removed: []string{"192.0.2.1"}}},
+        OpenPorts:   []string{"192.0.2.3:8443/tcp"},
+        ClosedPorts: []string{"192.0.2.1:22/tcp"},
+        NewWeb:      []string{"https://192.0.2.3:8443 [sni_host api.example.com]"},
+        GoneWeb:     []string{"https://192.0.2.1:443 [direct_ip]"},
+        WebChanges: []WebChange{{Target: "https://192.0.2.1:443 [sni_host www.example.com]", Fields: []FieldChange{
+            {Field: "status", Old: "200", New: "302"},
+            {Field: "body_hash", Old: "", New: "abc"},
+        }}},
+    }
+    got := Compare(a, b, "example.com")
+    if !reflect.DeepEqual(got, want) { t.Errorf("diff =\n%+v\nwant\n%+v", got, want) }
+    if got.Empty() { t.Error("Empty() on a diff with changes") }
+    if same := Compare(b, b, ""); !same.Empty() { t.Errorf("a state against itself: %+v", same) }
+}
+
+func TestLoadStateRunWindow(t *testing.T) {
+    ctx := context.Background()
+    db, err := store.Open(filepath.Join(t.TempDir(), "hermetica.sqlite"))
+    if err != nil { t.Fatal(err) }
+    defer db.Close()
+    www := store.Asset{Domain: "example.com", FQDN: "www.example.com", IP: "192.0.2.1", RRType: "A"}
+    old := store.Asset{Domain: "example.com", FQDN: "old.example.com", IP: "192.0.2.9", RRType: "A"}
+    pass := func(assets ...store.Asset) {
+        r, err := db.StartRun(ctx, "example.com", "run")
+        if err != nil { t.Fatal(err) }
+        time.Sleep(5 * time.Millisecond)
+        if err := db.UpsertAssets(ctx, assets); err != nil { t.Fatal(err) }
+        time.Sleep(5 * time.Millisecond)
+        if err := db.FinishRun(ctx, r.ID, "ok", ""); err != nil { t.Fatal(err) }
+    }
+    pass(www, old)
+    pass(www)
+    pass() // a run that did not resolve anything
+    st, err := LoadState(ctx, db, "example.com", "now")
+    if err != nil { t.Fatal(err) }
+    if len(st.Assets) != 1 || st.Assets[0].FQDN != "www.example.com" { t.Errorf("assets = %+v, want only www", st.Assets) }
+}
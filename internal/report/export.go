@@ -0,0 +1,138 @@
+// Package report turns the store into exports and reports.
+package report
+
+import (
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+    "time"
+
+    "hermetica/internal/store"
+)
+
+// Tables lists the exportable tables in export order.
+var Tables = []string{"assets", "services", "webtargets", "discovery"}
+
+// Formats lists the supported export formats.
+var Formats = []string{"csv", "json", "jsonl"}
+
+// TechSep joins multi-valued fields such as tech in CSV cells. JSON formats
+// keep them as arrays.
+const TechSep = ";"
+
+// columns are the CSV headers per table, as specified in the PRD.
+var columns = map[string][]string{
+    "assets":     {"id", "domain", "subdomain", "fqdn", "ip", "rrtype", "first_seen", "last_seen"},
+    "services":   {"asset_id", "ip", "port", "proto", "is_web"},
+    "webtargets": {"service_id", "input_host", "sni_mode", "url", "status", "title", "final_url", "tls_issuer", "cdn_hint", "tech", "body_hash", "page_group", "body_path", "shot_path"},
+    "discovery":  {"source", "hostname", "in_scope", "note", "seen_at"},
+}
+
+// Export writes the rows of table matching f to w in format and returns the
+// number of rows written.
+func Export(ctx context.Context, db *store.DB, table, format string, f store.Filter, w io.Writer) (int, error) {
+    recs, cells, err := load(ctx, db, table, f)
+    if err != nil { return 0, err }
+    switch format {
+    case "csv":
+        cw := csv.NewWriter(w)
+        if err := cw.Write(columns[table]); err != nil { return 0, err }
+        for _, c := range cells {
+            if err := cw.Write(c); err != nil { return 0, err }
+        }
+        cw.Flush()
+        return len(cells), cw.Error()
+    case "json":
+        enc := json.NewEncoder(w)
+        enc.SetIndent("", "  ")
+        return len(recs), enc.Encode(recs)
+    case "jsonl":
+        enc := json.NewEncoder(w)
+        for _, r := range recs {
+            if err := enc.Encode(r); err != nil { return 0, err }
+        }
+        return len(recs), nil
+    }
+    return 0, fmt.Errorf("unknown format %q (want %s)", format, strings.Join(Formats, ", "))
+}
+
+// load returns the records of table for JSON output and their CSV cells.
+func load(ctx context.Context, db *store.DB, table string, f store.Filter) ([]any, [][]string, error) {
+    if len(f.Status) > 0 && table != "webtargets" { return nil, nil, fmt.Errorf("--status only applies to webtargets, not %s", table) }
+    var recs []any
+    var cells [][]string
+    switch table {
+    case "assets":
+        rows, err := db.ListAssets(ctx, f)
+        if err != nil { return nil, nil, err }
+        for _, a := range rows {
+            recs = append(recs, a)
+            cells = append(cells, []string{a.ID, a.Domain, a.Subdomain, a.FQDN, a.IP, a.RRType, ts(a.FirstSeen), ts(a.LastSeen)})
+        }
+    case "services":
+        rows, err := db.ListServices(ctx, f)
+        if err != nil { return nil, nil, err }
+        for _, s := range rows {
+            recs = append(recs, s)
+            cells = append(cells, []string{s.AssetID, s.IP, strconv.Itoa(s.Port), s.Proto, strconv.FormatBool(s.IsWeb)})
+        }
+    case "webtargets":
+        rows, err := db.ListWebTargets(ctx, f)
+        if err != nil { return nil, nil, err }
+        for _, w := range rows {
+            recs = append(recs, w)
+            cells = append(cells, []string{w.ServiceID, w.InputHost, w.SNIMode, w.URL, strconv.Itoa(w.Status), w.Title, w.FinalURL, w.TLSIssuer, w.CDNHint, strings.Join(w.Tech, TechSep), w.BodyHash, w.PageGroup, w.BodyPath, w.ShotPath})
+        }
+    case "discovery":
+        rows, err := db.ListDiscoveries(ctx, f)
+        if err != nil { return nil, nil, err }
+        for _, d := range rows {
+            recs = append(recs, d)
+            cells = append(cells, []string{d.Source, d.Hostname, strconv.FormatBool(d.InScope), d.Note, ts(d.SeenAt)})
+        }
+    default:
+        return nil, nil, fmt.Errorf("unknown table %q (want %s)", table, strings.Join(Tables, ", "))
+    }
+    if recs == nil { recs = []any{} }
+    return recs, cells, nil
+}
+
+func ts(t time.Time) string {
+    if t.IsZero() { return "" }
+    return t.UTC().Format(time.RFC3339)
+}
+
+// ParseStatus parses a comma-separated list of status codes and classes
+// such as "200,301,4xx".
+func ParseStatus(s string) ([]store.StatusRange, error) {
+    var out []store.StatusRange
+    for _, part := range strings.Split(s, ",") {
+        part = strings.ToLower(strings.TrimSpace(part))
+        if part == "" { continue }
+        if len(part) == 3 && strings.HasSuffix(part, "xx") && part[0] >= '1' && part[0] <= '5' {
+            base := int(part[0]-'0') * 100
+            out = append(out, store.StatusRange{Min: base, Max: base + 99})
+            continue
+        }
+        n, err := strconv.Atoi(part)
+        if err != nil || n < 100 || n > 599 { return nil, fmt.Errorf("invalid status %q (want a code like 200 or a class like 4xx)", part) }
+        out = append(out, store.StatusRange{Min: n, Max: n})
+    }
+    return out, nil
+}
+
+// ParseSince accepts an RFC 3339 time, a date (2006-01-02) or a look-back
+// duration such as 36h or 7d.
+func ParseSince(s string, now time.Time) (time.Time, error) {
+    if t, err := time.Parse(time.RFC3339, s); err == nil { return t, nil }
+    if t, err := time.Parse("2006-01-02", s); err == nil { return t, nil }
+    if strings.HasSuffix(s, "d") {
+        if n, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && n >= 0 { return now.AddDate(0, 0, -n), nil }
+    }
+    if d, err := time.ParseDuration(s); err == nil && d >= 0 { return now.Add(-d), nil }
+    return time.Time{}, fmt.Errorf("invalid --since %q (want RFC 3339, YYYY-MM-DD, or a duration like 36h or 7d)", s)
+}
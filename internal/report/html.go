@@ -0,0 +1,140 @@
+package report
+
+import (
+    "context"
+    _ "embed"
+    "encoding/base64"
+    "html/template"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+
+    "hermetica/internal/store"
+)
+
+var (
+    //go:embed assets/report.html.tmpl
+    htmlTmpl string
+    //go:embed assets/report.css
+    htmlCSS string
+    //go:embed assets/report.js
+    htmlJS string
+)
+
+// maxInlineShot caps the size of screenshots embedded in the report.
+const maxInlineShot = 4 << 20
+
+type htmlPage struct {
+    Title     string
+    Generated string
+    Source    string
+    CSS       template.CSS
+    JS        template.JS
+    Targets   []htmlTarget
+}
+
+type htmlTarget struct {
+    Domain    string
+    Hosts     map[string]struct{}
+    IPs       []htmlIP
+    Services  []store.Service
+    OpenPorts int
+    Web       []store.WebTarget
+    Groups    []htmlGroup
+}
+
+type htmlIP struct {
+    IP    string
+    Hosts []string
+    Ports []int
+}
+
+type htmlGroup struct {
+    Name string
+    Shot template.URL
+    Rows []store.WebTarget
+}
+
+// HTML renders a single self-contained report for domains from the store:
+// CSS, JS and screenshots are inlined so the file opens offline. Screenshot
+// paths are relative to each target's directory, <workdir>/<domain>.
+func HTML(ctx context.Context, db *store.DB, title, source, workdir string, domains []string, w io.Writer) error {
+    page := htmlPage{Title: title, Generated: time.Now().UTC().Format(time.RFC3339), Source: source, CSS: template.CSS(htmlCSS), JS: template.JS(htmlJS)}
+    for _, d := range domains {
+        t, err := loadTarget(ctx, db, d, filepath.Join(workdir, d))
+        if err != nil { return err }
+        page.Targets = append(page.Targets, t)
+    }
+    tmpl, err := template.New("report").Funcs(template.FuncMap{
+        "ts":          func(t time.Time) string { return ts(t) },
+        "statusClass": func(s int) int { return s / 100 },
+    }).Parse(htmlTmpl)
+    if err != nil { return err }
+    return tmpl.Execute(w, page)
+}
+
+func loadTarget(ctx context.Context, db *store.DB, domain, dir string) (htmlTarget, error) {
+    f := store.Filter{Domain: domain}
+    t := htmlTarget{Domain: domain, Hosts: map[string]struct{}{}}
+    assets, err := db.ListAssets(ctx, f)
+    if err != nil { return t, err }
+    if t.Services, err = db.ListServices(ctx, f); err != nil { return t, err }
+    if t.Web, err = db.ListWebTargets(ctx, f); err != nil { return t, err }
+    t.OpenPorts = len(t.Services)
+
+    byIP := map[string]*htmlIP{}
+    ip := func(addr string) *htmlIP {
+        if byIP[addr] == nil { byIP[addr] = &htmlIP{IP: addr} }
+        return byIP[addr]
+    }
+    for _, a := range assets {
+        t.Hosts[a.FQDN] = struct{}{}
+        if a.RRType == "A" || a.RRType == "AAAA" { e := ip(a.IP); e.Hosts = append(e.Hosts, a.FQDN) }
+    }
+    for _, s := range t.Services { e := ip(s.IP); e.Ports = append(e.Ports, s.Port) }
+    for _, e := range byIP {
+        sort.Strings(e.Hosts)
+        sort.Ints(e.Ports)
+        t.IPs = append(t.IPs, *e)
+    }
+    sort.Slice(t.IPs, func(i, j int) bool { return t.IPs[i].IP < t.IPs[j].IP })
+
+    // Web targets without a page group each form their own group.
+    groups := map[string]*htmlGroup{}
+    var order []string
+    for _, w := range t.Web {
+        key := "url:" + w.URL + "|" + w.SNIMode + "|" + w.InputHost
+        if w.PageGroup != "" { key = w.PageGroup }
+        g := groups[key]
+        if g == nil {
+            g = &htmlGroup{Name: w.PageGroup}
+            groups[key] = g
+            order = append(order, key)
+        }
+        g.Rows = append(g.Rows, w)
+        if g.Shot == "" && w.ShotPath != "" { g.Shot = inlineImage(shotFile(dir, w.ShotPath)) }
+    }
+    // Largest groups first: shared pages (parking, default vhosts) stand out.
+    sort.SliceStable(order, func(i, j int) bool { return len(groups[order[i]].Rows) > len(groups[order[j]].Rows) })
+    for _, k := range order { t.Groups = append(t.Groups, *groups[k]) }
+    return t, nil
+}
+
+// shotFile resolves a stored shot_path against the target directory.
+func shotFile(dir, p string) string {
+    if filepath.IsAbs(p) { return p }
+    return filepath.Join(dir, p)
+}
+
+// inlineImage returns path as a data URI, or "" when it cannot be read or
+// is too large to embed.
+func inlineImage(path string) template.URL {
+    st, err := os.Stat(path)
+    if err != nil || st.Size() > maxInlineShot { return "" }
+    b, err := os.ReadFile(path)
+    if err != nil { return "" }
+    return template.URL("data:" + http.DetectContentType(b) + ";base64," + base64.StdEncoding.EncodeToString(b))
+}
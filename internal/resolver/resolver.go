@@ -0,0 +1,280 @@
+// Package resolver is a built-in DNS backend for the resolve_dns stage. It
+// reads the same hostname list as dnsx and writes dnsx-shaped JSONL, so it
+// can be swapped in with dns.backend: native.
+package resolver
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/miekg/dns"
+    "github.com/rs/zerolog/log"
+    "hermetica/internal/config"
+    "hermetica/internal/executil"
+)
+
+const (
+    queryTimeout = 5 * time.Second
+    maxCNAMEHops = 10
+)
+
+// Resolver spreads queries round-robin over a set of upstreams.
+type Resolver struct {
+    upstreams []*upstream
+    next      atomic.Uint64
+    retries   int
+    ipv6      bool
+}
+
+// QueryError is a lookup that failed on every attempt. Upstream is the
+// resolver of the last attempt and Kind one of timeout, servfail, refused
+// or network.
+type QueryError struct {
+    Upstream string
+    Kind     string
+    Err      error
+}
+
+func (e *QueryError) Error() string { return e.Upstream + ": " + e.Kind + ": " + e.Err.Error() }
+
+func (e *QueryError) Unwrap() error { return e.Err }
+
+// Record mirrors the dnsx JSONL fields Hermetica consumes.
+type Record struct {
+    Host       string   `json:"host"`
+    A          []string `json:"a,omitempty"`
+    AAAA       []string `json:"aaaa,omitempty"`
+    CNAME      []string `json:"cname,omitempty"`
+    StatusCode string   `json:"status_code"`
+    Resolver   []string `json:"resolver,omitempty"`
+}
+
+// New builds a resolver from cfg.Tools.ResolversFile, falling back to the
+// system resolvers in /etc/resolv.conf when no file is configured.
+func New(cfg *config.Config) (*Resolver, error) {
+    var lines []string
+    if cfg.Tools.ResolversFile != "" {
+        b, err := os.ReadFile(cfg.Tools.ResolversFile)
+        if err != nil { return nil, err }
+        for _, l := range strings.Split(string(b), "\n") {
+            l = strings.TrimSpace(l)
+            if l == "" || strings.HasPrefix(l, "#") { continue }
+            lines = append(lines, l)
+        }
+    } else if cc, err := dns.ClientConfigFromFile("/etc/resolv.conf"); err == nil {
+        for _, s := range cc.Servers { lines = append(lines, withPort(s, cc.Port)) }
+    }
+    if len(lines) == 0 { return nil, errors.New("no resolvers configured") }
+    r := &Resolver{retries: cfg.Limits.Retries, ipv6: cfg.DNS.IPv6Enabled}
+    for _, l := range lines {
+        u, err := parseUpstream(l, cfg.DNS.ResolverQPS)
+        if err != nil { return nil, err }
+        r.upstreams = append(r.upstreams, u)
+    }
+    return r, nil
+}
+
+// Run resolves every hostname in inList and writes resolved hosts to
+// outJSONL, matching the contract of tool/dnsx.Run. Failed lookups are
+// logged and counted per upstream and kind; when every lookup fails the
+// upstreams are unusable and Run returns an error.
+func Run(ctx context.Context, cfg *config.Config, inList string, outJSONL string) error {
+    r, err := New(cfg)
+    if err != nil { return err }
+    in, err := os.Open(inList)
+    if err != nil { return err }
+    defer in.Close()
+    if err := os.MkdirAll(filepath.Dir(outJSONL), 0o755); err != nil { return err }
+    f, err := os.Create(outJSONL + ".tmp")
+    if err != nil { return err }
+    defer f.Close()
+
+    workers := cfg.Limits.Concurrency
+    if workers <= 0 { workers = 50 }
+    hosts := make(chan string)
+    results := make(chan Record)
+    fails := &failures{by: map[[2]string]int{}}
+    var total atomic.Int64
+    var wg sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for h := range hosts {
+                total.Add(1)
+                rec, err := r.Resolve(ctx, h)
+                if err != nil {
+                    if ctx.Err() == nil { fails.add(ctx, h, err) }
+                    continue
+                }
+                if len(rec.A) == 0 && len(rec.AAAA) == 0 && len(rec.CNAME) == 0 { continue }
+                results <- rec
+            }
+        }()
+    }
+    go func() { wg.Wait(); close(results) }()
+
+    var werr error
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        enc := json.NewEncoder(f)
+        for rec := range results {
+            if werr == nil { werr = enc.Encode(rec) }
+        }
+    }()
+
+    sc := bufio.NewScanner(in)
+    feed:
+    for sc.Scan() {
+        h := strings.TrimSpace(sc.Text())
+        if h == "" { continue }
+        select {
+        case hosts <- h:
+        case <-ctx.Done():
+            break feed
+        }
+    }
+    close(hosts)
+    <-done
+    if err := ctx.Err(); err != nil { return err }
+    if err := sc.Err(); err != nil { return err }
+    if werr != nil { return werr }
+    if n := int(total.Load()); fails.report(ctx, n) { return fmt.Errorf("all %d lookups failed; last: %w", n, fails.last) }
+    f.Close()
+    return os.Rename(outJSONL+".tmp", outJSONL)
+}
+
+// failures counts failed lookups per upstream and kind.
+type failures struct {
+    mu   sync.Mutex
+    n    int
+    by   map[[2]string]int
+    last error
+}
+
+func (f *failures) add(ctx context.Context, host string, err error) {
+    up, kind := "", "error"
+    var qe *QueryError
+    if errors.As(err, &qe) { up, kind = qe.Upstream, qe.Kind }
+    log.Debug().Str("stage", executil.StageOf(ctx)).Str("host", host).Str("upstream", up).Str("kind", kind).Err(err).Msg("lookup failed")
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.n++
+    f.by[[2]string{up, kind}]++
+    f.last = err
+}
+
+// report logs the failure counts and reports whether all total lookups
+// failed.
+func (f *failures) report(ctx context.Context, total int) bool {
+    for k, n := range f.by {
+        log.Warn().Str("stage", executil.StageOf(ctx)).Str("upstream", k[0]).Str("kind", k[1]).Int("failed", n).Int("lookups", total).Msg("lookups failed")
+    }
+    return total > 0 && f.n == total
+}
+
+// Resolve queries A (and AAAA when enabled) for host, following the CNAME
+// chain to its end.
+func (r *Resolver) Resolve(ctx context.Context, host string) (Record, error) {
+    rec := Record{Host: strings.TrimSuffix(host, ".")}
+    qtypes := []uint16{dns.TypeA}
+    if r.ipv6 { qtypes = append(qtypes, dns.TypeAAAA) }
+    for _, qt := range qtypes {
+        chain, vals, rcode, used, err := r.lookup(ctx, rec.Host, qt)
+        if err != nil { return rec, err }
+        if rec.StatusCode != "NOERROR" { rec.StatusCode = rcode }
+        if len(rec.CNAME) < len(chain) { rec.CNAME = chain }
+        if used != "" && !contains(rec.Resolver, used) { rec.Resolver = append(rec.Resolver, used) }
+        if qt == dns.TypeA { rec.A = vals } else { rec.AAAA = vals }
+    }
+    return rec, nil
+}
+
+// lookup resolves name/qtype, re-querying the last CNAME target when an
+// upstream returns a partial chain.
+func (r *Resolver) lookup(ctx context.Context, name string, qtype uint16) (chain, vals []string, rcode, used string, err error) {
+    cur := dns.Fqdn(name)
+    seen := map[string]bool{cur: true}
+    for hop := 0; hop < maxCNAMEHops; hop++ {
+        resp, via, qerr := r.query(ctx, cur, qtype)
+        if qerr != nil { return chain, vals, rcode, used, qerr }
+        used = via
+        rcode = dns.RcodeToString[resp.Rcode]
+        cnames := map[string]string{}
+        for _, rr := range resp.Answer {
+            if c, ok := rr.(*dns.CNAME); ok { cnames[strings.ToLower(c.Hdr.Name)] = strings.ToLower(c.Target) }
+        }
+        progressed := false
+        for {
+            t, ok := cnames[strings.ToLower(cur)]
+            if !ok || seen[t] { break }
+            seen[t] = true
+            chain = append(chain, strings.TrimSuffix(t, "."))
+            cur = t
+            progressed = true
+        }
+        for _, rr := range resp.Answer {
+            if !strings.EqualFold(rr.Header().Name, cur) { continue }
+            switch v := rr.(type) {
+            case *dns.A:
+                if qtype == dns.TypeA { vals = append(vals, v.A.String()) }
+            case *dns.AAAA:
+                if qtype == dns.TypeAAAA { vals = append(vals, v.AAAA.String()) }
+            }
+        }
+        if len(vals) > 0 || resp.Rcode != dns.RcodeSuccess || !progressed {
+            return chain, vals, rcode, used, nil
+        }
+        // the answer stopped at a CNAME target without records; ask for it directly
+    }
+    return chain, vals, rcode, used, nil
+}
+
+// query sends one question, moving to the next upstream on each retry.
+func (r *Resolver) query(ctx context.Context, name string, qtype uint16) (*dns.Msg, string, error) {
+    m := new(dns.Msg)
+    m.SetQuestion(name, qtype)
+    m.RecursionDesired = true
+    var lastErr error
+    for attempt := 0; attempt <= r.retries; attempt++ {
+        u := r.upstreams[(r.next.Add(1)-1)%uint64(len(r.upstreams))]
+        resp, err := u.exchange(ctx, m, queryTimeout)
+        if err == nil && resp != nil && resp.Rcode != dns.RcodeServerFailure && resp.Rcode != dns.RcodeRefused {
+            return resp, u.name, nil
+        }
+        if ctx.Err() != nil { return nil, "", ctx.Err() }
+        lastErr = queryError(u.name, resp, err)
+    }
+    return nil, "", lastErr
+}
+
+// queryError classifies a failed exchange with upstream u.
+func queryError(u string, resp *dns.Msg, err error) *QueryError {
+    qe := &QueryError{Upstream: u, Kind: "network", Err: err}
+    var ne net.Error
+    switch {
+    case err == nil && resp == nil:
+        qe.Err = errors.New("no response")
+    case err == nil:
+        qe.Kind, qe.Err = strings.ToLower(dns.RcodeToString[resp.Rcode]), errors.New(dns.RcodeToString[resp.Rcode])
+    case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &ne) && ne.Timeout():
+        qe.Kind = "timeout"
+    }
+    return qe
+}
+
+func contains(list []string, s string) bool {
+    for _, v := range list { if v == s { return true } }
+    return false
+}
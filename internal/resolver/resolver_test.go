@@ -0,0 +1,306 @@
+package resolver
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/miekg/dns"
+    "hermetica/internal/config"
+)
+
+// stub is a local DNS server answering from a fixed zone. Each name maps
+// to the records returned verbatim, whatever their owner, so a test can
+// hand out a whole CNAME chain or just its first hop.
+type stub struct {
+    zone     map[string][]string // FQDN -> RRs in zone file syntax
+    truncate map[string]bool     // answer these over UDP with TC set and no records
+    rcode    int                 // when set, every query gets this rcode
+
+    mu      sync.Mutex
+    queries []query
+}
+
+type query struct {
+    proto string
+    name  string
+    at    time.Time
+}
+
+func (s *stub) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+    q := req.Question[0]
+    proto := "udp"
+    if _, ok := w.RemoteAddr().(*net.TCPAddr); ok { proto = "tcp" }
+    s.mu.Lock()
+    s.queries = append(s.queries, query{proto: proto, name: q.Name, at: time.Now()})
+    s.mu.Unlock()
+
+    m := new(dns.Msg)
+    m.SetReply(req)
+    switch {
+    case s.rcode != 0:
+        m.Rcode = s.rcode
+    case proto == "udp" && s.truncate[q.Name]:
+        m.Truncated = true
+    default:
+        rrs, ok := s.zone[q.Name]
+        if !ok { m.Rcode = dns.RcodeNameError }
+        for _, z := range rrs {
+            rr, err := dns.NewRR(z)
+            if err != nil { panic(err) }
+            m.Answer = append(m.Answer, rr)
+        }
+    }
+    _ = w.WriteMsg(m)
+}
+
+func (s *stub) log() []query {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return append([]query(nil), s.queries...)
+}
+
+// start serves s over UDP and TCP on the same 127.0.0.1 port and returns
+// the address.
+func (s *stub) start(t *testing.T) string {
+    t.Helper()
+    for i := 0; i < 20; i++ {
+        pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+        if err != nil { t.Fatal(err) }
+        addr := pc.LocalAddr().String()
+        l, err := net.Listen("tcp", addr)
+        if err != nil { pc.Close(); continue }
+        var ready sync.WaitGroup
+        ready.Add(2)
+        us := &dns.Server{PacketConn: pc, Handler: s, NotifyStartedFunc: ready.Done}
+        ts := &dns.Server{Listener: l, Handler: s, NotifyStartedFunc: ready.Done}
+        go func() { _ = us.ActivateAndServe() }()
+        go func() { _ = ts.ActivateAndServe() }()
+        ready.Wait()
+        t.Cleanup(func() { _ = us.Shutdown(); _ = ts.Shutdown() })
+        return addr
+    }
+    t.Fatal("no free port for UDP and TCP")
+    return ""
+}
+
+// newResolver builds a Resolver through New from a resolvers file.
+func newResolver(t *testing.T, qps int, upstreams ...string) (*config.Config, *Resolver) {
+    t.Helper()
+    cfg := &config.Config{}
+    cfg.Tools.ResolversFile = filepath.Join(t.TempDir(), "resolvers.txt")
+    cfg.DNS.ResolverQPS = qps
+    if err := os.WriteFile(cfg.Tools.ResolversFile, []byte(strings.Join(upstreams, "\n")+"\n"), 0o644); err != nil { t.Fatal(err) }
+    r, err := New(cfg)
+    if err != nil { t.Fatal(err) }
+    return cfg, r
+}
+
+func TestResolveUDP(t *testing.T) {
+    s := &stub{zone: map[string][]string{"www.example.com.": {"www.example.com. 60 IN A 192.0.2.1", "www.example.com. 60 IN A 192.0.2.2"}}}
+    addr := s.start(t)
+    _, r := newResolver(t, 0, addr)
+
+    rec, err := r.Resolve(context.Background(), "www.example.com")
+    if err != nil { t.Fatal(err) }
+    if want := []string{"192.0.2.1", "192.0.2.2"}; !slices.Equal(rec.A, want) { t.Errorf("A = %v, want %v", rec.A, want) }
+    if rec.StatusCode != "NOERROR" { t.Errorf("status = %q", rec.StatusCode) }
+    if !slices.Equal(rec.Resolver, []string{addr}) { t.Errorf("resolver = %v", rec.Resolver) }
+    if q := s.log(); len(q) != 1 || q[0].proto != "udp" { t.Errorf("queries = %+v, want one over udp", q) }
+}
+
+func TestResolveTCPFallback(t *testing.T) {
+    s := &stub{
+        zone:     map[string][]string{"big.example.com.": {"big.example.com. 60 IN A 192.0.2.9"}},
+        truncate: map[string]bool{"big.example.com.": true},
+    }
+    _, r := newResolver(t, 0, s.start(t))
+
+    rec, err := r.Resolve(context.Background(), "big.example.com")
+    if err != nil { t.Fatal(err) }
+    if !slices.Equal(rec.A, []string{"192.0.2.9"}) { t.Errorf("A = %v", rec.A) }
+    var protos []string
+    for _, q := range s.log() { protos = append(protos, q.proto) }
+    if !slices.Equal(protos, []string{"udp", "tcp"}) { t.Errorf("transports = %v, want [udp tcp]", protos) }
+}
+
+func TestResolveCNAMEChain(t *testing.T) {
+    tests := []struct {
+        name    string
+        zone    map[string][]string
+        wantA   []string
+        wantCN  []string
+        queries int
+    }{
+        {
+            name: "whole chain in one answer",
+            zone: map[string][]string{"www.example.com.": {
+                "www.example.com. 60 IN CNAME a.cdn.example.net.",
+                "a.cdn.example.net. 60 IN CNAME b.cdn.example.net.",
+                "b.cdn.example.net. 60 IN A 192.0.2.7",
+            }},
+            wantA: []string{"192.0.2.7"}, wantCN: []string{"a.cdn.example.net", "b.cdn.example.net"}, queries: 1,
+        },
+        {
+            name: "one hop per answer",
+            zone: map[string][]string{
+                "www.example.com.":   {"www.example.com. 60 IN CNAME a.cdn.example.net."},
+                "a.cdn.example.net.": {"a.cdn.example.net. 60 IN CNAME b.cdn.example.net."},
+                "b.cdn.example.net.": {"b.cdn.example.net. 60 IN A 192.0.2.7"},
+            },
+            wantA: []string{"192.0.2.7"}, wantCN: []string{"a.cdn.example.net", "b.cdn.example.net"}, queries: 3,
+        },
+        {
+            name: "dangling target",
+            zone: map[string][]string{"www.example.com.": {"www.example.com. 60 IN CNAME gone.example.net."}},
+            wantCN: []string{"gone.example.net"}, queries: 2,
+        },
+        {
+            name: "loop",
+            zone: map[string][]string{
+                "www.example.com.":   {"www.example.com. 60 IN CNAME a.cdn.example.net."},
+                "a.cdn.example.net.": {"a.cdn.example.net. 60 IN CNAME www.example.com."},
+            },
+            wantCN: []string{"a.cdn.example.net"}, queries: 2,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            s := &stub{zone: tt.zone}
+            _, r := newResolver(t, 0, s.start(t))
+            rec, err := r.Resolve(context.Background(), "www.example.com")
+            if err != nil { t.Fatal(err) }
+            if !slices.Equal(rec.A, tt.wantA) { t.Errorf("A = %v, want %v", rec.A, tt.wantA) }
+            if !slices.Equal(rec.CNAME, tt.wantCN) { t.Errorf("CNAME = %v, want %v", rec.CNAME, tt.wantCN) }
+            if n := len(s.log()); n != tt.queries { t.Errorf("%d queries, want %d", n, tt.queries) }
+        })
+    }
+}
+
+func TestRoundRobin(t *testing.T) {
+    zone := map[string][]string{}
+    for _, h := range []string{"a", "b", "c", "d"} {
+        zone[h+".example.com."] = []string{h + ".example.com. 60 IN A 192.0.2.1"}
+    }
+    s1, s2 := &stub{zone: zone}, &stub{zone: zone}
+    a1, a2 := s1.start(t), s2.start(t)
+    _, r := newResolver(t, 0, a1, a2)
+
+    var used []string
+    for _, h := range []string{"a", "b", "c", "d"} {
+        rec, err := r.Resolve(context.Background(), h+".example.com")
+        if err != nil { t.Fatal(err) }
+        used = append(used, rec.Resolver...)
+    }
+    if want := []string{a1, a2, a1, a2}; !slices.Equal(used, want) { t.Errorf("upstreams = %v, want %v", used, want) }
+    if len(s1.log()) != 2 || len(s2.log()) != 2 { t.Errorf("queries = %d/%d, want 2/2", len(s1.log()), len(s2.log())) }
+}
+
+func TestRetryMovesToNextUpstream(t *testing.T) {
+    bad := &stub{rcode: dns.RcodeServerFailure}
+    good := &stub{zone: map[string][]string{"www.example.com.": {"www.example.com. 60 IN A 192.0.2.1"}}}
+    cfg, _ := newResolver(t, 0, bad.start(t), good.start(t))
+    cfg.Limits.Retries = 1
+    r, err := New(cfg)
+    if err != nil { t.Fatal(err) }
+
+    rec, err := r.Resolve(context.Background(), "www.example.com")
+    if err != nil { t.Fatal(err) }
+    if !slices.Equal(rec.A, []string{"192.0.2.1"}) { t.Errorf("A = %v", rec.A) }
+    if len(bad.log()) != 1 || len(good.log()) != 1 { t.Errorf("queries = %d/%d, want 1/1", len(bad.log()), len(good.log())) }
+}
+
+func TestPerUpstreamQPS(t *testing.T) {
+    zone := map[string][]string{}
+    var hosts []string
+    for i := 0; i < 6; i++ {
+        h := string(rune('a'+i)) + ".example.com"
+        hosts = append(hosts, h)
+        zone[h+"."] = []string{h + ". 60 IN A 192.0.2.1"}
+    }
+    const qps = 10 // 100ms between queries to the same upstream
+    resolveAll := func(r *Resolver) time.Duration {
+        start := time.Now()
+        var wg sync.WaitGroup
+        for _, h := range hosts {
+            wg.Add(1)
+            go func(h string) {
+                defer wg.Done()
+                if _, err := r.Resolve(context.Background(), h); err != nil { t.Error(err) }
+            }(h)
+        }
+        wg.Wait()
+        return time.Since(start)
+    }
+
+    one := &stub{zone: zone}
+    _, r := newResolver(t, qps, one.start(t))
+    if d := resolveAll(r); d < 450*time.Millisecond {
+        t.Errorf("6 queries to one upstream took %v, want >= 500ms at %d qps", d, qps)
+    }
+    q := one.log()
+    for i := 1; i < len(q); i++ {
+        if gap := q[i].at.Sub(q[i-1].at); gap < 80*time.Millisecond { t.Errorf("queries %d and %d %v apart", i-1, i, gap) }
+    }
+
+    s1, s2 := &stub{zone: zone}, &stub{zone: zone}
+    _, r = newResolver(t, qps, s1.start(t), s2.start(t))
+    if d := resolveAll(r); d >= 450*time.Millisecond {
+        t.Errorf("6 queries over two upstreams took %v; the limit should apply per upstream", d)
+    }
+}
+
+func TestRunFailures(t *testing.T) {
+    run := func(t *testing.T, s *stub, hosts ...string) (string, error) {
+        cfg, _ := newResolver(t, 0, s.start(t))
+        dir := t.TempDir()
+        in, out := filepath.Join(dir, "hosts.txt"), filepath.Join(dir, "resolved.jsonl")
+        if err := os.WriteFile(in, []byte(strings.Join(hosts, "\n")+"\n"), 0o644); err != nil { t.Fatal(err) }
+        return out, Run(context.Background(), cfg, in, out)
+    }
+
+    t.Run("all fail", func(t *testing.T) {
+        out, err := run(t, &stub{rcode: dns.RcodeServerFailure}, "a.example.com", "b.example.com")
+        var qe *QueryError
+        if !errors.As(err, &qe) || qe.Kind != "servfail" { t.Fatalf("err = %v, want a servfail QueryError", err) }
+        if !strings.Contains(err.Error(), "all 2 lookups failed") { t.Errorf("err = %v", err) }
+        if _, serr := os.Stat(out); serr == nil { t.Error("output written despite every lookup failing") }
+    })
+
+    t.Run("some fail", func(t *testing.T) {
+        s := &stub{zone: map[string][]string{"a.example.com.": {"a.example.com. 60 IN A 192.0.2.1"}}}
+        out, err := run(t, s, "a.example.com", "nx.example.com")
+        if err != nil { t.Fatal(err) }
+        b, err := os.ReadFile(out)
+        if err != nil { t.Fatal(err) }
+        var rec Record
+        if err := json.Unmarshal(b, &rec); err != nil || rec.Host != "a.example.com" { t.Errorf("resolved = %s", b) }
+    })
+}
+
+func TestQueryErrorKinds(t *testing.T) {
+    refused := new(dns.Msg)
+    refused.Rcode = dns.RcodeRefused
+    tests := []struct {
+        resp *dns.Msg
+        err  error
+        want string
+    }{
+        {refused, nil, "refused"},
+        {nil, context.DeadlineExceeded, "timeout"},
+        {nil, &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, "timeout"},
+        {nil, &net.OpError{Op: "dial", Err: errors.New("connection refused")}, "network"},
+        {nil, nil, "network"},
+    }
+    for _, tt := range tests {
+        if got := queryError("192.0.2.53:53", tt.resp, tt.err).Kind; got != tt.want { t.Errorf("queryError(%v, %v) kind = %q, want %q", tt.resp != nil, tt.err, got, tt.want) }
+    }
+}
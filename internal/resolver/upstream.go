@@ -0,0 +1,129 @@
+package resolver
+
+import (
+    "bytes"
+    "context"
+    "crypto/tls"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/miekg/dns"
+)
+
+// upstream is one resolver from the resolvers file. Supported forms:
+//   1.1.1.1, 1.1.1.1:53, udp://1.1.1.1, tcp://1.1.1.1:53,
+//   tls://1.1.1.1:853 (DNS-over-TLS), https://dns.google/dns-query (DNS-over-HTTPS)
+type upstream struct {
+    name  string
+    proto string // udp, tcp, tls, https
+    addr  string // host:port, or the URL for https
+
+    interval time.Duration // minimum spacing between queries; 0 = unlimited
+    mu       sync.Mutex
+    next     time.Time
+}
+
+func parseUpstream(s string, qps int) (*upstream, error) {
+    s = strings.TrimSpace(s)
+    u := &upstream{name: s, proto: "udp"}
+    if qps > 0 { u.interval = time.Second / time.Duration(qps) }
+    if scheme, rest, ok := strings.Cut(s, "://"); ok {
+        u.proto = strings.ToLower(scheme)
+        s = rest
+    }
+    switch u.proto {
+    case "https":
+        if _, err := url.Parse("https://" + s); err != nil { return nil, fmt.Errorf("resolver %q: %w", u.name, err) }
+        u.addr = "https://" + s
+        return u, nil
+    case "udp", "tcp":
+        u.addr = withPort(s, "53")
+    case "tls":
+        u.addr = withPort(s, "853")
+    default:
+        return nil, fmt.Errorf("resolver %q: unsupported scheme %q", u.name, u.proto)
+    }
+    if _, _, err := net.SplitHostPort(u.addr); err != nil { return nil, fmt.Errorf("resolver %q: %w", u.name, err) }
+    return u, nil
+}
+
+func withPort(hostport, port string) string {
+    if _, _, err := net.SplitHostPort(hostport); err == nil { return hostport }
+    return net.JoinHostPort(strings.Trim(hostport, "[]"), port)
+}
+
+// wait blocks until the upstream's rate limit allows another query.
+func (u *upstream) wait(ctx context.Context) error {
+    if u.interval <= 0 { return nil }
+    u.mu.Lock()
+    now := time.Now()
+    at := u.next
+    if at.Before(now) { at = now }
+    u.next = at.Add(u.interval)
+    u.mu.Unlock()
+    if d := time.Until(at); d > 0 {
+        t := time.NewTimer(d)
+        defer t.Stop()
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-t.C:
+        }
+    }
+    return nil
+}
+
+// exchange sends m to the upstream over its transport. Truncated UDP
+// answers are retried over TCP.
+func (u *upstream) exchange(ctx context.Context, m *dns.Msg, timeout time.Duration) (*dns.Msg, error) {
+    if err := u.wait(ctx); err != nil { return nil, err }
+    switch u.proto {
+    case "https":
+        return u.exchangeDoH(ctx, m, timeout)
+    case "tls":
+        host, _, _ := net.SplitHostPort(u.addr)
+        c := &dns.Client{Net: "tcp-tls", Timeout: timeout, TLSConfig: &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}}
+        r, _, err := c.ExchangeContext(ctx, m, u.addr)
+        return r, err
+    case "tcp":
+        c := &dns.Client{Net: "tcp", Timeout: timeout}
+        r, _, err := c.ExchangeContext(ctx, m, u.addr)
+        return r, err
+    }
+    c := &dns.Client{Net: "udp", Timeout: timeout}
+    r, _, err := c.ExchangeContext(ctx, m, u.addr)
+    if err == nil && r.Truncated {
+        c.Net = "tcp"
+        r, _, err = c.ExchangeContext(ctx, m, u.addr)
+    }
+    return r, err
+}
+
+var dohClient = &http.Client{}
+
+// exchangeDoH implements RFC 8484 POST queries.
+func (u *upstream) exchangeDoH(ctx context.Context, m *dns.Msg, timeout time.Duration) (*dns.Msg, error) {
+    packed, err := m.Pack()
+    if err != nil { return nil, err }
+    cctx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+    req, err := http.NewRequestWithContext(cctx, http.MethodPost, u.addr, bytes.NewReader(packed))
+    if err != nil { return nil, err }
+    req.Header.Set("Content-Type", "application/dns-message")
+    req.Header.Set("Accept", "application/dns-message")
+    resp, err := dohClient.Do(req)
+    if err != nil { return nil, err }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK { return nil, fmt.Errorf("doh %s: http %d", u.addr, resp.StatusCode) }
+    body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+    if err != nil { return nil, err }
+    r := new(dns.Msg)
+    if err := r.Unpack(body); err != nil { return nil, err }
+    return r, nil
+}
@@ -0,0 +1,160 @@
+// Package schedule parses the cadences used by `hermetica watch`: fixed
+// intervals such as "6h" or "7d", and standard five-field cron expressions.
+package schedule
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Schedule yields the next time something should run after last.
+type Schedule interface {
+    Next(last time.Time) time.Time
+}
+
+// Interval runs every D after the previous run.
+type Interval struct{ D time.Duration }
+
+func (i Interval) Next(last time.Time) time.Time { return last.Add(i.D) }
+
+// Cron matches minute, hour, day of month, month and day of week like
+// crontab(5): each field takes *, numbers, ranges a-b, lists and /step;
+// months and weekdays also take English abbreviations (JAN, MON), and 7
+// is Sunday like 0. When both day fields are restricted a day matching
+// either one matches. Times are local wall clock times: one skipped by a
+// DST change does not run that day, and one that occurs twice runs twice.
+type Cron struct {
+    minute, hour, dom, month, dow uint64 // bit i set when value i matches
+    domStar, dowStar              bool
+    loc                           *time.Location
+}
+
+var macros = map[string]string{
+    "@hourly":   "0 * * * *",
+    "@daily":    "0 0 * * *",
+    "@midnight": "0 0 * * *",
+    "@weekly":   "0 0 * * 0",
+    "@monthly":  "0 0 1 * *",
+}
+
+// Parse accepts a Go duration ("90m", "6h"), a day or week count ("7d",
+// "2w"), a cron expression ("0 3 * * 1") or one of @hourly, @daily,
+// @weekly and @monthly. Cron expressions use local time.
+func Parse(spec string) (Schedule, error) {
+    spec = strings.TrimSpace(spec)
+    if m, ok := macros[spec]; ok { spec = m }
+    if f := strings.Fields(spec); len(f) == 5 {
+        c, err := parseCron(f)
+        if err != nil { return nil, err }
+        if c.Next(time.Now()).IsZero() { return nil, fmt.Errorf("cron %q never matches", spec) }
+        return c, nil
+    }
+    if d, err := parseInterval(spec); err == nil {
+        if d <= 0 { return nil, fmt.Errorf("interval %q must be positive", spec) }
+        return Interval{D: d}, nil
+    }
+    return nil, fmt.Errorf("%q is neither an interval (e.g. 6h, 7d) nor a cron expression (e.g. 0 3 * * 1)", spec)
+}
+
+func parseInterval(s string) (time.Duration, error) {
+    for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+        if n, ok := strings.CutSuffix(s, suffix); ok {
+            v, err := strconv.Atoi(n)
+            if err != nil { return 0, err }
+            return time.Duration(v) * unit, nil
+        }
+    }
+    return time.ParseDuration(s)
+}
+
+func parseCron(f []string) (*Cron, error) {
+    c := &Cron{loc: time.Local}
+    fields := []struct {
+        name     string
+        dst      *uint64
+        min, max int
+        names    []string
+    }{{"minute", &c.minute, 0, 59, nil}, {"hour", &c.hour, 0, 23, nil}, {"day of month", &c.dom, 1, 31, nil}, {"month", &c.month, 1, 12, monthNames}, {"day of week", &c.dow, 0, 7, dayNames}}
+    for i, fd := range fields {
+        bits, err := parseField(f[i], fd.min, fd.max, fd.names)
+        if err != nil { return nil, fmt.Errorf("cron %s: %w", fd.name, err) }
+        *fd.dst = bits
+    }
+    if c.dow&(1<<7) != 0 { c.dow |= 1 } // 7 is Sunday too
+    c.domStar, c.dowStar = f[2] == "*", f[4] == "*"
+    return c, nil
+}
+
+// Names accepted in the month and day of week fields; the index of a name
+// plus the field minimum is its value.
+var (
+    monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+    dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+func parseField(s string, min, max int, names []string) (uint64, error) {
+    value := func(v string) (int, error) {
+        for i, n := range names {
+            if strings.EqualFold(v, n) { return min + i, nil }
+        }
+        return strconv.Atoi(v)
+    }
+    var bits uint64
+    for _, part := range strings.Split(s, ",") {
+        rng, step := part, 1
+        if r, st, ok := strings.Cut(part, "/"); ok {
+            n, err := strconv.Atoi(st)
+            if err != nil || n <= 0 { return 0, fmt.Errorf("bad step in %q", part) }
+            rng, step = r, n
+        }
+        lo, hi := min, max
+        if rng != "*" {
+            a, b, isRange := strings.Cut(rng, "-")
+            var err error
+            if lo, err = value(a); err != nil { return 0, fmt.Errorf("bad value %q", part) }
+            hi = lo
+            if isRange {
+                if hi, err = value(b); err != nil { return 0, fmt.Errorf("bad value %q", part) }
+            } else if step > 1 {
+                hi = max
+            }
+        }
+        if lo < min || hi > max || lo > hi { return 0, fmt.Errorf("%q out of range %d-%d", part, min, max) }
+        for v := lo; v <= hi; v += step { bits |= 1 << uint(v) }
+    }
+    return bits, nil
+}
+
+// Next returns the first matching minute after last.
+func (c *Cron) Next(last time.Time) time.Time {
+    t := last.In(c.loc).Truncate(time.Minute).Add(time.Minute)
+    // Five years bounds the search for expressions such as 30 Feb.
+    for end := t.AddDate(5, 0, 0); t.Before(end); {
+        prev := t
+        switch {
+        case c.month&(1<<uint(t.Month())) == 0:
+            t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.loc)
+        case !c.dayMatches(t):
+            t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.loc)
+        case c.hour&(1<<uint(t.Hour())) == 0:
+            // Step by elapsed time: time.Date may map an hour skipped by
+            // DST back to the one before it.
+            t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+        case c.minute&(1<<uint(t.Minute())) == 0:
+            t = t.Add(time.Minute)
+        default:
+            return t
+        }
+        if !t.After(prev) { t = prev.Add(time.Minute) } // midnight skipped by DST
+    }
+    return time.Time{}
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+    dom := c.dom&(1<<uint(t.Day())) != 0
+    dow := c.dow&(1<<uint(t.Weekday())) != 0
+    if c.domStar || c.dowStar { return dom && dow }
+    return dom || dow
+}
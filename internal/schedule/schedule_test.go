@@ -0,0 +1,170 @@
+package schedule
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+// cronIn parses a five-field expression evaluated in loc.
+func cronIn(t *testing.T, spec string, loc *time.Location) *Cron {
+    t.Helper()
+    c, err := parseCron(strings.Fields(spec))
+    if err != nil { t.Fatalf("parseCron(%q): %v", spec, err) }
+    c.loc = loc
+    return c
+}
+
+func at(s string, loc *time.Location) time.Time {
+    t, err := time.ParseInLocation("2006-01-02 15:04:05", s, loc)
+    if err != nil { panic(err) }
+    return t
+}
+
+func TestParseInterval(t *testing.T) {
+    for _, tc := range []struct {
+        spec string
+        want time.Duration
+    }{
+        {"90m", 90 * time.Minute},
+        {"6h", 6 * time.Hour},
+        {"1h30m", 90 * time.Minute},
+        {"7d", 7 * 24 * time.Hour},
+        {"2w", 14 * 24 * time.Hour},
+        {" 24h ", 24 * time.Hour},
+    } {
+        s, err := Parse(tc.spec)
+        if err != nil { t.Errorf("Parse(%q): %v", tc.spec, err); continue }
+        if iv, ok := s.(Interval); !ok || iv.D != tc.want { t.Errorf("Parse(%q) = %#v, want Interval{%v}", tc.spec, s, tc.want) }
+    }
+}
+
+func TestParseErrors(t *testing.T) {
+    for _, tc := range []struct{ spec, want string }{
+        {"0d", "must be positive"},
+        {"-1h", "must be positive"},
+        {"soon", "neither an interval"},
+        {"xd", "neither an interval"},
+        {"1 2 3 4", "neither an interval"},
+        {"0 0 30 2 *", "never matches"},
+        {"0 0 31 4,6,9,11 *", "never matches"},
+        {"60 * * * *", "minute"},
+        {"* 24 * * *", "hour"},
+        {"* * 0 * *", "day of month"},
+        {"* * * 13 *", "month"},
+        {"* * * * 8", "day of week"},
+        {"*/0 * * * *", "bad step"},
+        {"5-1 * * * *", "out of range"},
+        {"* * * * FRI-SUN", "out of range"},
+        {"* * * FOO *", "bad value"},
+        {"* * * * MONDAY", "bad value"},
+        {"* * MON * *", "bad value"},
+    } {
+        _, err := Parse(tc.spec)
+        if err == nil || !strings.Contains(err.Error(), tc.want) { t.Errorf("Parse(%q) = %v, want error containing %q", tc.spec, err, tc.want) }
+    }
+}
+
+func TestMacros(t *testing.T) {
+    for spec, want := range map[string]string{
+        "@hourly":  "2026-01-05 11:00:00",
+        "@daily":   "2026-01-06 00:00:00",
+        "@weekly":  "2026-01-11 00:00:00",
+        "@monthly": "2026-02-01 00:00:00",
+    } {
+        s, err := Parse(spec)
+        if err != nil { t.Fatalf("Parse(%q): %v", spec, err) }
+        c := s.(*Cron)
+        c.loc = time.UTC
+        if got := c.Next(at("2026-01-05 10:07:00", time.UTC)); !got.Equal(at(want, time.UTC)) { t.Errorf("%s: Next = %v, want %s", spec, got, want) }
+    }
+}
+
+func TestCronNext(t *testing.T) {
+    // 2026-01-05 is a Monday.
+    for _, tc := range []struct {
+        name, spec, from, want string
+    }{
+        {"every 15 minutes", "*/15 * * * *", "2026-01-05 10:07:00", "2026-01-05 10:15:00"},
+        {"strictly after last", "*/15 * * * *", "2026-01-05 10:15:00", "2026-01-05 10:30:00"},
+        {"seconds are dropped", "*/15 * * * *", "2026-01-05 10:14:59", "2026-01-05 10:15:00"},
+        {"step over a range", "10-30/10 * * * *", "2026-01-05 10:07:00", "2026-01-05 10:10:00"},
+        {"step over a range ends at b", "10-30/10 * * * *", "2026-01-05 10:31:00", "2026-01-05 11:10:00"},
+        {"step from a start value", "5/20 * * * *", "2026-01-05 10:07:00", "2026-01-05 10:25:00"},
+        {"list", "0 3,15 * * *", "2026-01-05 10:07:00", "2026-01-05 15:00:00"},
+        {"hour step", "0 */6 * * *", "2026-01-05 10:07:00", "2026-01-05 12:00:00"},
+        {"7 is Sunday", "0 0 * * 7", "2026-01-05 10:07:00", "2026-01-11 00:00:00"},
+        {"0 is Sunday", "0 0 * * 0", "2026-01-05 10:07:00", "2026-01-11 00:00:00"},
+        {"range ending in 7", "0 0 * * 6-7", "2026-01-05 10:07:00", "2026-01-10 00:00:00"},
+        {"weekday name", "0 0 * * SUN", "2026-01-05 10:07:00", "2026-01-11 00:00:00"},
+        {"weekday name range, any case", "0 9 * * mon-Fri", "2026-01-10 12:00:00", "2026-01-12 09:00:00"},
+        {"month name", "0 0 1 JAN *", "2026-01-05 10:07:00", "2027-01-01 00:00:00"},
+        {"month name list", "0 0 1 jan,jul *", "2026-01-05 10:07:00", "2026-07-01 00:00:00"},
+        {"day of month only", "0 0 13 * *", "2026-01-05 10:07:00", "2026-01-13 00:00:00"},
+        {"day of week only", "0 0 * * 5", "2026-01-05 10:07:00", "2026-01-09 00:00:00"},
+        {"dom or dow: the Friday comes first", "0 0 13 * 5", "2026-01-05 10:07:00", "2026-01-09 00:00:00"},
+        {"dom or dow: the 13th comes first", "0 0 13 * 5", "2026-01-10 00:00:00", "2026-01-13 00:00:00"},
+        {"dom or dow: both on Friday 13th", "0 0 13 * 5", "2026-02-12 00:00:00", "2026-02-13 00:00:00"},
+        {"dom with dow star is AND", "0 0 13 * *", "2026-02-12 00:00:00", "2026-02-13 00:00:00"},
+        {"dom restricted by step is still OR", "0 0 */10 * 1", "2026-01-06 00:00:00", "2026-01-11 00:00:00"},
+        {"29 Feb waits for a leap year", "0 0 29 2 *", "2026-01-01 00:00:00", "2028-02-29 00:00:00"},
+        {"31st skips short months", "0 0 31 * *", "2026-04-01 00:00:00", "2026-05-31 00:00:00"},
+        {"year rollover", "59 23 31 12 *", "2026-12-31 23:59:00", "2027-12-31 23:59:00"},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            c := cronIn(t, tc.spec, time.UTC)
+            if got := c.Next(at(tc.from, time.UTC)); !got.Equal(at(tc.want, time.UTC)) { t.Errorf("%q after %s = %v, want %s", tc.spec, tc.from, got, tc.want) }
+        })
+    }
+}
+
+func TestCronDST(t *testing.T) {
+    ny, err := time.LoadLocation("America/New_York")
+    if err != nil { t.Skip(err) }
+    // 2026-03-08 02:00 EST jumps to 03:00 EDT; 2026-11-01 02:00 EDT falls
+    // back to 01:00 EST.
+    for _, tc := range []struct {
+        name, spec, from string
+        want             []string // successive Next times, formatted with zone
+    }{
+        {"time in the gap is skipped that day", "30 2 * * *", "2026-03-08 00:00:00",
+            []string{"2026-03-09 02:30 EDT", "2026-03-10 02:30 EDT"}},
+        {"hourly steps over the gap", "0 * * * *", "2026-03-08 00:30:00",
+            []string{"2026-03-08 01:00 EST", "2026-03-08 03:00 EDT", "2026-03-08 04:00 EDT"}},
+        {"repeated hour runs twice", "30 1 * * *", "2026-11-01 00:00:00",
+            []string{"2026-11-01 01:30 EDT", "2026-11-01 01:30 EST", "2026-11-02 01:30 EST"}},
+        {"hourly through the repeated hour", "0 * * * *", "2026-11-01 00:30:00",
+            []string{"2026-11-01 01:00 EDT", "2026-11-01 01:00 EST", "2026-11-01 02:00 EST"}},
+        {"daily across the change", "0 12 * * *", "2026-03-07 13:00:00",
+            []string{"2026-03-08 12:00 EDT", "2026-03-09 12:00 EDT"}},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            c := cronIn(t, tc.spec, ny)
+            last := at(tc.from, ny)
+            for _, want := range tc.want {
+                next := c.Next(last)
+                if got := next.Format("2006-01-02 15:04 MST"); got != want { t.Fatalf("%q after %v = %s, want %s", tc.spec, last, got, want) }
+                if !next.After(last) { t.Fatalf("Next(%v) = %v is not after it", last, next) }
+                last = next
+            }
+        })
+    }
+}
+
+func TestCronMidnightGap(t *testing.T) {
+    // Havana moves its clocks from 00:00 to 01:00, so midnight does not
+    // exist on 2026-03-08; Next must still move forward.
+    hv, err := time.LoadLocation("America/Havana")
+    if err != nil { t.Skip(err) }
+    c := cronIn(t, "0 0 * * *", hv)
+    got := c.Next(at("2026-03-07 12:00:00", hv))
+    if want := "2026-03-09 00:00"; got.Format("2006-01-02 15:04") != want { t.Errorf("Next = %v, want %s", got, want) }
+    c = cronIn(t, "30 * * * *", hv)
+    got = c.Next(at("2026-03-07 23:45:00", hv))
+    if want := "2026-03-08 01:30"; got.Format("2006-01-02 15:04") != want { t.Errorf("Next = %v, want %s", got, want) }
+}
+
+func TestIntervalNext(t *testing.T) {
+    last := at("2026-01-05 10:07:00", time.UTC)
+    if got := (Interval{D: 6 * time.Hour}).Next(last); !got.Equal(last.Add(6 * time.Hour)) { t.Errorf("Next = %v", got) }
+}
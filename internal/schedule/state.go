@@ -0,0 +1,39 @@
+package schedule
+
+import (
+    "time"
+
+    "hermetica/internal/store"
+)
+
+// RetryDelay is the wait before the first retry of a failed stage; it
+// doubles with every further failure up to MaxRetryDelay.
+const (
+    RetryDelay    = 5 * time.Minute
+    MaxRetryDelay = 6 * time.Hour
+)
+
+// Due returns when a stage with cadence s and watch state st is due: at
+// once when it never ran (ok is false), at its retry time after a failure,
+// and otherwise one cadence after it last completed. The cadence is
+// applied to LastRun rather than read from NextRun so that a changed
+// config takes effect on restart.
+func Due(s Schedule, st store.StageSchedule, ok bool) time.Time {
+    switch {
+    case !ok:
+        return time.Time{}
+    case st.LastStatus == "failed":
+        return st.NextRun
+    }
+    return s.Next(st.LastRun)
+}
+
+// Retry returns when a stage that failed n times in a row is tried again,
+// never later than its regular cadence s would run it.
+func Retry(now time.Time, n int, s Schedule) time.Time {
+    d := RetryDelay
+    for i := 1; i < n && d < MaxRetryDelay; i++ { d *= 2 }
+    at := now.Add(min(d, MaxRetryDelay))
+    if regular := s.Next(now); regular.Before(at) { return regular }
+    return at
+}
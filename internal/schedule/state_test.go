@@ -0,0 +1,68 @@
+package schedule
+
+import (
+    "testing"
+    "time"
+
+    "hermetica/internal/store"
+)
+
+func TestDue(t *testing.T) {
+    now := at("2026-03-02 10:00:00", time.UTC)
+    daily := Interval{24 * time.Hour}
+    for _, tc := range []struct {
+        name string
+        st   store.StageSchedule
+        ok   bool
+        want time.Time
+    }{
+        {"never ran", store.StageSchedule{}, false, time.Time{}},
+        {"done", store.StageSchedule{LastRun: now, NextRun: now.Add(time.Hour), LastStatus: "done"}, true, now.Add(24 * time.Hour)},
+        // NextRun was written under an older cadence; LastRun wins.
+        {"cadence changed", store.StageSchedule{LastRun: now, NextRun: now.Add(7 * 24 * time.Hour), LastStatus: "done"}, true, now.Add(24 * time.Hour)},
+        {"failed", store.StageSchedule{LastRun: now.Add(-48 * time.Hour), NextRun: now.Add(10 * time.Minute), LastStatus: "failed", Failures: 2}, true, now.Add(10 * time.Minute)},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            if got := Due(daily, tc.st, tc.ok); !got.Equal(tc.want) { t.Errorf("Due = %v, want %v", got, tc.want) }
+        })
+    }
+}
+
+func TestRetry(t *testing.T) {
+    now := at("2026-03-02 10:17:00", time.UTC)
+    daily, weekly := Interval{24 * time.Hour}, Interval{7 * 24 * time.Hour}
+    for _, tc := range []struct {
+        name string
+        n    int
+        s    Schedule
+        want time.Duration
+    }{
+        {"first failure", 1, daily, 5 * time.Minute},
+        {"second failure", 2, daily, 10 * time.Minute},
+        {"fifth failure", 5, daily, 80 * time.Minute},
+        {"capped", 8, weekly, 6 * time.Hour},
+        {"many failures", 1000, weekly, 6 * time.Hour},
+        {"short interval wins", 3, Interval{15 * time.Minute}, 15 * time.Minute},
+        {"cron slot wins", 4, cronIn(t, "30 * * * *", time.UTC), 13 * time.Minute},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            if got := Retry(now, tc.n, tc.s); !got.Equal(now.Add(tc.want)) { t.Errorf("Retry(%d) = %v, want now+%v", tc.n, got, tc.want) }
+        })
+    }
+}
+
+func TestRetryNeverAfterCadence(t *testing.T) {
+    now := at("2026-03-02 23:59:00", time.UTC)
+    for _, s := range []Schedule{
+        Interval{time.Minute}, Interval{time.Hour}, Interval{24 * time.Hour},
+        cronIn(t, "*/10 * * * *", time.UTC), cronIn(t, "0 3 * * *", time.UTC), cronIn(t, "0 0 1 * *", time.UTC),
+    } {
+        prev := now
+        for n := 1; n <= 12; n++ {
+            got := Retry(now, n, s)
+            if got.After(s.Next(now)) { t.Errorf("%v: Retry(%d) = %v after the regular %v", s, n, got, s.Next(now)) }
+            if !got.After(now) || got.Before(prev) { t.Errorf("%v: Retry(%d) = %v, want after now and not before %v", s, n, got, prev) }
+            prev = got
+        }
+    }
+}
@@ -0,0 +1,93 @@
+package scope
+
+import (
+    "fmt"
+    "net/netip"
+    "regexp"
+    "strings"
+
+    "hermetica/internal/config"
+)
+
+// Engine decides whether hostnames and IPs are inside the authorized scope.
+type Engine struct {
+    include []netip.Prefix
+    exclude []netip.Prefix
+    allow   *regexp.Regexp
+    deny    *regexp.Regexp
+}
+
+// New compiles the scope block of the config. An empty include list means
+// every IP is allowed unless excluded.
+func New(s config.Scope) (*Engine, error) {
+    e := &Engine{}
+    for _, c := range s.IncludeCIDRs {
+        p, err := parsePrefix(c)
+        if err != nil { return nil, fmt.Errorf("include_cidrs: %w", err) }
+        e.include = append(e.include, p)
+    }
+    for _, c := range s.ExcludeCIDRs {
+        p, err := parsePrefix(c)
+        if err != nil { return nil, fmt.Errorf("exclude_cidrs: %w", err) }
+        e.exclude = append(e.exclude, p)
+    }
+    if s.AllowedDomainRegex != "" {
+        re, err := regexp.Compile(s.AllowedDomainRegex)
+        if err != nil { return nil, fmt.Errorf("allowed_domain_regex: %w", err) }
+        e.allow = re
+    }
+    if s.DeniedDomainRegex != "" {
+        re, err := regexp.Compile(s.DeniedDomainRegex)
+        if err != nil { return nil, fmt.Errorf("denied_domain_regex: %w", err) }
+        e.deny = re
+    }
+    return e, nil
+}
+
+// parsePrefix accepts either a CIDR or a bare address (treated as /32 or /128).
+func parsePrefix(s string) (netip.Prefix, error) {
+    s = strings.TrimSpace(s)
+    if strings.Contains(s, "/") {
+        p, err := netip.ParsePrefix(s)
+        if err != nil { return netip.Prefix{}, err }
+        return p.Masked(), nil
+    }
+    a, err := netip.ParseAddr(s)
+    if err != nil { return netip.Prefix{}, err }
+    return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// NormalizeHost lowercases a hostname and strips a trailing dot.
+func NormalizeHost(h string) string {
+    return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(h)), ".")
+}
+
+// CheckHost reports whether a hostname passes the domain regexes. When it
+// does not, the returned reason is suitable for the discovery note column.
+func (e *Engine) CheckHost(h string) (bool, string) {
+    h = NormalizeHost(h)
+    if h == "" { return false, "empty hostname" }
+    if e.allow != nil && !e.allow.MatchString(h) {
+        return false, "does not match allowed_domain_regex"
+    }
+    if e.deny != nil && e.deny.MatchString(h) {
+        return false, "matches denied_domain_regex"
+    }
+    return true, ""
+}
+
+// CheckIP reports whether an IP is inside include_cidrs (when set) and
+// outside every exclude_cidrs entry.
+func (e *Engine) CheckIP(ip string) (bool, string) {
+    a, err := netip.ParseAddr(strings.TrimSpace(ip))
+    if err != nil { return false, "invalid ip " + ip }
+    a = a.Unmap()
+    for _, p := range e.exclude {
+        if p.Contains(a) { return false, fmt.Sprintf("ip %s excluded by %s", a, p) }
+    }
+    if len(e.include) == 0 { return true, "" }
+    for _, p := range e.include {
+        if p.Contains(a) { return true, "" }
+    }
+    return false, fmt.Sprintf("ip %s not in include_cidrs", a)
+}
@@ -0,0 +1,105 @@
+package scope
+
+import (
+    "strings"
+    "testing"
+
+    "hermetica/internal/config"
+)
+
+func TestNormalizeHost(t *testing.T) {
+    for _, tc := range []struct{ in, want string }{
+        {"example.com", "example.com"},
+        {"WWW.Example.COM", "www.example.com"},
+        {"example.com.", "example.com"},
+        {"  api.example.com.\n", "api.example.com"},
+        {"*.example.com", "*.example.com"},
+        {".", ""},
+        {"", ""},
+    } {
+        if got := NormalizeHost(tc.in); got != tc.want { t.Errorf("NormalizeHost(%q) = %q, want %q", tc.in, got, tc.want) }
+    }
+}
+
+func TestCheckHost(t *testing.T) {
+    for _, tc := range []struct {
+        name        string
+        allow, deny string
+        host        string
+        ok          bool
+        reason      string
+    }{
+        {"no regexes", "", "", "anything.test", true, ""},
+        {"empty host", "", "", "  ", false, "empty hostname"},
+        {"trailing dot only", "", "", ".", false, "empty hostname"},
+        {"allowed", `(^|\.)example\.com$`, "", "api.example.com", true, ""},
+        {"allowed after normalizing", `^api\.example\.com$`, "", "API.Example.com.", true, ""},
+        {"not allowed", `(^|\.)example\.com$`, "", "example.org", false, "does not match allowed_domain_regex"},
+        {"lookalike not allowed", `(^|\.)example\.com$`, "", "example.com.evil.test", false, "does not match allowed_domain_regex"},
+        {"denied", "", `^internal\.`, "internal.example.com", false, "matches denied_domain_regex"},
+        {"deny wins over allow", `example\.com$`, `^dev\.`, "dev.example.com", false, "matches denied_domain_regex"},
+        {"allowed and not denied", `example\.com$`, `^dev\.`, "www.example.com", true, ""},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            e, err := New(config.Scope{AllowedDomainRegex: tc.allow, DeniedDomainRegex: tc.deny})
+            if err != nil { t.Fatal(err) }
+            ok, reason := e.CheckHost(tc.host)
+            if ok != tc.ok || reason != tc.reason { t.Errorf("CheckHost(%q) = %v, %q; want %v, %q", tc.host, ok, reason, tc.ok, tc.reason) }
+        })
+    }
+}
+
+func TestCheckIP(t *testing.T) {
+    for _, tc := range []struct {
+        name             string
+        include, exclude []string
+        ip               string
+        ok               bool
+        reason           string // substring
+    }{
+        {"no lists", nil, nil, "203.0.113.7", true, ""},
+        {"no lists ipv6", nil, nil, "2001:db8::1", true, ""},
+        {"invalid", nil, nil, "not-an-ip", false, "invalid ip not-an-ip"},
+        {"empty", nil, nil, "", false, "invalid ip"},
+        {"included", []string{"203.0.113.0/24"}, nil, "203.0.113.7", true, ""},
+        {"not included", []string{"203.0.113.0/24"}, nil, "198.51.100.1", false, "not in include_cidrs"},
+        {"bare address include", []string{"198.51.100.1"}, nil, "198.51.100.1", true, ""},
+        {"bare address include misses neighbour", []string{"198.51.100.1"}, nil, "198.51.100.2", false, "not in include_cidrs"},
+        {"unmasked prefix", []string{"203.0.113.9/24"}, nil, "203.0.113.200", true, ""},
+        {"excluded", nil, []string{"10.0.0.0/8"}, "10.1.2.3", false, "excluded by 10.0.0.0/8"},
+        {"exclude wins over include", []string{"10.0.0.0/8"}, []string{"10.1.0.0/16"}, "10.1.2.3", false, "excluded by 10.1.0.0/16"},
+        {"included outside exclude", []string{"10.0.0.0/8"}, []string{"10.1.0.0/16"}, "10.2.0.1", true, ""},
+        {"v4-mapped v6 is unmapped", []string{"203.0.113.0/24"}, nil, "::ffff:203.0.113.7", true, ""},
+        {"v4-mapped v6 excluded", nil, []string{"10.0.0.0/8"}, "::ffff:10.0.0.1", false, "excluded"},
+        {"ipv6 include", []string{"2001:db8::/32"}, nil, "2001:db8:1::5", true, ""},
+        {"ipv6 outside include", []string{"2001:db8::/32"}, nil, "2001:db9::1", false, "not in include_cidrs"},
+        {"ipv4 list does not cover ipv6", []string{"0.0.0.0/0"}, nil, "2001:db8::1", false, "not in include_cidrs"},
+        {"whitespace", []string{"203.0.113.0/24"}, nil, " 203.0.113.7 ", true, ""},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            e, err := New(config.Scope{IncludeCIDRs: tc.include, ExcludeCIDRs: tc.exclude})
+            if err != nil { t.Fatal(err) }
+            ok, reason := e.CheckIP(tc.ip)
+            if ok != tc.ok || !strings.Contains(reason, tc.reason) || (tc.ok && reason != "") {
+                t.Errorf("CheckIP(%q) = %v, %q; want %v, %q", tc.ip, ok, reason, tc.ok, tc.reason)
+            }
+        })
+    }
+}
+
+func TestNewRejectsBadScope(t *testing.T) {
+    for _, tc := range []struct {
+        name  string
+        scope config.Scope
+        want  string
+    }{
+        {"bad include", config.Scope{IncludeCIDRs: []string{"10.0.0.0/33"}}, "include_cidrs"},
+        {"bad exclude", config.Scope{ExcludeCIDRs: []string{"nope"}}, "exclude_cidrs"},
+        {"bad allow", config.Scope{AllowedDomainRegex: "("}, "allowed_domain_regex"},
+        {"bad deny", config.Scope{DeniedDomainRegex: "[a-"}, "denied_domain_regex"},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            if _, err := New(tc.scope); err == nil || !strings.Contains(err.Error(), tc.want) { t.Errorf("New = %v, want error mentioning %s", err, tc.want) }
+        })
+    }
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "strings"
+    "time"
+)
+
+// StatusRange matches HTTP status codes from Min to Max inclusive.
+type StatusRange struct{ Min, Max int }
+
+// Filter narrows the rows returned by the List methods. Zero values match
+// everything.
+type Filter struct {
+    Domain  string        // apex domain; rows tied to it or its subdomains
+    OnlyWeb bool          // only rows tied to services that serve HTTP
+    Status  []StatusRange // web targets only
+    Since   time.Time     // last seen at or after
+}
+
+// where accumulates SQL conditions and their arguments.
+type where struct {
+    conds []string
+    args  []any
+}
+
+func (w *where) add(cond string, args ...any) {
+    w.conds = append(w.conds, cond)
+    w.args = append(w.args, args...)
+}
+
+func (w *where) String() string {
+    if len(w.conds) == 0 { return "" }
+    return " WHERE " + strings.Join(w.conds, " AND ")
+}
+
+// hostCond matches col against domain and its subdomains.
+func hostCond(col string) string { return "(" + col + " = ? OR " + col + " LIKE ?)" }
+
+func hostArgs(domain string) []any { return []any{domain, "%." + domain} }
+
+const webIPs = `SELECT ip FROM services WHERE is_web = 1`
+
+func (d *DB) ListAssets(ctx context.Context, f Filter) ([]Asset, error) {
+    var w where
+    if f.Domain != "" { w.add("domain = ?", f.Domain) }
+    if f.OnlyWeb { w.add("ip IN (" + webIPs + ")") }
+    if !f.Since.IsZero() { w.add("last_seen >= ?", f.Since.UTC()) }
+    rows, err := d.sql.QueryContext(ctx, `SELECT id, domain, subdomain, fqdn, ip, rrtype, first_seen, last_seen FROM assets`+w.String()+` ORDER BY fqdn, rrtype, ip`, w.args...)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []Asset
+    for rows.Next() {
+        var a Asset
+        var id, domain, sub, fqdn, ip, rr sql.NullString
+        var first, last sql.NullTime
+        if err := rows.Scan(&id, &domain, &sub, &fqdn, &ip, &rr, &first, &last); err != nil { return nil, err }
+        a = Asset{ID: id.String, Domain: domain.String, Subdomain: sub.String, FQDN: fqdn.String, IP: ip.String, RRType: rr.String, FirstSeen: first.Time, LastSeen: last.Time}
+        out = append(out, a)
+    }
+    return out, rows.Err()
+}
+
+func (d *DB) ListServices(ctx context.Context, f Filter) ([]Service, error) {
+    var w where
+    if f.Domain != "" { w.add("ip IN (SELECT ip FROM assets WHERE domain = ?)", f.Domain) }
+    if f.OnlyWeb { w.add("is_web = 1") }
+    if !f.Since.IsZero() { w.add("last_seen >= ?", f.Since.UTC()) }
+    rows, err := d.sql.QueryContext(ctx, `SELECT id, asset_id, ip, port, proto, is_web, first_seen, last_seen FROM services`+w.String()+` ORDER BY ip, port, proto`, w.args...)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []Service
+    for rows.Next() {
+        var id, asset, ip, proto sql.NullString
+        var port sql.NullInt64
+        var web sql.NullBool
+        var first, last sql.NullTime
+        if err := rows.Scan(&id, &asset, &ip, &port, &proto, &web, &first, &last); err != nil { return nil, err }
+        out = append(out, Service{ID: id.String, AssetID: asset.String, IP: ip.String, Port: int(port.Int64), Proto: proto.String, IsWeb: web.Bool, FirstSeen: first.Time, LastSeen: last.Time})
+    }
+    return out, rows.Err()
+}
+
+func (d *DB) ListWebTargets(ctx context.Context, f Filter) ([]WebTarget, error) {
+    var w where
+    if f.Domain != "" {
+        w.add("(service_id IN (SELECT s.id FROM services s JOIN assets a ON a.ip = s.ip WHERE a.domain = ?) OR "+hostCond("input_host")+")", append([]any{f.Domain}, hostArgs(f.Domain)...)...)
+    }
+    if len(f.Status) > 0 {
+        var or []string
+        var args []any
+        for _, r := range f.Status {
+            or = append(or, "status BETWEEN ? AND ?")
+            args = append(args, r.Min, r.Max)
+        }
+        w.add("("+strings.Join(or, " OR ")+")", args...)
+    }
+    if !f.Since.IsZero() { w.add("last_seen >= ?", f.Since.UTC()) }
+    rows, err := d.sql.QueryContext(ctx, `SELECT id, service_id, input_host, sni_mode, url, status, title, final_url, tls_issuer, cdn_hint, tech, body_hash, page_group, body_path, shot_path, first_seen, last_seen FROM webtargets`+w.String()+` ORDER BY url, sni_mode, input_host`, w.args...)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []WebTarget
+    for rows.Next() {
+        var s [14]sql.NullString
+        var status sql.NullInt64
+        var first, last sql.NullTime
+        if err := rows.Scan(&s[0], &s[1], &s[2], &s[3], &s[4], &status, &s[5], &s[6], &s[7], &s[8], &s[9], &s[10], &s[11], &s[12], &s[13], &first, &last); err != nil { return nil, err }
+        wt := WebTarget{ID: s[0].String, ServiceID: s[1].String, InputHost: s[2].String, SNIMode: s[3].String, URL: s[4].String, Status: int(status.Int64), Title: s[5].String, FinalURL: s[6].String, TLSIssuer: s[7].String, CDNHint: s[8].String, BodyHash: s[10].String, PageGroup: s[11].String, BodyPath: s[12].String, ShotPath: s[13].String, FirstSeen: first.Time, LastSeen: last.Time}
+        if s[9].String != "" { _ = json.Unmarshal([]byte(s[9].String), &wt.Tech) }
+        if wt.Tech == nil { wt.Tech = []string{} }
+        out = append(out, wt)
+    }
+    return out, rows.Err()
+}
+
+func (d *DB) ListDiscoveries(ctx context.Context, f Filter) ([]Discovery, error) {
+    var w where
+    if f.Domain != "" { w.add(hostCond("hostname"), hostArgs(f.Domain)...) }
+    if f.OnlyWeb { w.add("hostname IN (SELECT fqdn FROM assets WHERE ip IN (" + webIPs + "))") }
+    if !f.Since.IsZero() { w.add("seen_at >= ?", f.Since.UTC()) }
+    rows, err := d.sql.QueryContext(ctx, `SELECT source, hostname, in_scope, note, seen_at FROM discovery`+w.String()+` ORDER BY seen_at, hostname, source`, w.args...)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []Discovery
+    for rows.Next() {
+        var src, host, note sql.NullString
+        var in sql.NullBool
+        var seen sql.NullTime
+        if err := rows.Scan(&src, &host, &in, &note, &seen); err != nil { return nil, err }
+        out = append(out, Discovery{Source: src.String, Hostname: host.String, InScope: in.Bool, Note: note.String, SeenAt: seen.Time})
+    }
+    return out, rows.Err()
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "os"
+    "time"
+)
+
+// Run is one pipeline run of a target. Snapshot is the copy of the database
+// taken when the run ended, if any.
+type Run struct {
+    ID        string    `json:"id"`
+    Domain    string    `json:"domain"`
+    Mode      string    `json:"mode"`
+    Status    string    `json:"status"`
+    StartedAt time.Time `json:"started_at"`
+    EndedAt   time.Time `json:"ended_at"`
+    Snapshot  string    `json:"snapshot"`
+}
+
+// ErrNoRun is returned when a run ID is unknown.
+var ErrNoRun = errors.New("no such run")
+
+// StartRun records the start of a run of domain and returns its ID.
+func (d *DB) StartRun(ctx context.Context, domain, mode string) (Run, error) {
+    r := Run{ID: NewID(), Domain: domain, Mode: mode, Status: "running", StartedAt: time.Now().UTC()}
+    _, err := d.sql.ExecContext(ctx, `INSERT INTO runs (id, domain, mode, status, started_at) VALUES (?, ?, ?, ?, ?)`, r.ID, r.Domain, r.Mode, r.Status, r.StartedAt)
+    return r, err
+}
+
+// FinishRun records how run id ended and where its snapshot is written.
+func (d *DB) FinishRun(ctx context.Context, id, status, snapshot string) error {
+    _, err := d.sql.ExecContext(ctx, `UPDATE runs SET status = ?, ended_at = ?, snapshot = ? WHERE id = ?`, status, time.Now().UTC(), snapshot, id)
+    return err
+}
+
+// GetRun returns run id, or ErrNoRun.
+func (d *DB) GetRun(ctx context.Context, id string) (Run, error) {
+    rs, err := d.listRuns(ctx, ` WHERE id = ?`, id)
+    if err != nil { return Run{}, err }
+    if len(rs) == 0 { return Run{}, ErrNoRun }
+    return rs[0], nil
+}
+
+// ListRuns returns the runs of domain (all runs when empty), oldest first.
+func (d *DB) ListRuns(ctx context.Context, domain string) ([]Run, error) {
+    if domain == "" { return d.listRuns(ctx, "") }
+    return d.listRuns(ctx, ` WHERE domain = ?`, domain)
+}
+
+func (d *DB) listRuns(ctx context.Context, where string, args ...any) ([]Run, error) {
+    rows, err := d.sql.QueryContext(ctx, `SELECT id, domain, mode, status, started_at, ended_at, snapshot FROM runs`+where+` ORDER BY started_at, id`, args...)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []Run
+    for rows.Next() {
+        var id, domain, mode, status, snap sql.NullString
+        var start, end sql.NullTime
+        if err := rows.Scan(&id, &domain, &mode, &status, &start, &end, &snap); err != nil { return nil, err }
+        out = append(out, Run{ID: id.String, Domain: domain.String, Mode: mode.String, Status: status.String, StartedAt: start.Time, EndedAt: end.Time, Snapshot: snap.String})
+    }
+    return out, rows.Err()
+}
+
+// Snapshot writes a consistent copy of the database to path, replacing
+// any file there.
+func (d *DB) Snapshot(ctx context.Context, path string) error {
+    if err := os.Remove(path); err != nil && !os.IsNotExist(err) { return err }
+    _, err := d.sql.ExecContext(ctx, `VACUUM INTO ?`, path)
+    return err
+}
+
+// PruneSnapshots deletes the snapshots of domain's runs except the newest
+// keep ones and clears them from the runs table. It returns the deleted
+// paths.
+func (d *DB) PruneSnapshots(ctx context.Context, domain string, keep int) ([]string, error) {
+    runs, err := d.ListRuns(ctx, domain)
+    if err != nil { return nil, err }
+    var old []Run
+    for i := len(runs) - 1; i >= 0; i-- {
+        if runs[i].Snapshot == "" { continue }
+        if keep > 0 { keep--; continue }
+        old = append(old, runs[i])
+    }
+    var removed []string
+    for _, r := range old {
+        if err := os.Remove(r.Snapshot); err != nil && !os.IsNotExist(err) { return removed, err }
+        if _, err := d.sql.ExecContext(ctx, `UPDATE runs SET snapshot = '' WHERE id = ?`, r.ID); err != nil { return removed, err }
+        removed = append(removed, r.Snapshot)
+    }
+    return removed, nil
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+    "context"
+    "database/sql"
+    "time"
+)
+
+// StageSchedule is the watch state of one stage of a domain: when it last
+// completed, in which run it was last tried and how that ended, and when
+// it is due next. Failures counts the attempts that failed since the last
+// completed one.
+type StageSchedule struct {
+    Domain     string    `json:"domain"`
+    Stage      string    `json:"stage"`
+    LastRun    time.Time `json:"last_run"`
+    NextRun    time.Time `json:"next_run"`
+    LastStatus string    `json:"last_status"`
+    LastRunID  string    `json:"last_run_id"`
+    Failures   int       `json:"failures"`
+}
+
+// ListSchedules returns the schedule state of domain keyed by stage.
+func (d *DB) ListSchedules(ctx context.Context, domain string) (map[string]StageSchedule, error) {
+    rows, err := d.sql.QueryContext(ctx, `SELECT domain, stage, last_run, next_run, last_status, last_run_id, failures FROM schedules WHERE domain = ?`, domain)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    out := map[string]StageSchedule{}
+    for rows.Next() {
+        var dom, stage, status, runID sql.NullString
+        var last, next sql.NullTime
+        var failures sql.NullInt64
+        if err := rows.Scan(&dom, &stage, &last, &next, &status, &runID, &failures); err != nil { return nil, err }
+        out[stage.String] = StageSchedule{Domain: dom.String, Stage: stage.String, LastRun: last.Time, NextRun: next.Time, LastStatus: status.String, LastRunID: runID.String, Failures: int(failures.Int64)}
+    }
+    return out, rows.Err()
+}
+
+// SaveSchedules upserts the given stage schedules in one transaction.
+func (d *DB) SaveSchedules(ctx context.Context, ss []StageSchedule) error {
+    tx, err := d.sql.BeginTx(ctx, nil)
+    if err != nil { return err }
+    defer tx.Rollback()
+    for _, s := range ss {
+        _, err := tx.ExecContext(ctx, `INSERT INTO schedules (domain, stage, last_run, next_run, last_status, last_run_id, failures) VALUES (?, ?, ?, ?, ?, ?, ?)
+            ON CONFLICT(domain, stage) DO UPDATE SET last_run = excluded.last_run, next_run = excluded.next_run, last_status = excluded.last_status, last_run_id = excluded.last_run_id, failures = excluded.failures`,
+            s.Domain, s.Stage, s.LastRun.UTC(), s.NextRun.UTC(), s.LastStatus, s.LastRunID, s.Failures)
+        if err != nil { return err }
+    }
+    return tx.Commit()
+}
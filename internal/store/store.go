@@ -3,6 +3,9 @@ package store
 import (
     "context"
     "database/sql"
+    "strings"
+    "time"
+
     _ "modernc.org/sqlite"
 )
 
@@ -18,6 +21,9 @@ func Open(path string) (*DB, error) {
     if _, err := db.Exec(`PRAGMA journal_mode=WAL;`); err != nil {
         return nil, err
     }
+    // Pipeline stages write concurrently; pin one connection so writers
+    // queue in Go instead of failing with SQLITE_BUSY.
+    db.SetMaxOpenConns(1)
     s := &DB{sql: db}
     if err := s.migrate(context.Background()); err != nil {
         return nil, err
@@ -73,12 +79,209 @@ func (d *DB) migrate(ctx context.Context) error {
             note TEXT,
             seen_at TIMESTAMP
         );`,
+        `CREATE TABLE IF NOT EXISTS endpoints (
+            id TEXT PRIMARY KEY,
+            webtarget_id TEXT,
+            url TEXT,
+            method TEXT,
+            status INTEGER,
+            source TEXT,
+            first_seen TIMESTAMP,
+            last_seen TIMESTAMP,
+            UNIQUE (webtarget_id, method, url)
+        );`,
+        `CREATE TABLE IF NOT EXISTS runs (
+            id TEXT PRIMARY KEY,
+            domain TEXT,
+            mode TEXT,
+            status TEXT,
+            started_at TIMESTAMP,
+            ended_at TIMESTAMP,
+            snapshot TEXT
+        );`,
+        `CREATE TABLE IF NOT EXISTS schedules (
+            domain TEXT,
+            stage TEXT,
+            last_run TIMESTAMP,
+            next_run TIMESTAMP,
+            last_status TEXT,
+            last_run_id TEXT,
+            failures INTEGER,
+            PRIMARY KEY (domain, stage)
+        );`,
+        `CREATE TABLE IF NOT EXISTS wildcards (
+            zone TEXT PRIMARY KEY,
+            domain TEXT,
+            is_wildcard BOOLEAN,
+            answers TEXT,
+            filtered INTEGER,
+            checked_at TIMESTAMP
+        );`,
     }
     for _, s := range stmts {
         if _, err := d.sql.ExecContext(ctx, s); err != nil {
             return err
         }
     }
+    // Columns added after the initial schema; existing databases get them
+    // via ALTER TABLE.
+    cols := []struct{ table, name, def string }{
+        {"services", "id", "TEXT"},
+        {"webtargets", "id", "TEXT"},
+        {"services", "first_seen", "TIMESTAMP"},
+        {"services", "last_seen", "TIMESTAMP"},
+        {"webtargets", "first_seen", "TIMESTAMP"},
+        {"webtargets", "last_seen", "TIMESTAMP"},
+        {"schedules", "failures", "INTEGER"},
+    }
+    for _, c := range cols {
+        if err := d.ensureColumn(ctx, c.table, c.name, c.def); err != nil {
+            return err
+        }
+    }
+    post := []string{
+        `CREATE UNIQUE INDEX IF NOT EXISTS idx_services_id ON services(id);`,
+        `CREATE UNIQUE INDEX IF NOT EXISTS idx_webtargets_id ON webtargets(id);`,
+        `CREATE UNIQUE INDEX IF NOT EXISTS idx_assets_key ON assets(fqdn, ip, rrtype);`,
+    }
+    for _, s := range post {
+        if _, err := d.sql.ExecContext(ctx, s); err != nil {
+            return err
+        }
+    }
     return nil
 }
 
+func (d *DB) ensureColumn(ctx context.Context, table, name, def string) error {
+    rows, err := d.sql.QueryContext(ctx, `SELECT name FROM pragma_table_info(?)`, table)
+    if err != nil { return err }
+    defer rows.Close()
+    for rows.Next() {
+        var col string
+        if err := rows.Scan(&col); err != nil { return err }
+        if col == name { return nil }
+    }
+    if err := rows.Err(); err != nil { return err }
+    _, err = d.sql.ExecContext(ctx, `ALTER TABLE `+table+` ADD COLUMN `+name+` `+def)
+    return err
+}
+
+
+type Discovery struct {
+    Source   string    `json:"source"`
+    Hostname string    `json:"hostname"`
+    InScope  bool      `json:"in_scope"`
+    Note     string    `json:"note"`
+    SeenAt   time.Time `json:"seen_at"`
+}
+
+// AddDiscoveries records candidate hostnames and the scope decision taken
+// for each of them in a single transaction.
+func (d *DB) AddDiscoveries(ctx context.Context, recs []Discovery) error {
+    if len(recs) == 0 { return nil }
+    tx, err := d.sql.BeginTx(ctx, nil)
+    if err != nil { return err }
+    defer tx.Rollback()
+    stmt, err := tx.PrepareContext(ctx, `INSERT INTO discovery (source, hostname, in_scope, note, seen_at) VALUES (?, ?, ?, ?, ?)`)
+    if err != nil { return err }
+    defer stmt.Close()
+    for _, r := range recs {
+        if r.SeenAt.IsZero() { r.SeenAt = time.Now().UTC() }
+        if _, err := stmt.ExecContext(ctx, r.Source, r.Hostname, r.InScope, r.Note, r.SeenAt); err != nil { return err }
+    }
+    return tx.Commit()
+}
+
+type Wildcard struct {
+    Zone       string
+    Domain     string
+    IsWildcard bool
+    Answers    []string
+    Filtered   int
+    CheckedAt  time.Time
+}
+
+// UpsertWildcards stores the wildcard verdict for each probed zone,
+// replacing any earlier verdict for the same zone.
+func (d *DB) UpsertWildcards(ctx context.Context, recs []Wildcard) error {
+    if len(recs) == 0 { return nil }
+    tx, err := d.sql.BeginTx(ctx, nil)
+    if err != nil { return err }
+    defer tx.Rollback()
+    stmt, err := tx.PrepareContext(ctx, `INSERT INTO wildcards (zone, domain, is_wildcard, answers, filtered, checked_at) VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT(zone) DO UPDATE SET domain=excluded.domain, is_wildcard=excluded.is_wildcard, answers=excluded.answers, filtered=excluded.filtered, checked_at=excluded.checked_at`)
+    if err != nil { return err }
+    defer stmt.Close()
+    for _, r := range recs {
+        if r.CheckedAt.IsZero() { r.CheckedAt = time.Now().UTC() }
+        if _, err := stmt.ExecContext(ctx, r.Zone, r.Domain, r.IsWildcard, strings.Join(r.Answers, ","), r.Filtered, r.CheckedAt); err != nil { return err }
+    }
+    return tx.Commit()
+}
+
+// Asset is one DNS answer for a hostname. For CNAME records IP holds the
+// canonical name the host points to. Upserts ignore ID and the timestamps.
+type Asset struct {
+    ID        string    `json:"id"`
+    Domain    string    `json:"domain"`
+    Subdomain string    `json:"subdomain"`
+    FQDN      string    `json:"fqdn"`
+    IP        string    `json:"ip"`
+    RRType    string    `json:"rrtype"`
+    FirstSeen time.Time `json:"first_seen"`
+    LastSeen  time.Time `json:"last_seen"`
+}
+
+// UpsertAssets inserts assets keyed on (fqdn, ip, rrtype), keeping
+// first_seen and bumping last_seen for assets seen before.
+func (d *DB) UpsertAssets(ctx context.Context, recs []Asset) error {
+    if len(recs) == 0 { return nil }
+    tx, err := d.sql.BeginTx(ctx, nil)
+    if err != nil { return err }
+    defer tx.Rollback()
+    stmt, err := tx.PrepareContext(ctx, `INSERT INTO assets (id, domain, subdomain, fqdn, ip, rrtype, first_seen, last_seen)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(fqdn, ip, rrtype) DO UPDATE SET domain=excluded.domain, subdomain=excluded.subdomain, last_seen=excluded.last_seen`)
+    if err != nil { return err }
+    defer stmt.Close()
+    now := time.Now().UTC()
+    for _, a := range recs {
+        if _, err := stmt.ExecContext(ctx, NewID(), a.Domain, a.Subdomain, a.FQDN, a.IP, a.RRType, now, now); err != nil { return err }
+    }
+    return tx.Commit()
+}
+
+// Service is an open port found by the port scan. Upserts only read IP,
+// Port and Proto.
+type Service struct {
+    ID        string    `json:"id"`
+    AssetID   string    `json:"asset_id"`
+    IP        string    `json:"ip"`
+    Port      int       `json:"port"`
+    Proto     string    `json:"proto"`
+    IsWeb     bool      `json:"is_web"`
+    FirstSeen time.Time `json:"first_seen"`
+    LastSeen  time.Time `json:"last_seen"`
+}
+
+// UpsertServices inserts services keyed on (ip, port, proto), keeping
+// first_seen and bumping last_seen. Each service is linked to the oldest
+// asset resolving to its IP.
+func (d *DB) UpsertServices(ctx context.Context, recs []Service) error {
+    if len(recs) == 0 { return nil }
+    tx, err := d.sql.BeginTx(ctx, nil)
+    if err != nil { return err }
+    defer tx.Rollback()
+    stmt, err := tx.PrepareContext(ctx, `INSERT INTO services (id, asset_id, ip, port, proto, is_web, first_seen, last_seen)
+        VALUES (?, (SELECT id FROM assets WHERE ip = ? ORDER BY first_seen, id LIMIT 1), ?, ?, ?, 0, ?, ?)
+        ON CONFLICT(ip, port, proto) DO UPDATE SET
+            id=COALESCE(services.id, excluded.id), asset_id=COALESCE(services.asset_id, excluded.asset_id),
+            first_seen=COALESCE(services.first_seen, excluded.first_seen), last_seen=excluded.last_seen`)
+    if err != nil { return err }
+    defer stmt.Close()
+    now := time.Now().UTC()
+    for _, s := range recs {
+        if _, err := stmt.ExecContext(ctx, NewID(), s.IP, s.IP, s.Port, s.Proto, now, now); err != nil { return err }
+    }
+    return tx.Commit()
+}
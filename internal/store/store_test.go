@@ -0,0 +1,235 @@
+package store
+
+import (
+    "context"
+    "database/sql"
+    "os"
+    "path/filepath"
+    "reflect"
+    "slices"
+    "testing"
+    "time"
+)
+
+func openTest(t *testing.T, path string) *DB {
+    t.Helper()
+    db, err := Open(path)
+    if err != nil { t.Fatal(err) }
+    t.Cleanup(func() { db.Close() })
+    return db
+}
+
+// baselineSchema is the schema of the first release, before IDs and
+// first/last_seen were added to services and webtargets.
+var baselineSchema = []string{
+    `CREATE TABLE assets (id TEXT PRIMARY KEY, domain TEXT, subdomain TEXT, fqdn TEXT, ip TEXT, rrtype TEXT, first_seen TIMESTAMP, last_seen TIMESTAMP);`,
+    `CREATE TABLE services (asset_id TEXT, ip TEXT, port INTEGER, proto TEXT, is_web BOOLEAN, PRIMARY KEY (ip, port, proto));`,
+    `CREATE TABLE webtargets (service_id TEXT, input_host TEXT, sni_mode TEXT, url TEXT, status INTEGER, title TEXT, final_url TEXT, tls_issuer TEXT, cdn_hint TEXT, tech TEXT, body_hash TEXT, page_group TEXT, body_path TEXT, shot_path TEXT, PRIMARY KEY (service_id, sni_mode, input_host, url));`,
+    `CREATE TABLE discovery (source TEXT, hostname TEXT, in_scope BOOLEAN, note TEXT, seen_at TIMESTAMP);`,
+    `INSERT INTO services (ip, port, proto, is_web) VALUES ('192.0.2.1', 443, 'tcp', 1);`,
+    `INSERT INTO webtargets (service_id, input_host, sni_mode, url, status, title) VALUES ('', 'www.example.com', 'sni_host', 'https://192.0.2.1:443', 200, 'Old');`,
+}
+
+func columns(t *testing.T, db *DB, table string) []string {
+    t.Helper()
+    rows, err := db.sql.Query(`SELECT name FROM pragma_table_info(?)`, table)
+    if err != nil { t.Fatal(err) }
+    defer rows.Close()
+    var out []string
+    for rows.Next() {
+        var c string
+        if err := rows.Scan(&c); err != nil { t.Fatal(err) }
+        out = append(out, c)
+    }
+    return out
+}
+
+func TestMigrateBaseline(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "hermetica.sqlite")
+    raw, err := sql.Open("sqlite", path)
+    if err != nil { t.Fatal(err) }
+    for _, s := range baselineSchema {
+        if _, err := raw.Exec(s); err != nil { t.Fatal(err) }
+    }
+    raw.Close()
+
+    // Opening twice must be a no-op the second time.
+    db, err := Open(path)
+    if err != nil { t.Fatal(err) }
+    db.Close()
+    db = openTest(t, path)
+    for table, want := range map[string][]string{
+        "services":   {"id", "first_seen", "last_seen"},
+        "webtargets": {"id", "first_seen", "last_seen"},
+        "schedules":  {"failures"},
+    } {
+        cols := columns(t, db, table)
+        for _, c := range want {
+            if !slices.Contains(cols, c) { t.Errorf("%s lacks %s: %v", table, c, cols) }
+        }
+    }
+    for _, table := range []string{"endpoints", "runs", "wildcards"} {
+        if len(columns(t, db, table)) == 0 { t.Errorf("table %s not created", table) }
+    }
+
+    // Legacy rows survive and get an ID on first use.
+    ctx := context.Background()
+    id, err := db.ServiceID(ctx, "192.0.2.1", 443, "tcp")
+    if err != nil || id == "" { t.Fatalf("ServiceID = %q, %v", id, err) }
+    again, err := db.ServiceID(ctx, "192.0.2.1", 443, "tcp")
+    if err != nil || again != id { t.Errorf("ServiceID changed: %q then %q", id, again) }
+    ws, err := db.ListWebTargets(ctx, Filter{})
+    if err != nil { t.Fatal(err) }
+    if len(ws) != 1 || ws[0].Title != "Old" || ws[0].ID != "" { t.Errorf("legacy web targets = %+v", ws) }
+}
+
+func TestUpsertRoundTrip(t *testing.T) {
+    ctx := context.Background()
+    db := openTest(t, filepath.Join(t.TempDir(), "hermetica.sqlite"))
+    assets := []Asset{
+        {Domain: "example.com", Subdomain: "www", FQDN: "www.example.com", IP: "192.0.2.1", RRType: "A"},
+        {Domain: "example.com", Subdomain: "api", FQDN: "api.example.com", IP: "192.0.2.2", RRType: "A"},
+    }
+    if err := db.UpsertAssets(ctx, assets); err != nil { t.Fatal(err) }
+    first, err := db.ListAssets(ctx, Filter{})
+    if err != nil { t.Fatal(err) }
+    time.Sleep(10 * time.Millisecond)
+    if err := db.UpsertAssets(ctx, assets[:1]); err != nil { t.Fatal(err) }
+    second, err := db.ListAssets(ctx, Filter{})
+    if err != nil { t.Fatal(err) }
+    if len(second) != 2 { t.Fatalf("assets = %+v, want no duplicates", second) }
+    // Sorted by fqdn: api, www.
+    if second[1].ID != first[1].ID || !second[1].FirstSeen.Equal(first[1].FirstSeen) || !second[1].LastSeen.After(first[1].LastSeen) { t.Errorf("www upsert: %+v -> %+v", first[1], second[1]) }
+    if !second[0].LastSeen.Equal(first[0].LastSeen) { t.Error("api last_seen bumped without being seen") }
+
+    if err := db.UpsertServices(ctx, []Service{{IP: "192.0.2.1", Port: 443, Proto: "tcp"}, {IP: "192.0.2.1", Port: 22, Proto: "tcp"}}); err != nil { t.Fatal(err) }
+    svcs, err := db.ListServices(ctx, Filter{})
+    if err != nil { t.Fatal(err) }
+    if len(svcs) != 2 || svcs[0].AssetID != first[1].ID || svcs[0].ID == "" { t.Fatalf("services = %+v", svcs) }
+    sid, err := db.ServiceID(ctx, "192.0.2.1", 443, "tcp")
+    if err != nil { t.Fatal(err) }
+    if sid != svcs[1].ID { t.Errorf("ServiceID = %s, want %s", sid, svcs[1].ID) }
+
+    wt := WebTarget{ServiceID: sid, InputHost: "www.example.com", SNIMode: "sni_host", URL: "https://192.0.2.1:443", Status: 200, Title: "Welcome", Tech: []string{"Nginx"}, BodyHash: "abc", BodyPath: "bodies/x.bin"}
+    if err := db.UpsertWebTargets(ctx, []WebTarget{wt}); err != nil { t.Fatal(err) }
+    id, err := db.WebTargetID(ctx, sid, "sni_host", "www.example.com", "https://192.0.2.1:443")
+    if err != nil || id == "" { t.Fatalf("WebTargetID = %q, %v", id, err) }
+    if err := db.SetShotPath(ctx, []string{id}, "shots/"+id+".png"); err != nil { t.Fatal(err) }
+    if err := db.SetPageGroups(ctx, map[string]string{id: "pg_1"}); err != nil { t.Fatal(err) }
+    // A later probe without evidence keeps what was recorded.
+    wt.ID, wt.Status, wt.BodyHash, wt.BodyPath, wt.Tech = NewID(), 302, "", "", nil
+    if err := db.UpsertWebTargets(ctx, []WebTarget{wt}); err != nil { t.Fatal(err) }
+
+    ws, err := db.ListWebTargets(ctx, Filter{})
+    if err != nil { t.Fatal(err) }
+    if len(ws) != 1 { t.Fatalf("web targets = %+v", ws) }
+    got := ws[0]
+    want := WebTarget{ID: id, ServiceID: sid, InputHost: "www.example.com", SNIMode: "sni_host", URL: "https://192.0.2.1:443", Status: 302, Title: "Welcome", Tech: []string{}, BodyHash: "abc", PageGroup: "pg_1", BodyPath: "bodies/x.bin", ShotPath: "shots/" + id + ".png"}
+    got.FirstSeen, got.LastSeen = time.Time{}, time.Time{}
+    if !reflect.DeepEqual(got, want) { t.Errorf("web target =\n%+v\nwant\n%+v", got, want) }
+    svcs, err = db.ListServices(ctx, Filter{OnlyWeb: true})
+    if err != nil { t.Fatal(err) }
+    if len(svcs) != 1 || svcs[0].Port != 443 { t.Errorf("web services = %+v", svcs) }
+}
+
+func TestFilter(t *testing.T) {
+    ctx := context.Background()
+    db := openTest(t, filepath.Join(t.TempDir(), "hermetica.sqlite"))
+    if err := db.UpsertAssets(ctx, []Asset{
+        {Domain: "example.com", FQDN: "www.example.com", IP: "192.0.2.1", RRType: "A"},
+        {Domain: "example.org", FQDN: "example.org", IP: "198.51.100.1", RRType: "A"},
+    }); err != nil { t.Fatal(err) }
+    if err := db.UpsertServices(ctx, []Service{{IP: "192.0.2.1", Port: 443, Proto: "tcp"}, {IP: "198.51.100.1", Port: 80, Proto: "tcp"}}); err != nil { t.Fatal(err) }
+    com, err := db.ServiceID(ctx, "192.0.2.1", 443, "tcp")
+    if err != nil { t.Fatal(err) }
+    org, err := db.ServiceID(ctx, "198.51.100.1", 80, "tcp")
+    if err != nil { t.Fatal(err) }
+    if err := db.UpsertWebTargets(ctx, []WebTarget{
+        {ServiceID: com, SNIMode: "sni_host", InputHost: "www.example.com", URL: "https://192.0.2.1:443", Status: 200},
+        {ServiceID: com, SNIMode: "direct_ip", URL: "https://192.0.2.1:443", Status: 404},
+        {ServiceID: org, SNIMode: "direct_ip", URL: "http://198.51.100.1", Status: 301},
+    }); err != nil { t.Fatal(err) }
+    if err := db.AddDiscoveries(ctx, []Discovery{
+        {Source: "subfinder", Hostname: "www.example.com", InScope: true},
+        {Source: "subfinder", Hostname: "badexample.com"},
+        {Source: "san", Hostname: "example.org", InScope: true},
+    }); err != nil { t.Fatal(err) }
+
+    urls := func(f Filter) []string {
+        ws, err := db.ListWebTargets(ctx, f)
+        if err != nil { t.Fatal(err) }
+        var out []string
+        for _, w := range ws { out = append(out, w.SNIMode+" "+w.URL) }
+        return out
+    }
+    for _, tc := range []struct {
+        name string
+        f    Filter
+        want []string
+    }{
+        {"all", Filter{}, []string{"direct_ip http://198.51.100.1", "direct_ip https://192.0.2.1:443", "sni_host https://192.0.2.1:443"}},
+        {"domain", Filter{Domain: "example.com"}, []string{"direct_ip https://192.0.2.1:443", "sni_host https://192.0.2.1:443"}},
+        {"status", Filter{Status: []StatusRange{{200, 299}, {300, 399}}}, []string{"direct_ip http://198.51.100.1", "sni_host https://192.0.2.1:443"}},
+        {"domain and status", Filter{Domain: "example.com", Status: []StatusRange{{400, 499}}}, []string{"direct_ip https://192.0.2.1:443"}},
+        {"since", Filter{Since: time.Now().Add(time.Hour)}, nil},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            if got := urls(tc.f); !reflect.DeepEqual(got, tc.want) { t.Errorf("web targets = %q, want %q", got, tc.want) }
+        })
+    }
+
+    ds, err := db.ListDiscoveries(ctx, Filter{Domain: "example.com"})
+    if err != nil { t.Fatal(err) }
+    if len(ds) != 1 || ds[0].Hostname != "www.example.com" { t.Errorf("discoveries = %+v, want only www (not badexample.com)", ds) }
+    as, err := db.ListAssets(ctx, Filter{Domain: "example.org", OnlyWeb: true})
+    if err != nil { t.Fatal(err) }
+    if len(as) != 1 || as[0].FQDN != "example.org" { t.Errorf("assets = %+v", as) }
+    ss, err := db.ListServices(ctx, Filter{Domain: "example.com"})
+    if err != nil { t.Fatal(err) }
+    if len(ss) != 1 || ss[0].ID != com { t.Errorf("services = %+v", ss) }
+}
+
+func TestSnapshots(t *testing.T) {
+    ctx := context.Background()
+    dir := t.TempDir()
+    db := openTest(t, filepath.Join(dir, "hermetica.sqlite"))
+    if err := db.UpsertAssets(ctx, []Asset{{Domain: "example.com", FQDN: "www.example.com", IP: "192.0.2.1", RRType: "A"}}); err != nil { t.Fatal(err) }
+    var snaps []string
+    for i := 0; i < 4; i++ {
+        r, err := db.StartRun(ctx, "example.com", "run")
+        if err != nil { t.Fatal(err) }
+        p := filepath.Join(dir, "snapshots", r.ID+".sqlite")
+        if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil { t.Fatal(err) }
+        if err := db.Snapshot(ctx, p); err != nil { t.Fatal(err) }
+        if err := db.FinishRun(ctx, r.ID, "ok", p); err != nil { t.Fatal(err) }
+        snaps = append(snaps, p)
+    }
+    other, err := db.StartRun(ctx, "example.org", "run")
+    if err != nil { t.Fatal(err) }
+    otherSnap := filepath.Join(dir, "snapshots", other.ID+".sqlite")
+    if err := db.Snapshot(ctx, otherSnap); err != nil { t.Fatal(err) }
+    if err := db.FinishRun(ctx, other.ID, "ok", otherSnap); err != nil { t.Fatal(err) }
+
+    // A snapshot is a usable database with the same rows; writing it again
+    // replaces the file.
+    if err := db.Snapshot(ctx, snaps[3]); err != nil { t.Fatal(err) }
+    s := openTest(t, snaps[3])
+    as, err := s.ListAssets(ctx, Filter{})
+    if err != nil { t.Fatal(err) }
+    if len(as) != 1 || as[0].FQDN != "www.example.com" { t.Errorf("snapshot assets = %+v", as) }
+
+    removed, err := db.PruneSnapshots(ctx, "example.com", 2)
+    if err != nil { t.Fatal(err) }
+    if want := []string{snaps[1], snaps[0]}; !reflect.DeepEqual(removed, want) { t.Errorf("removed %v, want %v", removed, want) }
+    for i, p := range snaps {
+        _, err := os.Stat(p)
+        if kept := i >= 2; kept != (err == nil) { t.Errorf("%s: exists = %v", p, err == nil) }
+    }
+    if _, err := os.Stat(otherSnap); err != nil { t.Errorf("other domain's snapshot pruned: %v", err) }
+    runs, err := db.ListRuns(ctx, "example.com")
+    if err != nil { t.Fatal(err) }
+    var left []string
+    for _, r := range runs { left = append(left, r.Snapshot) }
+    if want := []string{"", "", snaps[2], snaps[3]}; !reflect.DeepEqual(left, want) { t.Errorf("run snapshots = %v, want %v", left, want) }
+    if _, err := db.GetRun(ctx, "nope"); err != ErrNoRun { t.Errorf("GetRun(unknown) = %v", err) }
+}
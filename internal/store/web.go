@@ -0,0 +1,151 @@
+package store
+
+import (
+    "context"
+    "crypto/rand"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "time"
+
+    "github.com/oklog/ulid/v2"
+)
+
+// NewID returns a new ULID string.
+func NewID() string {
+    return ulid.MustNew(ulid.Timestamp(time.Now()), rand.Reader).String()
+}
+
+type WebTarget struct {
+    ID        string    `json:"id"` // optional; a new ULID is allocated when empty
+    ServiceID string    `json:"service_id"`
+    InputHost string    `json:"input_host"`
+    SNIMode   string    `json:"sni_mode"`
+    URL       string    `json:"url"`
+    Status    int       `json:"status"`
+    Title     string    `json:"title"`
+    FinalURL  string    `json:"final_url"`
+    TLSIssuer string    `json:"tls_issuer"`
+    CDNHint   string    `json:"cdn_hint"`
+    Tech      []string  `json:"tech"`
+    BodyHash  string    `json:"body_hash"`
+    PageGroup string    `json:"page_group"`
+    BodyPath  string    `json:"body_path"`
+    ShotPath  string    `json:"shot_path"`
+    FirstSeen time.Time `json:"first_seen"` // set by the store; ignored on upsert
+    LastSeen  time.Time `json:"last_seen"`
+}
+
+// ServiceID returns the ID of the (ip, port, proto) service, creating the
+// row when it does not exist yet.
+func (d *DB) ServiceID(ctx context.Context, ip string, port int, proto string) (string, error) {
+    var id sql.NullString
+    err := d.sql.QueryRowContext(ctx, `SELECT id FROM services WHERE ip = ? AND port = ? AND proto = ?`, ip, port, proto).Scan(&id)
+    switch {
+    case err == nil && id.Valid && id.String != "":
+        return id.String, nil
+    case err == nil:
+        nid := NewID()
+        _, err = d.sql.ExecContext(ctx, `UPDATE services SET id = ? WHERE ip = ? AND port = ? AND proto = ?`, nid, ip, port, proto)
+        return nid, err
+    case errors.Is(err, sql.ErrNoRows):
+        nid, now := NewID(), time.Now().UTC()
+        _, err = d.sql.ExecContext(ctx, `INSERT INTO services (id, ip, port, proto, is_web, first_seen, last_seen) VALUES (?, ?, ?, ?, ?, ?, ?)`, nid, ip, port, proto, false, now, now)
+        return nid, err
+    }
+    return "", err
+}
+
+// UpsertWebTargets inserts or refreshes web targets keyed on (service_id,
+// sni_mode, input_host, url), keeping first_seen and bumping last_seen.
+// Evidence paths already recorded are kept when the new row leaves them
+// empty.
+func (d *DB) UpsertWebTargets(ctx context.Context, recs []WebTarget) error {
+    if len(recs) == 0 { return nil }
+    tx, err := d.sql.BeginTx(ctx, nil)
+    if err != nil { return err }
+    defer tx.Rollback()
+    stmt, err := tx.PrepareContext(ctx, `INSERT INTO webtargets (id, service_id, input_host, sni_mode, url, status, title, final_url, tls_issuer, cdn_hint, tech, body_hash, page_group, body_path, shot_path, first_seen, last_seen)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(service_id, sni_mode, input_host, url) DO UPDATE SET
+            id=COALESCE(webtargets.id, excluded.id), first_seen=COALESCE(webtargets.first_seen, excluded.first_seen), last_seen=excluded.last_seen, status=excluded.status, title=excluded.title, final_url=excluded.final_url,
+            tls_issuer=excluded.tls_issuer, cdn_hint=excluded.cdn_hint, tech=excluded.tech,
+            body_hash=COALESCE(NULLIF(excluded.body_hash, ''), webtargets.body_hash),
+            page_group=COALESCE(NULLIF(excluded.page_group, ''), webtargets.page_group),
+            body_path=COALESCE(NULLIF(excluded.body_path, ''), webtargets.body_path),
+            shot_path=COALESCE(NULLIF(excluded.shot_path, ''), webtargets.shot_path)`)
+    if err != nil { return err }
+    defer stmt.Close()
+    mark, err := tx.PrepareContext(ctx, `UPDATE services SET is_web = 1 WHERE id = ?`)
+    if err != nil { return err }
+    defer mark.Close()
+    now := time.Now().UTC()
+    for _, w := range recs {
+        tech, _ := json.Marshal(w.Tech)
+        if w.Tech == nil { tech = []byte("[]") }
+        id := w.ID
+        if id == "" { id = NewID() }
+        if _, err := stmt.ExecContext(ctx, id, w.ServiceID, w.InputHost, w.SNIMode, w.URL, w.Status, w.Title, w.FinalURL, w.TLSIssuer, w.CDNHint, string(tech), w.BodyHash, w.PageGroup, w.BodyPath, w.ShotPath, now, now); err != nil { return err }
+        if _, err := mark.ExecContext(ctx, w.ServiceID); err != nil { return err }
+    }
+    return tx.Commit()
+}
+
+// WebTargetID returns the ID of the web target with the given key, or ""
+// when it is not stored.
+func (d *DB) WebTargetID(ctx context.Context, serviceID, sniMode, inputHost, url string) (string, error) {
+    var id sql.NullString
+    err := d.sql.QueryRowContext(ctx, `SELECT id FROM webtargets WHERE service_id = ? AND sni_mode = ? AND input_host = ? AND url = ?`, serviceID, sniMode, inputHost, url).Scan(&id)
+    if errors.Is(err, sql.ErrNoRows) { return "", nil }
+    return id.String, err
+}
+
+type Endpoint struct {
+    WebTargetID string
+    URL         string
+    Method      string
+    Status      int
+    Source      string // page the endpoint was found on
+}
+
+// UpsertEndpoints records crawled endpoints, keeping first_seen and bumping
+// last_seen for endpoints seen before.
+func (d *DB) UpsertEndpoints(ctx context.Context, recs []Endpoint) error {
+    if len(recs) == 0 { return nil }
+    tx, err := d.sql.BeginTx(ctx, nil)
+    if err != nil { return err }
+    defer tx.Rollback()
+    stmt, err := tx.PrepareContext(ctx, `INSERT INTO endpoints (id, webtarget_id, url, method, status, source, first_seen, last_seen)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(webtarget_id, method, url) DO UPDATE SET status=excluded.status, source=excluded.source, last_seen=excluded.last_seen`)
+    if err != nil { return err }
+    defer stmt.Close()
+    now := time.Now().UTC()
+    for _, e := range recs {
+        if _, err := stmt.ExecContext(ctx, NewID(), e.WebTargetID, e.URL, e.Method, e.Status, e.Source, now, now); err != nil { return err }
+    }
+    return tx.Commit()
+}
+
+// SetShotPath records the screenshot of the given web targets.
+func (d *DB) SetShotPath(ctx context.Context, ids []string, path string) error {
+    for _, id := range ids {
+        if _, err := d.sql.ExecContext(ctx, `UPDATE webtargets SET shot_path = ? WHERE id = ?`, path, id); err != nil { return err }
+    }
+    return nil
+}
+
+// SetPageGroups records the page group of each web target, keyed by ID.
+func (d *DB) SetPageGroups(ctx context.Context, groups map[string]string) error {
+    if len(groups) == 0 { return nil }
+    tx, err := d.sql.BeginTx(ctx, nil)
+    if err != nil { return err }
+    defer tx.Rollback()
+    stmt, err := tx.PrepareContext(ctx, `UPDATE webtargets SET page_group = ? WHERE id = ?`)
+    if err != nil { return err }
+    defer stmt.Close()
+    for id, g := range groups {
+        if _, err := stmt.ExecContext(ctx, g, id); err != nil { return err }
+    }
+    return tx.Commit()
+}
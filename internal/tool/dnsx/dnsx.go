@@ -13,8 +13,9 @@ import (
     "hermetica/internal/executil"
 )
 
-// Build input file from subfinder JSONL to a plain list of hostnames
-func BuildInputFromSubfinder(subsJSONL string, outList string) error {
+// Build input file from subfinder JSONL to a plain list of hostnames.
+// keep is consulted once per unique host (nil keeps everything).
+func BuildInputFromSubfinder(subsJSONL string, outList string, keep func(host, source string) bool) error {
     in, err := os.Open(subsJSONL)
     if err != nil { return err }
     defer in.Close()
@@ -32,6 +33,8 @@ func BuildInputFromSubfinder(subsJSONL string, outList string) error {
                 if h == "" { continue }
                 if _, dup := seen[h]; !dup {
                     seen[h] = struct{}{}
+                    src, _ := obj["source"].(string)
+                    if keep != nil && !keep(h, src) { continue }
                     out.WriteString(h+"\n")
                 }
             }
@@ -48,10 +51,46 @@ func Run(ctx context.Context, cfg *config.Config, inList string, outJSONL string
     args := []string{"-l", inList, "-a", "-cname", "-retry", "2", "-json"}
     if cfg.DNS.IPv6Enabled { args = append(args, "-aaaa") }
     if cfg.Tools.ResolversFile != "" { args = append(args, "-r", cfg.Tools.ResolversFile) }
-    spec := executil.CmdSpec{Path: cfg.Tools.Paths["dnsx"], Args: args, Timeout: 60 * time.Minute}
+    spec := executil.CmdSpec{Path: cfg.Tools.Paths["dnsx"], Args: args, Timeout: 60 * time.Minute, Retry: cfg.Tools.Retry.Policy()}
     err = executil.RunJSONL(ctx, spec, func(b []byte) error { _, werr := f.Write(append(b, '\n')); return werr })
-    if err != nil { return err }
+    if err != nil { f.Close(); _ = os.Remove(outJSONL+".tmp"); return err }
     f.Close()
     return os.Rename(outJSONL+".tmp", outJSONL)
 }
 
+
+// Record is the subset of a dnsx JSONL row Hermetica relies on.
+type Record struct {
+    Host  string
+    A     []string
+    AAAA  []string
+    CNAME []string
+}
+
+// UnmarshalJSON accepts cname either as a string or as a list, as dnsx
+// versions differ.
+func (r *Record) UnmarshalJSON(b []byte) error {
+    var raw struct {
+        Host  string          `json:"host"`
+        A     []string        `json:"a"`
+        AAAA  []string        `json:"aaaa"`
+        CNAME json.RawMessage `json:"cname"`
+    }
+    if err := json.Unmarshal(b, &raw); err != nil { return err }
+    r.Host, r.A, r.AAAA, r.CNAME = raw.Host, raw.A, raw.AAAA, nil
+    if len(raw.CNAME) == 0 || string(raw.CNAME) == "null" { return nil }
+    var one string
+    if err := json.Unmarshal(raw.CNAME, &one); err == nil {
+        if one != "" { r.CNAME = []string{one} }
+        return nil
+    }
+    return json.Unmarshal(raw.CNAME, &r.CNAME)
+}
+
+// Answers returns every value the record resolved to.
+func (r Record) Answers() []string {
+    out := make([]string, 0, len(r.A)+len(r.AAAA)+len(r.CNAME))
+    out = append(out, r.A...)
+    out = append(out, r.AAAA...)
+    return append(out, r.CNAME...)
+}
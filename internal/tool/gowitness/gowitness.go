@@ -0,0 +1,36 @@
+package gowitness
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "hermetica/internal/config"
+    "hermetica/internal/executil"
+)
+
+// Shoot captures a PNG of url and moves it to outPNG. gowitness names files
+// after the URL, so it writes into a scratch directory that is removed
+// afterwards.
+func Shoot(ctx context.Context, cfg *config.Config, url, outPNG string, timeout time.Duration) error {
+    if err := os.MkdirAll(filepath.Dir(outPNG), 0o755); err != nil { return err }
+    scratch, err := os.MkdirTemp(filepath.Dir(outPNG), ".gowitness-")
+    if err != nil { return err }
+    defer os.RemoveAll(scratch)
+    secs := int(timeout / time.Second)
+    if secs <= 0 { secs = 20 }
+    args := []string{"scan", "single", "--url", url, "--screenshot-path", scratch, "--screenshot-format", "png", "--timeout", fmt.Sprintf("%d", secs), "--quiet"}
+    spec := executil.CmdSpec{Path: cfg.Tools.Paths["gowitness"], Args: args, Timeout: timeout + 30*time.Second, Retry: cfg.Tools.Retry.Policy()}
+    if err := executil.RunJSONL(ctx, spec, func([]byte) error { return nil }); err != nil { return err }
+    entries, err := os.ReadDir(scratch)
+    if err != nil { return err }
+    for _, e := range entries {
+        if e.IsDir() || !strings.HasSuffix(strings.ToLower(e.Name()), ".png") { continue }
+        return os.Rename(filepath.Join(scratch, e.Name()), outPNG)
+    }
+    return errors.New("gowitness produced no screenshot")
+}
@@ -2,26 +2,140 @@ package httpx
 
 import (
     "context"
+    "encoding/json"
     "fmt"
     "os"
     "path/filepath"
+    "sort"
+    "strings"
+    "sync"
     "time"
 
     "hermetica/internal/config"
     "hermetica/internal/executil"
 )
 
-func RunBasic(ctx context.Context, cfg *config.Config, inList, outJSONL string) error {
+func baseArgs(cfg *config.Config) []string {
+    args := []string{"-json", "-fr", "-title", "-sc", "-tech-detect", "-tls-grab", "-no-color", "-silent", "-retries", intToStr(cfg.Limits.Retries), "-timeout", intToStr(cfg.Limits.HTTPXTimeoutSec)}
+    if CaptureBodies(cfg) {
+        // -irr adds the body, raw request and raw response to each row;
+        // -rsts caps what is kept. captureEvidence strips all three.
+        args = append(args, "-irr")
+        if cfg.Limits.MaxBodyKB > 0 { args = append(args, "-rsts", intToStr(cfg.Limits.MaxBodyKB*1024)) }
+    }
+    return args
+}
+
+// CaptureBodies reports whether the evidence settings need response bodies.
+func CaptureBodies(cfg *config.Config) bool {
+    return cfg.Evidence.StoreBodyHash || cfg.Evidence.StoreBodySample || cfg.Evidence.NearDupe
+}
+
+// ProbeGroup is one httpx run: every ip:port in Inputs probed with the same
+// addressing Mode. Host is the hostname sent as TLS server name and/or Host
+// header; it is empty for ModeDirectIP.
+type ProbeGroup struct {
+    Mode   string
+    Host   string
+    Inputs []string
+}
+
+// name identifies the group's part file within the parts directory.
+func (g ProbeGroup) name() string {
+    if g.Host == "" { return g.Mode }
+    return filepath.Join(g.Mode, g.Host)
+}
+
+// args returns the flags that address every input as g.Host: -sni sets the
+// TLS server name and -H the Host header. The connection always goes to the
+// ip:port of the input.
+func (g ProbeGroup) args() []string {
+    switch g.Mode {
+    case ModeSNIHost:
+        return []string{"-sni", g.Host, "-H", "Host: " + g.Host}
+    case ModeSNIOnly:
+        return []string{"-sni", g.Host}
+    case ModeHostOnly:
+        return []string{"-H", "Host: " + g.Host}
+    }
+    return nil
+}
+
+// maxRuns caps how many httpx processes RunMatrix starts at once; each
+// already probes with its own thread pool.
+const maxRuns = 4
+
+// RunMatrix runs httpx once per group, tagging every row with sni_mode and
+// input_host, and concatenates the results into outJSONL. Each group's
+// output is kept in partsDir so an interrupted run only re-probes the
+// groups that did not finish.
+func RunMatrix(ctx context.Context, cfg *config.Config, groups []ProbeGroup, partsDir, outJSONL string) error {
+    if err := os.MkdirAll(partsDir, 0o755); err != nil { return err }
+    sort.Slice(groups, func(i, j int) bool {
+        if groups[i].Mode != groups[j].Mode { return groups[i].Mode < groups[j].Mode }
+        return groups[i].Host < groups[j].Host
+    })
+    parts := make([]string, len(groups))
+    errs := make([]error, len(groups))
+    sem := make(chan struct{}, maxRuns)
+    var wg sync.WaitGroup
+    for i, g := range groups {
+        parts[i] = filepath.Join(partsDir, g.name()+".jsonl")
+        if _, err := os.Stat(parts[i]); err == nil { continue }
+        wg.Add(1)
+        go func(i int, g ProbeGroup) {
+            defer wg.Done()
+            sem <- struct{}{}
+            defer func() { <-sem }()
+            errs[i] = runGroup(ctx, cfg, g, parts[i])
+        }(i, g)
+    }
+    wg.Wait()
+    for i, err := range errs {
+        if err != nil { return fmt.Errorf("%s: %w", groups[i].name(), err) }
+    }
+
     if err := os.MkdirAll(filepath.Dir(outJSONL), 0o755); err != nil { return err }
-    f, err := os.Create(outJSONL+".tmp")
+    f, err := os.Create(outJSONL + ".tmp")
     if err != nil { return err }
     defer f.Close()
-    args := []string{"-json", "-fr", "-title", "-sc", "-tech-detect", "-tls-grab", "-no-color", "-silent", "-retries", intToStr(cfg.Limits.Retries), "-timeout", intToStr(cfg.Limits.HTTPXTimeoutSec), "-list", inList}
-    spec := executil.CmdSpec{Path: cfg.Tools.Paths["httpx"], Args: args, Timeout: 24 * time.Hour}
-    err = executil.RunJSONL(ctx, spec, func(b []byte) error { _, werr := f.Write(append(b, '\n')); return werr })
+    for _, p := range parts {
+        b, err := os.ReadFile(p)
+        if err != nil { return err }
+        if _, err := f.Write(b); err != nil { return err }
+    }
+    if err := f.Close(); err != nil { return err }
+    return os.Rename(outJSONL+".tmp", outJSONL)
+}
+
+// command returns the httpx arguments for g reading its inputs from list.
+func command(cfg *config.Config, g ProbeGroup, list string) []string {
+    return append(append(baseArgs(cfg), g.args()...), "-list", list)
+}
+
+func runGroup(ctx context.Context, cfg *config.Config, g ProbeGroup, out string) error {
+    if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil { return err }
+    list := strings.TrimSuffix(out, ".jsonl") + ".txt"
+    if err := os.WriteFile(list, []byte(strings.Join(g.Inputs, "\n")+"\n"), 0o644); err != nil { return err }
+    f, err := os.Create(out + ".tmp")
     if err != nil { return err }
+    defer f.Close()
+    spec := executil.CmdSpec{Path: cfg.Tools.Paths["httpx"], Args: command(cfg, g, list), Timeout: 24 * time.Hour, Retry: cfg.Tools.Retry.Policy()}
+    mode, host := quote(g.Mode), quote(g.Host)
+    err = executil.RunJSONL(ctx, spec, func(b []byte) error {
+        var row map[string]json.RawMessage
+        if err := json.Unmarshal(b, &row); err != nil { return nil }
+        row["sni_mode"], row["input_host"] = mode, host
+        tagged, err := json.Marshal(row)
+        if err != nil { return err }
+        _, werr := f.Write(append(tagged, '\n'))
+        return werr
+    })
+    if err != nil { f.Close(); _ = os.Remove(out+".tmp"); return err }
     f.Close()
-    return os.Rename(outJSONL+".tmp", outJSONL)
+    return os.Rename(out+".tmp", out)
 }
 
+func quote(s string) json.RawMessage { b, _ := json.Marshal(s); return b }
+
 func intToStr(i int) string { return fmt.Sprintf("%d", i) }
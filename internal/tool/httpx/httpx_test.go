@@ -0,0 +1,89 @@
+package httpx
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "testing"
+
+    "hermetica/internal/config"
+)
+
+// fakeHTTPX records its arguments next to the -list file it was given and
+// prints one row per input line.
+const fakeHTTPX = `#!/bin/sh
+list=
+prev=
+for a in "$@"; do
+    [ "$prev" = "-list" ] && list=$a
+    prev=$a
+done
+printf '%s\n' "$@" > "$list.args"
+while read -r in; do printf '{"input":"%s","url":"https://%s"}\n' "$in" "$in"; done < "$list"
+`
+
+func testConfig(httpx string) *config.Config {
+    cfg := &config.Config{}
+    cfg.Tools.Paths = map[string]string{"httpx": httpx}
+    cfg.Limits.Retries = 1
+    cfg.Limits.HTTPXTimeoutSec = 10
+    return cfg
+}
+
+var base = []string{"-json", "-fr", "-title", "-sc", "-tech-detect", "-tls-grab", "-no-color", "-silent", "-retries", "1", "-timeout", "10"}
+
+func TestRunMatrix(t *testing.T) {
+    dir := t.TempDir()
+    bin := filepath.Join(dir, "httpx")
+    if err := os.WriteFile(bin, []byte(fakeHTTPX), 0o755); err != nil { t.Fatal(err) }
+    cfg := testConfig(bin)
+    inputs := []string{"192.0.2.1:443", "[2001:db8::1]:8443"}
+    for _, tc := range []struct {
+        mode, host string
+        part       string   // part file below the parts dir
+        args       []string // group flags between the base flags and -list
+    }{
+        {ModeSNIHost, "www.example.com", "sni_host/www.example.com", []string{"-sni", "www.example.com", "-H", "Host: www.example.com"}},
+        {ModeSNIOnly, "www.example.com", "sni/www.example.com", []string{"-sni", "www.example.com"}},
+        {ModeHostOnly, "www.example.com", "host/www.example.com", []string{"-H", "Host: www.example.com"}},
+        {ModeDirectIP, "", "direct_ip", nil},
+    } {
+        t.Run(tc.mode, func(t *testing.T) {
+            parts, out := filepath.Join(dir, tc.mode), filepath.Join(dir, tc.mode+".jsonl")
+            g := ProbeGroup{Mode: tc.mode, Host: tc.host, Inputs: inputs}
+            if err := RunMatrix(context.Background(), cfg, []ProbeGroup{g}, parts, out); err != nil { t.Fatal(err) }
+
+            list := filepath.Join(parts, tc.part+".txt")
+            b, err := os.ReadFile(list)
+            if err != nil { t.Fatal(err) }
+            if got := strings.Split(strings.TrimSpace(string(b)), "\n"); !slices.Equal(got, inputs) { t.Errorf("input lines = %q, want %q", got, inputs) }
+            b, err = os.ReadFile(list + ".args")
+            if err != nil { t.Fatal(err) }
+            want := append(append(append([]string{}, base...), tc.args...), "-list", list)
+            if got := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n"); !slices.Equal(got, want) { t.Errorf("argv = %q\nwant   %q", got, want) }
+
+            rows, err := ReadResults(out)
+            if err != nil { t.Fatal(err) }
+            if len(rows) != len(inputs) { t.Fatalf("got %d rows, want %d", len(rows), len(inputs)) }
+            for i, r := range rows {
+                if r.Input != inputs[i] || r.SNIMode != tc.mode || r.InputHost != tc.host { t.Errorf("row %d = %q %q %q", i, r.Input, r.SNIMode, r.InputHost) }
+            }
+        })
+    }
+}
+
+func TestRunMatrixKeepsFinishedGroups(t *testing.T) {
+    dir := t.TempDir()
+    cfg := testConfig(filepath.Join(dir, "missing-httpx"))
+    parts := filepath.Join(dir, "probe")
+    done := filepath.Join(parts, "sni_host", "a.example.com.jsonl")
+    if err := os.MkdirAll(filepath.Dir(done), 0o755); err != nil { t.Fatal(err) }
+    if err := os.WriteFile(done, []byte(`{"input":"192.0.2.1:443","sni_mode":"sni_host","input_host":"a.example.com"}`+"\n"), 0o644); err != nil { t.Fatal(err) }
+    groups := []ProbeGroup{{Mode: ModeSNIHost, Host: "a.example.com", Inputs: []string{"192.0.2.1:443"}}}
+    if err := RunMatrix(context.Background(), cfg, groups, parts, filepath.Join(dir, "web.jsonl")); err != nil { t.Fatalf("finished group was run again: %v", err) }
+    groups = append(groups, ProbeGroup{Mode: ModeSNIHost, Host: "b.example.com", Inputs: []string{"192.0.2.1:443"}})
+    err := RunMatrix(context.Background(), cfg, groups, parts, filepath.Join(dir, "web.jsonl"))
+    if err == nil || !strings.HasPrefix(err.Error(), "sni_host/b.example.com: ") { t.Errorf("err = %v", err) }
+}
@@ -0,0 +1,97 @@
+package httpx
+
+import (
+    "bufio"
+    "encoding/json"
+    "os"
+    "strconv"
+)
+
+// Result is the subset of an httpx JSONL row Hermetica relies on. Field
+// names differ slightly between httpx versions; UnmarshalJSON accepts both.
+type Result struct {
+    Input      string   `json:"input"`
+    Host       string   `json:"host"`
+    Port       int      `json:"port"`
+    Scheme     string   `json:"scheme"`
+    URL        string   `json:"url"`
+    StatusCode int      `json:"status_code"`
+    Title      string   `json:"title"`
+    FinalURL   string   `json:"final_url"`
+    Webserver  string   `json:"webserver"`
+    Tech       []string `json:"tech"`
+    CDNName    string   `json:"cdn_name"`
+    ContentLength int   `json:"content_length"`
+    TLS        *TLS     `json:"tls,omitempty"`
+
+    // Added by RunMatrix to record how the request was addressed.
+    SNIMode   string `json:"sni_mode"`
+    InputHost string `json:"input_host"`
+
+    // Body is only present in raw rows when bodies are captured; the
+    // pipeline replaces it (and the raw request and response) with the
+    // fields below before storing the row.
+    Body        string `json:"body,omitempty"`
+    WebTargetID string `json:"webtarget_id,omitempty"`
+    BodyHash    string `json:"body_hash,omitempty"`
+    BodyPath    string `json:"body_path,omitempty"`
+    SimHash     string `json:"simhash,omitempty"`
+    PageGroup   string `json:"page_group,omitempty"`
+}
+
+type TLS struct {
+    SubjectCN string   `json:"subject_cn"`
+    IssuerCN  string   `json:"issuer_cn"`
+    DNSNames  []string `json:"dns_names"`
+}
+
+func (r *Result) UnmarshalJSON(b []byte) error {
+    type plain Result
+    var raw struct {
+        plain
+        Port         json.RawMessage `json:"port"`
+        Technologies []string        `json:"technologies"`
+        TLS          *struct {
+            TLS
+            SubjectAN []string `json:"subject_an"`
+        } `json:"tls"`
+    }
+    if err := json.Unmarshal(b, &raw); err != nil { return err }
+    *r = Result(raw.plain)
+    if len(raw.Port) > 0 {
+        var s string
+        if json.Unmarshal(raw.Port, &s) == nil { r.Port, _ = strconv.Atoi(s) } else { _ = json.Unmarshal(raw.Port, &r.Port) }
+    }
+    if len(r.Tech) == 0 { r.Tech = raw.Technologies }
+    if raw.TLS != nil {
+        t := raw.TLS.TLS
+        if len(t.DNSNames) == 0 { t.DNSNames = raw.TLS.SubjectAN }
+        r.TLS = &t
+    }
+    return nil
+}
+
+// ReadResults parses every row of an httpx JSONL file, skipping lines that
+// do not parse.
+func ReadResults(path string) ([]Result, error) {
+    f, err := os.Open(path)
+    if err != nil { return nil, err }
+    defer f.Close()
+    var out []Result
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+    for sc.Scan() {
+        var r Result
+        if err := json.Unmarshal(sc.Bytes(), &r); err == nil { out = append(out, r) }
+    }
+    return out, sc.Err()
+}
+
+// Probe modes recorded in web.jsonl and webtargets.sni_mode, describing
+// how the request was addressed.
+const (
+    ModeSNIHost  = "sni_host"  // SNI and Host header set to the hostname
+    ModeSNIOnly  = "sni"       // SNI set, Host header is the IP
+    ModeHostOnly = "host"      // Host header set, no SNI
+    ModeDirectIP = "direct_ip" // bare ip:port
+)
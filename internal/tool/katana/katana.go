@@ -0,0 +1,66 @@
+package katana
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "path/filepath"
+    "time"
+
+    "hermetica/internal/config"
+    "hermetica/internal/executil"
+)
+
+// Run crawls every URL in inList with the limits from stages.crawling.katana.
+// The field scope is kept to the root domain of each seed.
+func Run(ctx context.Context, cfg *config.Config, inList, outJSONL string) error {
+    if err := os.MkdirAll(filepath.Dir(outJSONL), 0o755); err != nil { return err }
+    f, err := os.Create(outJSONL+".tmp")
+    if err != nil { return err }
+    defer f.Close()
+    spec := executil.CmdSpec{Path: cfg.Tools.Paths["katana"], Args: command(cfg, inList), Timeout: 24 * time.Hour, Retry: cfg.Tools.Retry.Policy()}
+    err = executil.RunJSONL(ctx, spec, func(b []byte) error { _, werr := f.Write(append(b, '\n')); return werr })
+    if err != nil { f.Close(); _ = os.Remove(outJSONL+".tmp"); return err }
+    f.Close()
+    return os.Rename(outJSONL+".tmp", outJSONL)
+}
+
+// command returns katana's arguments. timeout_seconds bounds the crawl of
+// each seed (-crawl-duration); single requests use limits.httpx_timeout_seconds.
+func command(cfg *config.Config, inList string) []string {
+    k := cfg.Stages.Crawling.Katana
+    args := []string{"-silent", "-jsonl", "-no-color", "-fs", "rdn", "-list", inList}
+    if k.Concurrency > 0 { args = append(args, "-concurrency", fmtInt(k.Concurrency)) }
+    if k.TimeoutSeconds > 0 { args = append(args, "-crawl-duration", fmtInt(k.TimeoutSeconds)+"s") }
+    if t := cfg.Limits.HTTPXTimeoutSec; t > 0 { args = append(args, "-timeout", fmtInt(t)) }
+    if k.MaxDepth > 0 { args = append(args, "-depth", fmtInt(k.MaxDepth)) }
+    return args
+}
+
+// Result is the subset of a katana JSONL row Hermetica relies on. Older
+// releases only emit request.url and output.
+type Result struct {
+    Request struct {
+        Method   string `json:"method"`
+        Endpoint string `json:"endpoint"`
+        URL      string `json:"url"`
+        Source   string `json:"source"`
+    } `json:"request"`
+    Response struct {
+        StatusCode int `json:"status_code"`
+    } `json:"response"`
+    Output string `json:"output"`
+}
+
+// Endpoint returns the discovered URL of the row.
+func (r Result) Endpoint() string {
+    switch {
+    case r.Request.Endpoint != "":
+        return r.Request.Endpoint
+    case r.Output != "":
+        return r.Output
+    }
+    return r.Request.URL
+}
+
+func fmtInt(i int) string { return fmt.Sprintf("%d", i) }
@@ -0,0 +1,29 @@
+package katana
+
+import (
+    "slices"
+    "testing"
+
+    "hermetica/internal/config"
+)
+
+func TestCommand(t *testing.T) {
+    base := []string{"-silent", "-jsonl", "-no-color", "-fs", "rdn", "-list", "seeds.txt"}
+    for _, tc := range []struct {
+        name                    string
+        conc, crawl, req, depth    int
+        want                       []string
+    }{
+        {"defaults", 0, 0, 0, 0, base},
+        {"all limits", 10, 600, 8, 2, append(slices.Clone(base), "-concurrency", "10", "-crawl-duration", "600s", "-timeout", "8", "-depth", "2")},
+        {"request timeout only", 0, 0, 5, 0, append(slices.Clone(base), "-timeout", "5")},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            cfg := &config.Config{}
+            k := &cfg.Stages.Crawling.Katana
+            k.Concurrency, k.TimeoutSeconds, k.MaxDepth = tc.conc, tc.crawl, tc.depth
+            cfg.Limits.HTTPXTimeoutSec = tc.req
+            if got := command(cfg, "seeds.txt"); !slices.Equal(got, tc.want) { t.Errorf("args = %q\nwant   %q", got, tc.want) }
+        })
+    }
+}
@@ -5,16 +5,23 @@ import (
     "context"
     "encoding/json"
     "fmt"
+    "io"
+    "math"
     "os"
     "path/filepath"
+    "regexp"
+    "strconv"
+    "strings"
     "time"
 
     "hermetica/internal/config"
     "hermetica/internal/executil"
 )
 
-// Build input IP list from dnsx JSONL
-func BuildIPsFromDNSX(resolvedJSONL, outList string, includeIPv6 bool) error {
+// Build input IP list from dnsx JSONL. keep is consulted once per IP, with
+// the first host that resolved to it (nil keeps everything); kept IPs are
+// written once.
+func BuildIPsFromDNSX(resolvedJSONL, outList string, includeIPv6 bool, keep func(host, ip string) bool) error {
     in, err := os.Open(resolvedJSONL)
     if err != nil { return err }
     defer in.Close()
@@ -22,37 +29,193 @@ func BuildIPsFromDNSX(resolvedJSONL, outList string, includeIPv6 bool) error {
     out, err := os.Create(outList)
     if err != nil { return err }
     defer out.Close()
-    seen := map[string]struct{}{}
+    decided := map[string]bool{}
     sc := bufio.NewScanner(in)
     for sc.Scan() {
         var obj struct{
+            Host string `json:"host"`
             A []string `json:"a"`
             AAAA []string `json:"aaaa"`
         }
         if err := json.Unmarshal(sc.Bytes(), &obj); err == nil {
-            for _, ip := range obj.A { if _,ok:=seen[ip]; !ok { seen[ip]=struct{}{}; out.WriteString(ip+"\n") } }
-            if includeIPv6 {
-                for _, ip := range obj.AAAA { if _,ok:=seen[ip]; !ok { seen[ip]=struct{}{}; out.WriteString(ip+"\n") } }
+            ips := obj.A
+            if includeIPv6 { ips = append(ips, obj.AAAA...) }
+            for _, ip := range ips {
+                if _, done := decided[ip]; done { continue }
+                ok := keep == nil || keep(obj.Host, ip)
+                decided[ip] = ok
+                if ok { out.WriteString(ip+"\n") }
             }
         }
     }
     return sc.Err()
 }
 
-func Run(ctx context.Context, cfg *config.Config, inList, outJSONL string) error {
+// RateChange describes one adjustment of the scan rate between batches.
+type RateChange struct {
+    Batch    int     // index of the batch that was measured
+    Loss     float64 // observed loss ratio for that batch
+    From, To int
+}
+
+// Options tunes Run. The zero value scans everything from scratch.
+type Options struct {
+    OnRate  func(RateChange) // called for every adaptive rate change
+    OnBatch func(Progress)   // called after each completed batch
+    Resume  *Progress        // progress of an interrupted run to continue from
+}
+
+// Progress records how far a scan got: the IPs of every completed batch,
+// the size of outJSONL.partial after the last of them, and the rate the
+// next batch would have used.
+type Progress struct {
+    Covered []string `json:"covered"`
+    Offset  int64    `json:"offset"`
+    Rate    int      `json:"rate"`
+}
+
+// Run scans every IP in inList in batches of scan.batch_size and writes
+// naabu's JSONL to outJSONL. Rows are streamed into outJSONL.partial, which
+// is renamed once every batch is done, so an interrupted scan keeps its
+// results; passing its Progress as opt.Resume truncates the partial file to
+// the last completed batch and scans only the IPs not yet covered.
+//
+// With scan.adaptive_backoff enabled the rate of each batch is derived from
+// the loss seen in the previous one: it is multiplied by backoff_multiplier
+// when the loss exceeded packet_loss_threshold (never below min_rate) and by
+// recovery_multiplier otherwise (never above naabu_rate).
+func Run(ctx context.Context, cfg *config.Config, inList, outJSONL string, opt Options) error {
     if err := os.MkdirAll(filepath.Dir(outJSONL), 0o755); err != nil { return err }
-    f, err := os.Create(outJSONL+".tmp")
+    ab := cfg.Scan.AdaptiveBackoff
+    size, minRate, maxRate := cfg.Scan.BatchSize, ab.MinRate, cfg.Scan.NaabuRate
+    if size <= 0 { size = 256 }
+    if ab.Enabled {
+        if maxRate <= 0 { maxRate = 1000 } // naabu's own default
+        if minRate <= 0 { minRate = 100 }
+        if minRate > maxRate { minRate = maxRate }
+    }
+    ips, err := readList(inList)
+    if err != nil { return err }
+
+    partial := outJSONL + ".partial"
+    prog := Progress{Rate: maxRate}
+    if r := opt.Resume; r != nil {
+        prog = Progress{Covered: append([]string(nil), r.Covered...), Offset: r.Offset, Rate: r.Rate}
+        if prog.Rate <= 0 || !ab.Enabled { prog.Rate = maxRate }
+        done := map[string]bool{}
+        for _, ip := range prog.Covered { done[ip] = true }
+        todo := ips[:0]
+        for _, ip := range ips {
+            if !done[ip] { todo = append(todo, ip) }
+        }
+        ips = todo
+    }
+    f, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0o644)
     if err != nil { return err }
     defer f.Close()
+    if err := f.Truncate(prog.Offset); err != nil { return err }
+    if _, err := f.Seek(prog.Offset, io.SeekStart); err != nil { return err }
+    write := func(b []byte) error {
+        n, werr := f.Write(append(b, '\n'))
+        prog.Offset += int64(n)
+        return werr
+    }
+    list, err := os.CreateTemp(filepath.Dir(inList), ".naabu-batch-*.txt")
+    if err != nil { return err }
+    list.Close()
+    defer os.Remove(list.Name())
+
+    for b, i := 0, 0; i < len(ips); b, i = b+1, i+size {
+        end := i + size
+        if end > len(ips) { end = len(ips) }
+        if err := os.WriteFile(list.Name(), []byte(strings.Join(ips[i:end], "\n")+"\n"), 0o644); err != nil { return err }
+        st, err := scan(ctx, cfg, list.Name(), prog.Rate, ab.Enabled, write)
+        if err != nil { return fmt.Errorf("batch %d: %w", b, err) }
+        // Without packet stats the batch gives no signal and the rate stays.
+        if loss, ok := st.loss(); ab.Enabled && ok {
+            next := nextRate(ab, prog.Rate, minRate, maxRate, loss)
+            if next != prog.Rate && opt.OnRate != nil { opt.OnRate(RateChange{Batch: b, Loss: loss, From: prog.Rate, To: next}) }
+            prog.Rate = next
+        }
+        if err := f.Sync(); err != nil { return err }
+        prog.Covered = append(prog.Covered, ips[i:end]...)
+        if opt.OnBatch != nil { opt.OnBatch(Progress{Covered: append([]string(nil), prog.Covered...), Offset: prog.Offset, Rate: prog.Rate}) }
+    }
+    if err := f.Close(); err != nil { return err }
+    return os.Rename(partial, outJSONL)
+}
+
+// nextRate is the rate of the batch after one that ran at rate and lost
+// loss of its packets.
+func nextRate(ab config.AdaptiveBackoff, rate, minRate, maxRate int, loss float64) int {
+    if loss > ab.PacketLossThreshold {
+        next := int(float64(rate) * ab.BackoffMultiplier)
+        if next < minRate { next = minRate }
+        return next
+    }
+    next := int(math.Ceil(float64(rate) * ab.RecoveryMultiplier))
+    if next > maxRate { next = maxRate }
+    return next
+}
+
+// scan runs naabu once over list at rate. With stats set naabu prints
+// progress to stderr, which is parsed into the returned batchStats.
+func scan(ctx context.Context, cfg *config.Config, list string, rate int, stats bool, write executil.LineHandler) (*batchStats, error) {
     // Determine scan type based on profile
     scanType := "c" // connect
     if cfg.Scan.Profile == "thorough" { scanType = "s" } // SYN
-    args := []string{"-list", inList, "-p", "-", "-s", scanType, "-rate",  fmtInt(cfg.Scan.NaabuRate), "-json"}
-    spec := executil.CmdSpec{Path: cfg.Tools.Paths["naabu"], Args: args, Timeout: 24 * time.Hour}
-    err = executil.RunJSONL(ctx, spec, func(b []byte) error { _, werr := f.Write(append(b, '\n')); return werr })
-    if err != nil { return err }
-    f.Close()
-    return os.Rename(outJSONL+".tmp", outJSONL)
+    args := []string{"-list", list, "-p", "-", "-s", scanType, "-rate",  fmtInt(rate), "-json"}
+    st := &batchStats{}
+    spec := executil.CmdSpec{Path: cfg.Tools.Paths["naabu"], Args: args, Timeout: 24 * time.Hour, Retry: cfg.Tools.Retry.Policy()}
+    if stats {
+        spec.Args = append(spec.Args, "-stats", "-si", "5")
+        spec.Stderr = func(b []byte) error { st.observe(string(b)); return nil }
+    }
+    return st, executil.RunJSONL(ctx, spec, write)
+}
+
+// batchStats accumulates loss signals from naabu's stderr: the last
+// "Packets: sent/total" and "Errors: n" counters of the -stats output, and
+// [WRN]/[ERR] lines reporting timeouts or dropped packets.
+type batchStats struct {
+    sent, errors, timeouts int
+}
+
+var (
+    packetsRe = regexp.MustCompile(`Packets:\s*(\d+)\s*/\s*\d+`)
+    errorsRe  = regexp.MustCompile(`Errors:\s*(\d+)`)
+    timeoutRe = regexp.MustCompile(`(?i)timeout|timed out|no buffer space|dropped|resource temporarily unavailable`)
+)
+
+func (s *batchStats) observe(line string) {
+    if m := packetsRe.FindStringSubmatch(line); m != nil {
+        s.sent, _ = strconv.Atoi(m[1])
+        if m := errorsRe.FindStringSubmatch(line); m != nil { s.errors, _ = strconv.Atoi(m[1]) }
+        return
+    }
+    isLog := strings.Contains(line, "[WRN]") || strings.Contains(line, "[ERR]")
+    if isLog && timeoutRe.MatchString(line) { s.timeouts++ }
+}
+
+// loss is the share of sent packets that errored or timed out. ok is false
+// when naabu reported no packet count, which says nothing about loss.
+func (s *batchStats) loss() (loss float64, ok bool) {
+    if s.sent == 0 { return 0, false }
+    bad := s.errors + s.timeouts
+    if bad >= s.sent { return 1, true }
+    return float64(bad) / float64(s.sent), true
+}
+
+func readList(path string) ([]string, error) {
+    f, err := os.Open(path)
+    if err != nil { return nil, err }
+    defer f.Close()
+    var out []string
+    sc := bufio.NewScanner(f)
+    for sc.Scan() {
+        if l := strings.TrimSpace(sc.Text()); l != "" { out = append(out, l) }
+    }
+    return out, sc.Err()
 }
 
 func fmtInt(i int) string { return fmt.Sprintf("%d", i) }
@@ -0,0 +1,129 @@
+package naabu
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+
+    "hermetica/internal/config"
+)
+
+func TestBatchStats(t *testing.T) {
+    for _, tc := range []struct {
+        name  string
+        lines []string
+        loss  float64
+        ok    bool
+    }{
+        {"no stats", []string{"[INF] Running CONNECT scan with non root privileges"}, 0, false},
+        {"clean", []string{"[0:00:05] | Port: 80 | IPs: 2 | Packets: 200/131070 (0%) | Errors: 0 | Found: 1"}, 0, true},
+        {"last counters win", []string{
+            "[0:00:05] | Packets: 100/2000 (5%) | Errors: 50",
+            "[0:00:10] | Packets: 1000/2000 (50%) | Errors: 100",
+        }, 0.1, true},
+        {"timeouts count", []string{
+            "[0:00:05] | Packets: 100/100 (100%) | Errors: 5",
+            "[WRN] Could not connect to 192.0.2.1:443: i/o timeout",
+            "[ERR] write: no buffer space available",
+            "[INF] timeout in an info line is not a loss signal",
+        }, 0.07, true},
+        {"all lost", []string{"Packets: 10/10 | Errors: 12"}, 1, true},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            var s batchStats
+            for _, l := range tc.lines { s.observe(l) }
+            loss, ok := s.loss()
+            if ok != tc.ok || loss != tc.loss { t.Errorf("loss = %v, %v, want %v, %v", loss, ok, tc.loss, tc.ok) }
+        })
+    }
+}
+
+func TestNextRate(t *testing.T) {
+    ab := config.AdaptiveBackoff{Enabled: true, PacketLossThreshold: 0.05, BackoffMultiplier: 0.5, RecoveryMultiplier: 1.25}
+    for _, tc := range []struct {
+        name string
+        rate int
+        loss float64
+        want int
+    }{
+        {"back off", 1000, 0.2, 500},
+        {"at threshold ramps up", 400, 0.05, 500},
+        {"ramp rounds up", 101, 0, 127},
+        {"floor at min_rate", 150, 0.5, 100},
+        {"cap at naabu_rate", 900, 0, 1000},
+        {"stays at the cap", 1000, 0.01, 1000},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            if got := nextRate(ab, tc.rate, 100, 1000, tc.loss); got != tc.want { t.Errorf("nextRate(%d, %v) = %d, want %d", tc.rate, tc.loss, got, tc.want) }
+        })
+    }
+}
+
+// fakeNaabu prints one open port per listed IP and, with -stats, reports
+// 20% errors whenever the rate is above 300.
+const fakeNaabu = `#!/bin/sh
+while [ $# -gt 0 ]; do
+    case "$1" in
+    -list) list=$2; shift ;;
+    -rate) rate=$2; shift ;;
+    -stats) stats=1 ;;
+    esac
+    shift
+done
+while read -r ip; do printf '{"ip":"%s","port":80}\n' "$ip"; done < "$list"
+if [ -n "$stats" ]; then
+    errs=0
+    [ "$rate" -gt 300 ] && errs=20
+    echo "[0:00:05] | Port: 80 | Packets: 100/100 (100%) | Errors: $errs | Found: 1" >&2
+fi
+`
+
+func TestRunAdaptiveRate(t *testing.T) {
+    dir := t.TempDir()
+    bin := filepath.Join(dir, "naabu")
+    if err := os.WriteFile(bin, []byte(fakeNaabu), 0o755); err != nil { t.Fatal(err) }
+    list := filepath.Join(dir, "ips.txt")
+    ips := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4", "192.0.2.5"}
+    if err := os.WriteFile(list, []byte(strings.Join(ips, "\n")+"\n"), 0o644); err != nil { t.Fatal(err) }
+    cfg := &config.Config{}
+    cfg.Tools.Paths = map[string]string{"naabu": bin}
+    cfg.Scan = config.Scan{NaabuRate: 1000, BatchSize: 1, AdaptiveBackoff: config.AdaptiveBackoff{
+        Enabled: true, PacketLossThreshold: 0.1, BackoffMultiplier: 0.5, RecoveryMultiplier: 1.5, MinRate: 100,
+    }}
+
+    for _, tc := range []struct {
+        name    string
+        enabled bool
+        resume  *Progress
+        want    []RateChange
+        covered int
+    }{
+        {"back off and ramp", true, nil, []RateChange{
+            {Batch: 0, Loss: 0.2, From: 1000, To: 500},
+            {Batch: 1, Loss: 0.2, From: 500, To: 250},
+            {Batch: 2, Loss: 0, From: 250, To: 375},
+            {Batch: 3, Loss: 0.2, From: 375, To: 187},
+            {Batch: 4, Loss: 0, From: 187, To: 281},
+        }, 5},
+        {"resume at the recorded rate", true, &Progress{Covered: ips[:3], Rate: 250}, []RateChange{
+            {Batch: 0, Loss: 0, From: 250, To: 375},
+            {Batch: 1, Loss: 0.2, From: 375, To: 187},
+        }, 5},
+        {"disabled", false, nil, nil, 5},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            cfg.Scan.AdaptiveBackoff.Enabled = tc.enabled
+            out := filepath.Join(t.TempDir(), "ports.jsonl")
+            var got []RateChange
+            var last Progress
+            opt := Options{OnRate: func(c RateChange) { got = append(got, c) }, OnBatch: func(p Progress) { last = p }, Resume: tc.resume}
+            if err := Run(context.Background(), cfg, list, out, opt); err != nil { t.Fatal(err) }
+            if !reflect.DeepEqual(got, tc.want) { t.Errorf("rate changes =\n%+v\nwant\n%+v", got, tc.want) }
+            if len(last.Covered) != tc.covered { t.Errorf("covered %v", last.Covered) }
+            if _, err := os.Stat(out); err != nil { t.Errorf("output: %v", err) }
+        })
+    }
+}
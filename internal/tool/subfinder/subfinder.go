@@ -17,12 +17,12 @@ func Run(ctx context.Context, cfg *config.Config, domain string, outJSONL string
     defer f.Close()
     args := []string{"-silent", "-all", "-d", domain, "-json"}
     if cfg.Tools.ProviderConfig != "" { args = append(args, "-pc", cfg.Tools.ProviderConfig) }
-    spec := executil.CmdSpec{Path: cfg.Tools.Paths["subfinder"], Args: args, Timeout: 60 * time.Minute}
+    spec := executil.CmdSpec{Path: cfg.Tools.Paths["subfinder"], Args: args, Timeout: 60 * time.Minute, Retry: cfg.Tools.Retry.Policy()}
     err = executil.RunJSONL(ctx, spec, func(b []byte) error {
         _, werr := f.Write(append(b, '\n'))
         return werr
     })
-    if err != nil { return err }
+    if err != nil { f.Close(); _ = os.Remove(outJSONL+".tmp"); return err }
     f.Close()
     return os.Rename(outJSONL+".tmp", outJSONL)
 }